@@ -0,0 +1,174 @@
+// Command hyphora-certs creates a self-signed CA and certificates signed
+// by it for the nodes and clients of a cluster, for setups without a PKI
+// of their own.
+//
+//	hyphora-certs ca
+//	hyphora-certs node -name node1 -hosts 10.0.0.1,node1.lan
+//	hyphora-certs client -name admin
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: hyphora-certs <command> [flags]
+
+Commands:
+  ca       create ca.pem and ca-key.pem
+  node     create <name>.pem and <name>-key.pem for a node, signed by the CA
+  client   create <name>.pem and <name>-key.pem for a client, signed by the CA
+
+Run hyphora-certs <command> -h for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "ca":
+		err = createCA(args)
+	case "node", "client":
+		err = createLeaf(cmd, args)
+	default:
+		fmt.Fprintf(os.Stderr, "hyphora-certs: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hyphora-certs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func createCA(args []string) error {
+	fs := flag.NewFlagSet("ca", flag.ExitOnError)
+	dir := fs.String("dir", ".", "write the files to this directory")
+	days := fs.Int("days", 3650, "validity of the CA in days")
+	name := fs.String("name", "Hyphora CA", "common name of the CA")
+	fs.Parse(args)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template(*name, *days)
+	if err != nil {
+		return err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	return write(*dir, "ca", der, key)
+}
+
+// createLeaf creates a node certificate, valid for serving and for
+// dialing other nodes, or a client one, valid for dialing only.
+func createLeaf(kind string, args []string) error {
+	fs := flag.NewFlagSet(kind, flag.ExitOnError)
+	dir := fs.String("dir", ".", "read the CA from and write the files to this directory")
+	days := fs.Int("days", 825, "validity of the certificate in days")
+	name := fs.String("name", "", "common name of the certificate, such as the node ID; also names the files")
+	hosts := fs.String("hosts", "127.0.0.1,localhost", "comma-separated IP addresses and DNS names the node is reached at")
+	fs.Parse(args)
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+
+	ca, err := tls.LoadX509KeyPair(filepath.Join(*dir, "ca.pem"), filepath.Join(*dir, "ca-key.pem"))
+	if err != nil {
+		return fmt.Errorf("load the CA (create it with hyphora-certs ca): %w", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template(*name, *days)
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if kind == "node" {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		tmpl.DNSNames = []string{*name}
+		for _, h := range strings.Split(*hosts, ",") {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else if h != "" && h != *name {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return write(*dir, *name, der, key)
+}
+
+func template(name string, days int) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, days),
+	}, nil
+}
+
+// write saves the certificate as <base>.pem and its key as
+// <base>-key.pem, refusing to replace either.
+func write(dir, base string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPath := filepath.Join(dir, base+".pem")
+	keyPath := filepath.Join(dir, base+"-key.pem")
+	for _, p := range []string{certPath, keyPath} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%s already exists", p)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s and %s\n", certPath, keyPath)
+	return nil
+}
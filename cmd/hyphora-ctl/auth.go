@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+func (x *ctl) auth(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: hyphora-ctl auth roles|put-role|delete-role|tokens|create-token|delete-token|whoami [args]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "roles":
+		return x.roles(args[1:])
+	case "put-role":
+		return x.putRole(args[1:])
+	case "delete-role":
+		return x.deleteRole(args[1:])
+	case "tokens":
+		return x.tokens(args[1:])
+	case "create-token":
+		return x.createToken(args[1:])
+	case "delete-token":
+		return x.deleteToken(args[1:])
+	case "whoami":
+		return x.whoami(args[1:])
+	}
+	return fmt.Errorf("unknown auth command %q", args[0])
+}
+
+func (x *ctl) roles(args []string) error {
+	parse(subcommand("auth roles", ""), args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	roles, err := x.c.Roles(ctx)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(roles)
+	}
+	rows := make([][]string, len(roles))
+	for i, r := range roles {
+		rules := make([]string, len(r.Rules))
+		for j, rule := range r.Rules {
+			rules[j] = formatRule(rule)
+		}
+		builtin := ""
+		if r.Builtin {
+			builtin = "*"
+		}
+		rows[i] = []string{r.Name, builtin, strings.Join(rules, " ")}
+	}
+	return x.table([]string{"NAME", "BUILTIN", "RULES"}, rows)
+}
+
+// parseRule reads a rule written as access:namespace:prefix, where the
+// namespace "*" covers every namespace and the prefix may be empty.
+func parseRule(s string) (api.Rule, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return api.Rule{}, fmt.Errorf("rule %q: want access:namespace:prefix", s)
+	}
+	rule := api.Rule{Access: parts[0], Namespace: parts[1], Prefix: parts[2]}
+	if rule.Namespace == "*" {
+		rule.Namespace = ""
+	}
+	return rule, nil
+}
+
+func formatRule(r api.Rule) string {
+	return r.Access + ":" + cmp.Or(r.Namespace, "*") + ":" + r.Prefix
+}
+
+func (x *ctl) putRole(args []string) error {
+	fs := subcommand("auth put-role", "<name> <access:namespace:prefix>...")
+	pos := parse(fs, args, 2, -1)
+	role := api.Role{Name: pos[0]}
+	for _, s := range pos[1:] {
+		rule, err := parseRule(s)
+		if err != nil {
+			return err
+		}
+		role.Rules = append(role.Rules, rule)
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.PutRole(ctx, role); err != nil {
+		return err
+	}
+	return x.done("OK")
+}
+
+func (x *ctl) deleteRole(args []string) error {
+	pos := parse(subcommand("auth delete-role", "<name>"), args, 1, 1)
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.DeleteRole(ctx, pos[0]); err != nil {
+		return err
+	}
+	return x.done("OK")
+}
+
+func (x *ctl) tokens(args []string) error {
+	parse(subcommand("auth tokens", ""), args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	tokens, err := x.c.Tokens(ctx)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(tokens)
+	}
+	rows := make([][]string, len(tokens))
+	for i, t := range tokens {
+		rows[i] = []string{t.ID, t.Name, strings.Join(t.Roles, ","), t.Created.Local().Format("2006-01-02 15:04:05")}
+	}
+	return x.table([]string{"ID", "NAME", "ROLES", "CREATED"}, rows)
+}
+
+func (x *ctl) createToken(args []string) error {
+	fs := subcommand("auth create-token", "<name>")
+	roles := fs.String("roles", "", "comma-separated roles of the token")
+	pos := parse(fs, args, 1, 1)
+	var names []string
+	if *roles != "" {
+		names = strings.Split(*roles, ",")
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	resp, err := x.c.CreateToken(ctx, pos[0], names)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(resp)
+	}
+	fmt.Fprintf(x.out, "Created token %s (%s). Its secret is shown only once:\n", resp.Token.ID, resp.Token.Name)
+	_, err = fmt.Fprintln(x.out, resp.Secret)
+	return err
+}
+
+func (x *ctl) deleteToken(args []string) error {
+	pos := parse(subcommand("auth delete-token", "<id>"), args, 1, 1)
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.DeleteToken(ctx, pos[0]); err != nil {
+		return err
+	}
+	return x.done("OK")
+}
+
+func (x *ctl) whoami(args []string) error {
+	parse(subcommand("auth whoami", ""), args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	resp, err := x.c.WhoAmI(ctx)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(resp)
+	}
+	if !resp.AuthEnabled {
+		return x.done("Authentication is disabled")
+	}
+	return x.table([]string{"NAME", "ROLES"}, [][]string{{resp.Name, strings.Join(resp.Roles, ",")}})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/AMS003010/Hyphora/internal/backup"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// backup saves a backup archive of the leader's state, checked once it is
+// on disk. It is not bound by -timeout, since large stores take a while.
+func (x *ctl) backup(args []string) error {
+	fs := subcommand("backup", "<file>")
+	since := fs.Uint64("since", 0, "save only the changes after this Raft index, the index of an earlier backup")
+	path := parse(fs, args, 1, 1)[0]
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	archive, err := x.c.Backup(ctx, *since)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, archive); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	m, err := readArchive(path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if x.output == "json" {
+		return x.json(m)
+	}
+	return x.done(fmt.Sprintf("Saved %s backup of index %d (%d bytes) to %s", m.Kind, m.Index, m.Size, path))
+}
+
+func readArchive(path string) (*backup.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, _, err := backup.Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// restore seeds the data directory of a new node from a full backup and
+// the incremental backups taken after it, in order. It works offline: the
+// node is started afterwards, forms a cluster of its own and restores
+// the state, and other nodes join it.
+func (x *ctl) restore(args []string) error {
+	fs := subcommand("restore", "<full backup> [incremental backup]...")
+	dataDir := fs.String("data-dir", "", "data directory of the new node, which must not hold a node yet")
+	id := fs.String("id", "", "Raft server ID the new node will be started with")
+	raftAddr := fs.String("raft-addr", "", "Raft address other nodes will reach the new node at")
+	paths := parse(fs, args, 1, -1)
+	if *dataDir == "" || *id == "" || *raftAddr == "" {
+		return fmt.Errorf("restore needs -data-dir, -id and -raft-addr")
+	}
+
+	var (
+		st    *raftnode.State
+		index uint64
+	)
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		m, data, err := backup.Read(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		switch {
+		case i == 0 && m.Kind != backup.Full:
+			return fmt.Errorf("%s: the first backup must be a full one, not %s", path, m.Kind)
+		case i == 0:
+			if st, err = raftnode.ReadState(bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		case m.Kind != backup.Incremental:
+			return fmt.Errorf("%s: only the first backup can be a full one", path)
+		case m.Since > index || m.Index < index:
+			return fmt.Errorf("%s: holds the changes from index %d to %d, which do not follow index %d", path, m.Since, m.Index, index)
+		default:
+			d, err := raftnode.ReadDelta(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			st.Apply(d)
+		}
+		index = m.Index
+	}
+	if err := raftnode.Seed(*dataDir, *id, *raftAddr, index, st); err != nil {
+		return err
+	}
+	return x.done(fmt.Sprintf("Seeded %s with %d keys at index %d; start the node as %s at %s", *dataDir, len(st.Data), index, *id, *raftAddr))
+}
+
+// exportKeys saves every key with its value as one JSON object per line. It
+// reads through the API, so leases, locks, queues and other internal
+// state are not included.
+func (x *ctl) exportKeys(args []string) error {
+	path := parse(subcommand("export", "<file>"), args, 1, 1)[0]
+	ctx, cancel := x.context()
+	defer cancel()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	after, n := "", 0
+	for {
+		items, more, err := x.c.Scan(ctx, "", after, 1000)
+		if err != nil {
+			return err
+		}
+		for _, kv := range items {
+			if err := enc.Encode(kv); err != nil {
+				return err
+			}
+		}
+		n += len(items)
+		if !more || len(items) == 0 {
+			break
+		}
+		after = items[len(items)-1].Key
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return x.done(fmt.Sprintf("Saved %d keys to %s", n, path))
+}
+
+// importKeys writes back the keys of an export, overwriting current values.
+// Keys added since the export are left alone.
+func (x *ctl) importKeys(args []string) error {
+	path := parse(subcommand("import", "<file>"), args, 1, 1)[0]
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	n := 0
+	for dec.More() {
+		var kv api.KeyValue
+		if err := dec.Decode(&kv); err != nil {
+			return fmt.Errorf("%s: entry %d: %w", path, n+1, err)
+		}
+		ctx, cancel := x.context()
+		err := x.c.Put(ctx, kv.Key, kv.Value)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("import %q: %w", kv.Key, err)
+		}
+		n++
+	}
+	return x.done(fmt.Sprintf("Imported %d keys from %s", n, path))
+}
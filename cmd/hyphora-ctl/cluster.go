@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (x *ctl) cluster(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: hyphora-ctl cluster members|status|add-peer|remove-peer|demote-peer|transfer-leader [args]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "members":
+		return x.members(args[1:])
+	case "status":
+		return x.status(args[1:])
+	case "add-peer":
+		return x.addPeer(args[1:])
+	case "remove-peer":
+		return x.removePeer(args[1:])
+	case "demote-peer":
+		return x.demotePeer(args[1:])
+	case "transfer-leader":
+		return x.transferLeader(args[1:])
+	}
+	return fmt.Errorf("unknown cluster command %q", args[0])
+}
+
+func (x *ctl) members(args []string) error {
+	parse(subcommand("cluster members", ""), args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	members, err := x.c.Members(ctx)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(members)
+	}
+	rows := make([][]string, len(members))
+	for i, m := range members {
+		leader := ""
+		if m.Leader {
+			leader = "*"
+		}
+		rows[i] = []string{m.ID, m.Suffrage, leader, m.Status, contact(m.LastContact), m.RaftAddress, m.HTTPAddress, m.GRPCAddress}
+	}
+	return x.table([]string{"ID", "SUFFRAGE", "LEADER", "STATUS", "LAST CONTACT", "RAFT", "HTTP", "GRPC"}, rows)
+}
+
+// contact shows how long ago a member was last heard from.
+func contact(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if d := time.Since(t); d >= time.Second {
+		return d.Round(time.Second).String() + " ago"
+	}
+	return "now"
+}
+
+func (x *ctl) status(args []string) error {
+	rest := parse(subcommand("cluster status", "[httpAddr]"), args, 0, 1)
+	addr := x.c.Endpoints()[0]
+	if len(rest) == 1 {
+		addr = rest[0]
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	st, err := x.c.Status(ctx, addr)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(st)
+	}
+	ready := "yes"
+	if !st.Ready {
+		ready = "no: " + st.NotReady
+	}
+	rows := [][]string{
+		{"id", st.ID},
+		{"state", st.State},
+		{"ready", ready},
+		{"leader", strings.TrimSpace(st.LeaderID + " " + st.LeaderAddress)},
+		{"term", st.Raft["term"]},
+		{"commit index", st.Raft["commit_index"]},
+		{"applied index", st.Raft["applied_index"]},
+		{"members", strconv.Itoa(len(st.Members))},
+		{"keys", strconv.Itoa(st.Store.Keys)},
+		{"data files", fmt.Sprintf("%d (%d bytes, %d dead)", st.Store.DataFiles, st.Store.DataBytes, st.Store.DeadBytes)},
+	}
+	if snap := st.LastSnapshot; snap != nil {
+		rows = append(rows, []string{"last snapshot", fmt.Sprintf("%s (index %d, %d bytes)", snap.ID, snap.Index, snap.Size)})
+	}
+	if c := st.LastCompaction; c != nil {
+		rows = append(rows, []string{"last compaction", fmt.Sprintf("%s (%dms, %d bytes reclaimed)", c.Time.Format(time.RFC3339), c.DurationMS, c.ReclaimedBytes)})
+	}
+	return x.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+func (x *ctl) addPeer(args []string) error {
+	fs := subcommand("cluster add-peer", "[-nonvoter] <id> <raftAddr>")
+	nonVoter := fs.Bool("nonvoter", false, "add a member that replicates without voting")
+	rest := parse(fs, args, 2, 2)
+	ctx, cancel := x.context()
+	defer cancel()
+	add := x.c.AddPeer
+	if *nonVoter {
+		add = x.c.AddNonVoter
+	}
+	if err := add(ctx, rest[0], rest[1]); err != nil {
+		return err
+	}
+	return x.done("Added " + rest[0])
+}
+
+func (x *ctl) removePeer(args []string) error {
+	fs := subcommand("cluster remove-peer", "[-force] <id>")
+	force := fs.Bool("force", false, "remove even if no reachable quorum of voters would remain")
+	id := parse(fs, args, 1, 1)[0]
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.RemovePeer(ctx, id, *force); err != nil {
+		return err
+	}
+	return x.done("Removed " + id)
+}
+
+func (x *ctl) demotePeer(args []string) error {
+	fs := subcommand("cluster demote-peer", "[-force] <id>")
+	force := fs.Bool("force", false, "demote even if no reachable quorum of voters would remain")
+	id := parse(fs, args, 1, 1)[0]
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.DemotePeer(ctx, id, *force); err != nil {
+		return err
+	}
+	return x.done("Demoted " + id)
+}
+
+func (x *ctl) transferLeader(args []string) error {
+	fs := subcommand("cluster transfer-leader", "[-force] [id]")
+	force := fs.Bool("force", false, "transfer even to a voter the leader cannot reach")
+	rest := parse(fs, args, 0, 1)
+	id := ""
+	if len(rest) == 1 {
+		id = rest[0]
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.TransferLeader(ctx, id, *force); err != nil {
+		return err
+	}
+	return x.done("Leadership transferred")
+}
+
+func (x *ctl) compact(args []string) error {
+	parse(subcommand("compact", ""), args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.Compact(ctx); err != nil {
+		return err
+	}
+	return x.done("Compaction completed")
+}
+
+func (x *ctl) snapshot(args []string) error {
+	fs := subcommand("snapshot", "[-truncate]")
+	truncate := fs.Bool("truncate", false, "drop every log entry the snapshot covers, not only those beyond the trailing logs")
+	parse(fs, args, 0, 0)
+	ctx, cancel := x.context()
+	defer cancel()
+	snap, err := x.c.Snapshot(ctx, *truncate)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(snap)
+	}
+	log := "empty"
+	if snap.FirstLogIndex != 0 {
+		log = fmt.Sprintf("%d-%d", snap.FirstLogIndex, snap.LastLogIndex)
+	}
+	return x.table([]string{"ID", "INDEX", "TERM", "SIZE", "LOG"}, [][]string{{
+		snap.ID, strconv.FormatUint(snap.Index, 10), strconv.FormatUint(snap.Term, 10), strconv.FormatInt(snap.Size, 10), log,
+	}})
+}
+
+func (x *ctl) snapshots(args []string) error {
+	rest := parse(subcommand("snapshots", "[httpAddr]"), args, 0, 1)
+	addr := x.c.Endpoints()[0]
+	if len(rest) == 1 {
+		addr = rest[0]
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	snaps, err := x.c.Snapshots(ctx, addr)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(snaps)
+	}
+	rows := make([][]string, 0, len(snaps))
+	for _, snap := range snaps {
+		rows = append(rows, []string{snap.ID, strconv.FormatUint(snap.Index, 10), strconv.FormatUint(snap.Term, 10), strconv.FormatInt(snap.Size, 10)})
+	}
+	return x.table([]string{"ID", "INDEX", "TERM", "SIZE"}, rows)
+}
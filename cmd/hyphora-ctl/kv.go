@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// get prints the raw value in table mode, so it can be redirected to a
+// file and written back with put -file.
+func (x *ctl) get(args []string) error {
+	fs := subcommand("get", "[-version N] <key>")
+	version := fs.Uint64("version", 0, "read the value as of this Raft index")
+	key := parse(fs, args, 1, 1)[0]
+	ctx, cancel := x.context()
+	defer cancel()
+	kv, err := x.c.GetVersion(ctx, key, *version)
+	if err != nil {
+		return err
+	}
+	if x.output == "json" {
+		return x.json(kv)
+	}
+	_, err = x.out.Write(kv.Value)
+	return err
+}
+
+func (x *ctl) put(args []string) error {
+	fs := subcommand("put", "[-file path] [-lease id] <key> [value]")
+	file := fs.String("file", "", "read the value from this file; '-' reads stdin")
+	lease := fs.Uint64("lease", 0, "attach the key to this lease")
+	rest := parse(fs, args, 1, 2)
+	var val []byte
+	switch {
+	case len(rest) == 2 && *file != "":
+		return fmt.Errorf("put: give the value either as an argument or with -file")
+	case len(rest) == 2:
+		val = []byte(rest[1])
+	case *file != "" && *file != "-":
+		var err error
+		if val, err = os.ReadFile(*file); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if val, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.PutWithLease(ctx, rest[0], val, *lease); err != nil {
+		return err
+	}
+	return x.done("OK")
+}
+
+func (x *ctl) del(args []string) error {
+	key := parse(subcommand("del", "<key>"), args, 1, 1)[0]
+	ctx, cancel := x.context()
+	defer cancel()
+	if err := x.c.Delete(ctx, key); err != nil {
+		return err
+	}
+	return x.done("OK")
+}
+
+func (x *ctl) scan(args []string) error {
+	fs := subcommand("scan", "[-after key] [-limit n] [prefix]")
+	after := fs.String("after", "", "start after this key")
+	limit := fs.Int("limit", 0, "list at most this many keys; 0 lists all")
+	keysOnly := fs.Bool("keys", false, "print keys without values")
+	rest := parse(fs, args, 0, 1)
+	prefix := ""
+	if len(rest) == 1 {
+		prefix = rest[0]
+	}
+	ctx, cancel := x.context()
+	defer cancel()
+
+	var items []api.KeyValue
+	for {
+		page := 1000
+		if *limit > 0 {
+			page = min(page, *limit-len(items))
+		}
+		batch, more, err := x.c.Scan(ctx, prefix, *after, page)
+		if err != nil {
+			return err
+		}
+		items = append(items, batch...)
+		if !more || len(batch) == 0 || (*limit > 0 && len(items) >= *limit) {
+			break
+		}
+		*after = batch[len(batch)-1].Key
+	}
+
+	if x.output == "json" {
+		return x.json(items)
+	}
+	if *keysOnly {
+		for _, kv := range items {
+			fmt.Fprintln(x.out, kv.Key)
+		}
+		return nil
+	}
+	rows := make([][]string, len(items))
+	for i, kv := range items {
+		rows[i] = []string{kv.Key, strconv.FormatUint(kv.Version, 10), printable(kv.Value)}
+	}
+	return x.table([]string{"KEY", "VERSION", "VALUE"}, rows)
+}
+
+// watch runs until interrupted; it is not bound by -timeout.
+func (x *ctl) watch(args []string) error {
+	rest := parse(subcommand("watch", "[prefix]"), args, 0, 1)
+	prefix := ""
+	if len(rest) == 1 {
+		prefix = rest[0]
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	w, err := x.c.Watch(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for {
+		ev, err := w.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == io.EOF {
+				return fmt.Errorf("watch ended by the node")
+			}
+			return err
+		}
+		if x.output == "json" {
+			err = x.jsonLine(ev)
+		} else {
+			_, err = fmt.Fprintf(x.out, "%s %s %d %s\n", ev.Type, ev.Key, ev.Version, printable(ev.Value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
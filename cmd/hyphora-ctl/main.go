@@ -0,0 +1,236 @@
+// Command hyphora-ctl reads and writes a Hyphora cluster and manages its
+// membership. It finds the leader from any of the given endpoints.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AMS003010/Hyphora/pkg/client"
+)
+
+const usage = `Usage: hyphora-ctl [flags] <command> [args]
+
+Commands:
+  get <key>                          print a value (-version N for an older one)
+  put <key> [value]                  write a value from the argument, -file or stdin
+  del <key>                          delete a key
+  scan [prefix]                      list keys under a prefix (-after, -limit)
+  watch [prefix]                     stream changes under a prefix
+  cluster members                    list the Raft members
+  cluster status [httpAddr]          show a node's state and readiness
+  cluster add-peer <id> <raftAddr>   add a voter (-nonvoter for a non-voter)
+  cluster remove-peer <id>           remove a member
+  cluster demote-peer <id>           turn a voter into a non-voter
+  cluster transfer-leader [id]       move leadership to another voter
+  compact                            compact the leader's data files
+  snapshot                           take a Raft snapshot on the leader and
+                                     truncate its log (-truncate for all of it)
+  snapshots [httpAddr]               list the snapshots a node keeps
+  backup <file>                      save a backup archive of the leader's
+                                     state (-since N for the changes after N)
+  restore <full> [incremental]...    seed a new node's data directory from
+                                     backups, offline (-data-dir, -id, -raft-addr)
+  export <file>                      save every key and value as JSON lines
+  import <file>                      write the keys saved by export
+  auth roles                         list the roles
+  auth put-role <name> <rule>...     create or replace a role; a rule is
+                                     access:namespace:prefix, e.g. read:kv:app/
+  auth delete-role <name>            delete a role
+  auth tokens                        list the tokens
+  auth create-token <name>           create a token (-roles a,b) and print it
+  auth delete-token <id>             delete a token
+  auth whoami                        show the name and roles of -token
+
+Flags:
+`
+
+// ctl carries the global flags to the commands.
+type ctl struct {
+	c       *client.Client
+	output  string
+	timeout time.Duration
+	out     io.Writer
+}
+
+func main() {
+	endpoints := flag.String("endpoints", envOr("HYPHORA_ENDPOINTS", "127.0.0.1:8081"), "comma-separated HTTP addresses of cluster nodes ($HYPHORA_ENDPOINTS)")
+	output := flag.String("o", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", 10*time.Second, "deadline of each request, including retries")
+	cacert := flag.String("cacert", os.Getenv("HYPHORA_CACERT"), "connect over HTTPS, trusting this CA ($HYPHORA_CACERT)")
+	cert := flag.String("cert", os.Getenv("HYPHORA_CERT"), "client certificate, for nodes that verify clients ($HYPHORA_CERT)")
+	key := flag.String("key", os.Getenv("HYPHORA_KEY"), "private key of -cert ($HYPHORA_KEY)")
+	token := flag.String("token", os.Getenv("HYPHORA_TOKEN"), "bearer token, for clusters with auth enabled ($HYPHORA_TOKEN)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+	tlsConfig, err := clientTLS(*cacert, *cert, *key)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	c, err := client.New(client.Config{Endpoints: strings.Split(*endpoints, ","), TLS: tlsConfig, Token: *token})
+	if err != nil {
+		fatalf("%v", err)
+	}
+	x := &ctl{c: c, output: *output, timeout: *timeout, out: os.Stdout}
+
+	commands := map[string]func([]string) error{
+		"get":       x.get,
+		"put":       x.put,
+		"del":       x.del,
+		"scan":      x.scan,
+		"watch":     x.watch,
+		"cluster":   x.cluster,
+		"compact":   x.compact,
+		"snapshot":  x.snapshot,
+		"snapshots": x.snapshots,
+		"backup":    x.backup,
+		"restore":   x.restore,
+		"export":    x.exportKeys,
+		"import":    x.importKeys,
+		"auth":      x.auth,
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "hyphora-ctl: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := cmd(flag.Args()[1:]); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "hyphora-ctl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// clientTLS returns the TLS configuration given by the flags, or nil for
+// plain HTTP.
+func clientTLS(cacert, cert, key string) (*tls.Config, error) {
+	if cacert == "" {
+		if cert != "" {
+			return nil, errors.New("-cert needs -cacert")
+		}
+		return nil, nil
+	}
+	pem, err := os.ReadFile(cacert)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{RootCAs: x509.NewCertPool()}
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in %s", cacert)
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// context bounds one command by the -timeout flag and by Ctrl-C.
+func (x *ctl) context() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := context.WithTimeout(ctx, x.timeout)
+	return ctx, func() { cancel(); stop() }
+}
+
+// subcommand returns the flag set of one command; args describes its
+// positional arguments in the usage message.
+func subcommand(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hyphora-ctl %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of a command and exits with its usage unless it
+// has between min and max positional arguments; max < 0 means no limit.
+func parse(fs *flag.FlagSet, args []string, min, max int) []string {
+	fs.Parse(args)
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+// table writes rows under a header, aligned in columns.
+func (x *ctl) table(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(x.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (x *ctl) json(v any) error {
+	enc := json.NewEncoder(x.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// jsonLine writes v on a line of its own, for streams.
+func (x *ctl) jsonLine(v any) error {
+	return json.NewEncoder(x.out).Encode(v)
+}
+
+// printable shows a value in a table: as text when it is readable, quoted
+// otherwise.
+func printable(val []byte) string {
+	if !utf8.Valid(val) {
+		return fmt.Sprintf("%q", val)
+	}
+	for _, r := range string(val) {
+		if !unicode.IsPrint(r) {
+			return fmt.Sprintf("%q", val)
+		}
+	}
+	return string(val)
+}
+
+// done reports a successful command that has nothing to print.
+func (x *ctl) done(msg string) error {
+	if x.output == "json" {
+		return x.json(struct{}{})
+	}
+	_, err := fmt.Fprintln(x.out, msg)
+	return err
+}
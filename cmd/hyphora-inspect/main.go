@@ -9,7 +9,11 @@ import (
 	"os"
 )
 
-const recordHeaderSize = 1 + 8 + 8
+const (
+	recordHeaderSize = 1 + 8 + 8
+	flagVersioned    = 0x2
+	versionSize      = 8
+)
 
 func main() {
 	filePath := flag.String("file", "", "Path to .db file to inspect")
@@ -50,6 +54,17 @@ func main() {
 		keyLen := int64(binary.BigEndian.Uint64(hdr[1:9]))
 		valLen := int64(binary.BigEndian.Uint64(hdr[9:17]))
 
+		var version uint64
+		var versionLen int64
+		if flags&flagVersioned == flagVersioned {
+			vbuf := make([]byte, versionSize)
+			if _, err := io.ReadFull(r, vbuf); err != nil {
+				panic(err)
+			}
+			version = binary.BigEndian.Uint64(vbuf)
+			versionLen = versionSize
+		}
+
 		key := make([]byte, keyLen)
 		if _, err := io.ReadFull(r, key); err != nil {
 			panic(err)
@@ -67,10 +82,10 @@ func main() {
 			valStr = valStr[0:4]
 		}
 
-		fmt.Printf("offset=%d flags=%02x key=%q value=%q\n",
-			offset, flags, string(key), valStr)
+		fmt.Printf("offset=%d flags=%02x version=%d key=%q value=%q\n",
+			offset, flags, version, string(key), valStr)
 
-		offset += recordHeaderSize + keyLen + valLen
+		offset += recordHeaderSize + versionLen + keyLen + valLen
 	}
 
 	fmt.Println()
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/filerepl"
+	"github.com/AMS003010/Hyphora/internal/filesync"
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/hashicorp/raft"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `Usage: hyphora-node [flags] [<dataDir> <raftAddr> <nodeID> <httpPort> [grpcPort]]
+       hyphora-node recover <peers.json> [flags]
+
+Settings are read from the file given by -config ($HYPHORA_CONFIG), in YAML
+or JSON, then from the environment, then from flags, each overriding the
+previous. Every flag has an environment variable: -raft-addr is
+$HYPHORA_RAFT_ADDR. The positional form sets -data-dir, -raft-addr, -id,
+-http-addr and -grpc-addr.
+
+hyphora-node recover brings back a cluster that lost its quorum; run it
+without arguments for details.
+
+Flags:
+`
+
+// Config holds every setting of a node, as found in its configuration file.
+type Config struct {
+	ID            string `yaml:"id"`
+	DataDir       string `yaml:"data_dir"`
+	HTTPAddr      string `yaml:"http_addr"`
+	GRPCAddr      string `yaml:"grpc_addr"`
+	RedisAddr     string `yaml:"redis_addr"`
+	MemcachedAddr string `yaml:"memcached_addr"`
+
+	Raft       RaftConfig       `yaml:"raft"`
+	Cluster    ClusterConfig    `yaml:"cluster"`
+	Compaction CompactionConfig `yaml:"compaction"`
+	TLS        TLSConfig        `yaml:"tls"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Replicate  ReplicateConfig  `yaml:"replicate"`
+	Sync       SyncConfig       `yaml:"sync"`
+	Readiness  ReadinessConfig  `yaml:"readiness"`
+	Log        LogConfig        `yaml:"log"`
+	Shutdown   ShutdownConfig   `yaml:"shutdown"`
+}
+
+type RaftConfig struct {
+	Addr               string        `yaml:"addr"`
+	Advertise          string        `yaml:"advertise"`
+	HeartbeatTimeout   time.Duration `yaml:"heartbeat_timeout"`
+	ElectionTimeout    time.Duration `yaml:"election_timeout"`
+	LeaderLeaseTimeout time.Duration `yaml:"leader_lease_timeout"`
+	CommitTimeout      time.Duration `yaml:"commit_timeout"`
+	SnapshotInterval   time.Duration `yaml:"snapshot_interval"`
+	SnapshotThreshold  uint64        `yaml:"snapshot_threshold"`
+	SnapshotRetain     int           `yaml:"snapshot_retain"`
+	TrailingLogs       uint64        `yaml:"trailing_logs"`
+	MaxPool            int           `yaml:"max_pool"`
+	TransportTimeout   time.Duration `yaml:"transport_timeout"`
+}
+
+type ClusterConfig struct {
+	Join            []string `yaml:"join"`
+	Bootstrap       bool     `yaml:"bootstrap"`
+	BootstrapExpect int      `yaml:"bootstrap_expect"`
+	// Token is presented by this node to the others, to join and to
+	// forward writes to the leader. With auth enabled, every node accepts
+	// it as an admin token, so it must be the same on all of them.
+	Token string `yaml:"token"`
+}
+
+// TLSConfig turns on TLS for every listener and mutual TLS between nodes
+// when CertFile is set. The certificate must be valid for both server and
+// client authentication, since nodes dial each other with it.
+type TLSConfig struct {
+	CertFile      string   `yaml:"cert_file"`
+	KeyFile       string   `yaml:"key_file"`
+	CAFile        string   `yaml:"ca_file"`
+	VerifyClients bool     `yaml:"verify_clients"`
+	PeerNames     []string `yaml:"peer_names"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != ""
+}
+
+// AuthConfig requires every API request to carry a bearer token when
+// Enabled. Tokens are accepted from this list and from those created
+// through the API.
+type AuthConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Tokens  []TokenConfig `yaml:"tokens"`
+}
+
+type TokenConfig struct {
+	Name  string   `yaml:"name"`
+	Token string   `yaml:"token"`
+	Roles []string `yaml:"roles"`
+}
+
+// ReplicateConfig confines the files /replicate may read to Roots. It is
+// disabled without roots.
+type ReplicateConfig struct {
+	Roots        []string `yaml:"roots"`
+	MaxSize      int64    `yaml:"max_size"`
+	RelativeKeys bool     `yaml:"relative_keys"`
+}
+
+// SyncConfig mirrors directories through the store: each published
+// directory is rescanned every Interval and written under its prefix, and
+// each subscribed prefix is materialised into its directory.
+type SyncConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	MaxSize   int64         `yaml:"max_size"`
+	Publish   []SyncDir     `yaml:"publish"`
+	Subscribe []SyncDir     `yaml:"subscribe"`
+}
+
+type SyncDir struct {
+	Prefix string `yaml:"prefix"`
+	Dir    string `yaml:"dir"`
+}
+
+func (c SyncConfig) syncer() filesync.Config {
+	cfg := filesync.Config{Interval: c.Interval, MaxSize: c.MaxSize}
+	for _, d := range c.Publish {
+		cfg.Publish = append(cfg.Publish, filesync.Dir{Dir: d.Dir, Prefix: d.Prefix})
+	}
+	for _, d := range c.Subscribe {
+		cfg.Subscribe = append(cfg.Subscribe, filesync.Dir{Dir: d.Dir, Prefix: d.Prefix})
+	}
+	return cfg
+}
+
+// ReadinessConfig sets when /readyz reports the node ready: with a known
+// leader if RequireLeader, and at most MaxApplyLag committed entries left
+// to apply.
+type ReadinessConfig struct {
+	RequireLeader bool   `yaml:"require_leader"`
+	MaxApplyLag   uint64 `yaml:"max_apply_lag"`
+}
+
+func (c ReadinessConfig) service() service.Readiness {
+	return service.Readiness{RequireLeader: c.RequireLeader, MaxApplyLag: c.MaxApplyLag}
+}
+
+// LogConfig sets the format of the log, text or json, and the lowest
+// level logged: debug, info, warn or error.
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+func (c LogConfig) logging() logging.Config {
+	return logging.Config{Format: c.Format, Level: c.Level}
+}
+
+// ShutdownConfig bounds a shutdown on SIGINT or SIGTERM: requests in
+// flight get DrainTimeout to finish, and the whole shutdown, Raft and the
+// stores included, gets Timeout before the process exits anyway.
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// CompactionConfig is the auto-compaction policy: every Interval, the
+// leader compacts once there are more than MaxFiles data files.
+type CompactionConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxFiles int           `yaml:"max_files"`
+}
+
+func defaultConfig() *Config {
+	rc := raft.DefaultConfig()
+	return &Config{
+		HTTPAddr: ":8081",
+		Raft: RaftConfig{
+			Addr:               "127.0.0.1:9001",
+			HeartbeatTimeout:   rc.HeartbeatTimeout,
+			ElectionTimeout:    rc.ElectionTimeout,
+			LeaderLeaseTimeout: rc.LeaderLeaseTimeout,
+			CommitTimeout:      rc.CommitTimeout,
+			SnapshotInterval:   rc.SnapshotInterval,
+			SnapshotThreshold:  rc.SnapshotThreshold,
+			SnapshotRetain:     1,
+			TrailingLogs:       rc.TrailingLogs,
+			MaxPool:            3,
+			TransportTimeout:   10 * time.Second,
+		},
+		Compaction: CompactionConfig{Interval: 5 * time.Minute, MaxFiles: 3},
+		Replicate:  ReplicateConfig{MaxSize: filerepl.DefaultMaxSize},
+		Sync:       SyncConfig{Interval: 2 * time.Second, MaxSize: filerepl.DefaultMaxSize},
+		Readiness:  ReadinessConfig{RequireLeader: true, MaxApplyLag: service.DefaultMaxApplyLag},
+		Log:        LogConfig{Format: "text", Level: "info"},
+		Shutdown:   ShutdownConfig{DrainTimeout: 10 * time.Second, Timeout: 30 * time.Second},
+	}
+}
+
+// flagSet binds a flag to every setting of c, and -config to path.
+func (c *Config) flagSet(path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("hyphora-node", flag.ExitOnError)
+	fs.StringVar(path, "config", "", "read settings from this YAML or JSON file")
+	fs.StringVar(&c.ID, "id", c.ID, "Raft server ID of this node")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory of the Raft log and data files")
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "serve the HTTP API on this address")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "serve gRPC on this address (default: the HTTP port + 1000)")
+	fs.StringVar(&c.RedisAddr, "redis", c.RedisAddr, "serve the Redis protocol on this address, e.g. :6379")
+	fs.StringVar(&c.MemcachedAddr, "memcached", c.MemcachedAddr, "serve the memcached text protocol on this address, e.g. :11211")
+
+	fs.StringVar(&c.Raft.Addr, "raft-addr", c.Raft.Addr, "Raft transport bind address")
+	fs.StringVar(&c.Raft.Advertise, "raft-advertise", c.Raft.Advertise, "Raft address other nodes dial, if not -raft-addr")
+	fs.DurationVar(&c.Raft.HeartbeatTimeout, "raft-heartbeat-timeout", c.Raft.HeartbeatTimeout, "time without contact from the leader before a follower stands for election")
+	fs.DurationVar(&c.Raft.ElectionTimeout, "raft-election-timeout", c.Raft.ElectionTimeout, "time without a leader before a candidate starts a new election")
+	fs.DurationVar(&c.Raft.LeaderLeaseTimeout, "raft-leader-lease-timeout", c.Raft.LeaderLeaseTimeout, "time a leader stays leader without contact from a quorum")
+	fs.DurationVar(&c.Raft.CommitTimeout, "raft-commit-timeout", c.Raft.CommitTimeout, "time without an entry before the leader sends a heartbeat")
+	fs.DurationVar(&c.Raft.SnapshotInterval, "raft-snapshot-interval", c.Raft.SnapshotInterval, "how often to check whether to take a snapshot")
+	fs.Uint64Var(&c.Raft.SnapshotThreshold, "raft-snapshot-threshold", c.Raft.SnapshotThreshold, "log entries since the last snapshot that trigger a new one")
+	fs.IntVar(&c.Raft.SnapshotRetain, "raft-snapshot-retain", c.Raft.SnapshotRetain, "snapshots kept on disk")
+	fs.Uint64Var(&c.Raft.TrailingLogs, "raft-trailing-logs", c.Raft.TrailingLogs, "log entries kept behind a snapshot for slow followers")
+	fs.IntVar(&c.Raft.MaxPool, "raft-max-pool", c.Raft.MaxPool, "connections kept open to each peer")
+	fs.DurationVar(&c.Raft.TransportTimeout, "raft-transport-timeout", c.Raft.TransportTimeout, "deadline of each Raft transport I/O")
+
+	fs.Var((*listFlag)(&c.Cluster.Join), "join", "comma-separated HTTP addresses of nodes to join the cluster through")
+	fs.BoolVar(&c.Cluster.Bootstrap, "bootstrap", c.Cluster.Bootstrap, "form a new single-node cluster if this node has no state")
+	fs.IntVar(&c.Cluster.BootstrapExpect, "bootstrap-expect", c.Cluster.BootstrapExpect, "form a new cluster once this many nodes given by -join are up")
+	fs.StringVar(&c.Cluster.Token, "cluster-token", c.Cluster.Token, "token nodes present to each other; with -auth, accepted as an admin token")
+
+	fs.DurationVar(&c.Compaction.Interval, "compaction-interval", c.Compaction.Interval, "how often the leader checks whether to compact")
+	fs.IntVar(&c.Compaction.MaxFiles, "compaction-max-files", c.Compaction.MaxFiles, "data files beyond which the leader compacts")
+
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "serve TLS and authenticate to other nodes with this certificate")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "private key of -tls-cert")
+	fs.StringVar(&c.TLS.CAFile, "tls-ca", c.TLS.CAFile, "CA that signs the certificates of nodes and clients")
+	fs.BoolVar(&c.TLS.VerifyClients, "tls-verify-clients", c.TLS.VerifyClients, "require API clients to present a certificate signed by -tls-ca")
+	fs.Var((*listFlag)(&c.TLS.PeerNames), "tls-peer-names", "comma-separated certificate names accepted from other nodes (default: any signed by -tls-ca)")
+	fs.BoolVar(&c.Auth.Enabled, "auth", c.Auth.Enabled, "require a bearer token on every API request")
+	fs.Var((*listFlag)(&c.Replicate.Roots), "replicate-roots", "comma-separated directories /replicate may read files from (default: none, disabling it)")
+	fs.Int64Var(&c.Replicate.MaxSize, "replicate-max-size", c.Replicate.MaxSize, "largest file /replicate reads, in bytes")
+	fs.Var((*syncDirsFlag)(&c.Sync.Publish), "sync-publish", "comma-separated prefix=dir pairs: mirror each local dir under its key prefix")
+	fs.Var((*syncDirsFlag)(&c.Sync.Subscribe), "sync-subscribe", "comma-separated prefix=dir pairs: materialise each key prefix into its local dir")
+	fs.DurationVar(&c.Sync.Interval, "sync-interval", c.Sync.Interval, "time between two scans of a published directory")
+	fs.Int64Var(&c.Sync.MaxSize, "sync-max-size", c.Sync.MaxSize, "largest file published, in bytes; larger files are skipped")
+	fs.BoolVar(&c.Readiness.RequireLeader, "ready-require-leader", c.Readiness.RequireLeader, "report the node ready on /readyz only while it knows a leader")
+	fs.Uint64Var(&c.Readiness.MaxApplyLag, "ready-max-apply-lag", c.Readiness.MaxApplyLag, "committed entries a node ready on /readyz may have left to apply")
+	fs.BoolVar(&c.Replicate.RelativeKeys, "replicate-relative-keys", c.Replicate.RelativeKeys, "key replicated files by their path relative to their root instead of their base name")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log as text or json")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "lowest level logged: debug, info, warn or error")
+	fs.DurationVar(&c.Shutdown.DrainTimeout, "shutdown-drain-timeout", c.Shutdown.DrainTimeout, "time requests in flight get to finish on shutdown")
+	fs.DurationVar(&c.Shutdown.Timeout, "shutdown-timeout", c.Shutdown.Timeout, "time a shutdown may take before the process exits anyway")
+
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// listFlag is a comma-separated list; setting it replaces the list.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = nil
+	if s != "" {
+		*l = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// syncDirsFlag is a comma-separated list of prefix=dir pairs.
+type syncDirsFlag []SyncDir
+
+func (l *syncDirsFlag) String() string {
+	pairs := make([]string, len(*l))
+	for i, d := range *l {
+		pairs[i] = d.Prefix + "=" + d.Dir
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *syncDirsFlag) Set(s string) error {
+	*l = nil
+	if s == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		prefix, dir, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q: want prefix=dir", pair)
+		}
+		*l = append(*l, SyncDir{Prefix: prefix, Dir: dir})
+	}
+	return nil
+}
+
+// envName is the environment variable of a flag.
+func envName(flagName string) string {
+	return "HYPHORA_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig builds the configuration from the defaults, the file, the
+// environment, the flags and the positional arguments in args, and
+// validates it.
+func loadConfig(args []string) (*Config, error) {
+	// Parse the command line first to find the file; its flags are
+	// applied again after the file and the environment.
+	var path string
+	cli := defaultConfig().flagSet(&path)
+	cli.Parse(args)
+
+	c := defaultConfig()
+	path = cmp.Or(path, os.Getenv("HYPHORA_CONFIG"))
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	fs := c.flagSet(new(string))
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && f.Name != "config" && err == nil {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("%s: %w", envName(f.Name), e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	cli.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			fs.Set(f.Name, f.Value.String())
+		}
+	})
+
+	switch pos := cli.Args(); len(pos) {
+	case 0:
+	case 4, 5:
+		c.DataDir, c.Raft.Addr, c.ID = pos[0], pos[1], pos[2]
+		c.HTTPAddr = ":" + pos[3]
+		c.GRPCAddr = ""
+		if len(pos) == 5 {
+			c.GRPCAddr = ":" + pos[4]
+		}
+	default:
+		cli.Usage()
+		os.Exit(2)
+	}
+	if c.GRPCAddr == "" {
+		c.GRPCAddr = defaultGRPCAddr(c.HTTPAddr)
+	}
+	return c, c.validate()
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// defaultGRPCAddr puts the gRPC listener 1000 ports above HTTP.
+func defaultGRPCAddr(httpAddr string) string {
+	host, port, err := net.SplitHostPort(httpAddr)
+	if err != nil {
+		return ":9090"
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return ":9090"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+1000))
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.ID != "", "id is required")
+	check(c.DataDir != "", "data_dir is required")
+	for name, addr := range map[string]string{
+		"http_addr":      c.HTTPAddr,
+		"grpc_addr":      c.GRPCAddr,
+		"raft.addr":      c.Raft.Addr,
+		"redis_addr":     c.RedisAddr,
+		"memcached_addr": c.MemcachedAddr,
+	} {
+		if addr != "" {
+			_, _, err := net.SplitHostPort(addr)
+			check(err == nil, "%s %q: %v", name, addr, err)
+		}
+	}
+	if c.Raft.Advertise == "" {
+		host, _, _ := net.SplitHostPort(c.Raft.Addr)
+		ip := net.ParseIP(host)
+		check(host != "" && (ip == nil || !ip.IsUnspecified()), "raft.advertise is required when raft.addr %q is not an address other nodes can dial", c.Raft.Addr)
+	}
+	if err := raft.ValidateConfig(c.node().RaftConfig()); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.Raft.SnapshotRetain >= 1, "raft.snapshot_retain must be at least 1")
+	check(c.Raft.MaxPool >= 1, "raft.max_pool must be at least 1")
+	check(c.Raft.TransportTimeout > 0, "raft.transport_timeout must be positive")
+	check(c.Cluster.BootstrapExpect >= 0, "cluster.bootstrap_expect must not be negative")
+	check(c.Cluster.BootstrapExpect <= 1 || len(c.Cluster.Join) > 0, "cluster.bootstrap_expect needs the other nodes in cluster.join")
+	check(!c.Cluster.Bootstrap || c.Cluster.BootstrapExpect <= 1, "cluster.bootstrap and cluster.bootstrap_expect are exclusive")
+	if t := c.TLS; t.enabled() || t.KeyFile != "" || t.CAFile != "" || t.VerifyClients || len(t.PeerNames) > 0 {
+		check(t.CertFile != "" && t.KeyFile != "" && t.CAFile != "", "tls needs cert_file, key_file and ca_file")
+	}
+	for i, t := range c.Auth.Tokens {
+		check(t.Name != "" && t.Token != "", "auth.tokens[%d] needs a name and a token", i)
+	}
+	if c.Auth.Enabled {
+		check(c.MemcachedAddr == "", "memcached_addr cannot be used with auth: the memcached protocol has no authentication")
+		check(len(c.Cluster.Join) == 0 || c.Cluster.Token != "", "auth with cluster.join needs cluster.token")
+	}
+	check(c.Replicate.MaxSize > 0, "replicate.max_size must be positive")
+	for _, dir := range c.Replicate.Roots {
+		info, err := os.Stat(dir)
+		check(err == nil && info.IsDir(), "replicate.roots: %s is not a directory", dir)
+	}
+	check(c.Sync.Interval > 0, "sync.interval must be positive")
+	check(c.Sync.MaxSize > 0, "sync.max_size must be positive")
+	for _, d := range c.Sync.Publish {
+		info, err := os.Stat(d.Dir)
+		check(err == nil && info.IsDir(), "sync.publish: %s is not a directory", d.Dir)
+	}
+	if err := c.Sync.syncer().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("sync: %w", err))
+	}
+	check(c.Compaction.Interval > 0, "compaction.interval must be positive")
+	check(c.Compaction.MaxFiles >= 1, "compaction.max_files must be at least 1")
+	check(c.Shutdown.DrainTimeout > 0, "shutdown.drain_timeout must be positive")
+	check(c.Shutdown.Timeout > c.Shutdown.DrainTimeout, "shutdown.timeout must be longer than shutdown.drain_timeout")
+	if err := c.Log.logging().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// node returns the settings of the Raft node.
+func (c *Config) node() raftnode.Config {
+	_, httpPort, _ := net.SplitHostPort(c.HTTPAddr)
+	return raftnode.Config{
+		DataDir:  c.DataDir,
+		BindAddr: c.Raft.Addr,
+		ID:       c.ID,
+		HTTPPort: httpPort,
+		// Without seeds there is no cluster to join, so the node forms
+		// its own, as it always did.
+		Bootstrap:          c.Cluster.Bootstrap || c.Cluster.BootstrapExpect == 1 || len(c.Cluster.Join) == 0,
+		AdvertiseAddr:      c.Raft.Advertise,
+		HeartbeatTimeout:   c.Raft.HeartbeatTimeout,
+		ElectionTimeout:    c.Raft.ElectionTimeout,
+		LeaderLeaseTimeout: c.Raft.LeaderLeaseTimeout,
+		CommitTimeout:      c.Raft.CommitTimeout,
+		SnapshotInterval:   c.Raft.SnapshotInterval,
+		SnapshotThreshold:  c.Raft.SnapshotThreshold,
+		TrailingLogs:       c.Raft.TrailingLogs,
+		SnapshotRetain:     c.Raft.SnapshotRetain,
+		MaxPool:            c.Raft.MaxPool,
+		TransportTimeout:   c.Raft.TransportTimeout,
+	}
+}
+
+// advertised returns the address other nodes reach listen at: its own
+// host if it has one, the host of the Raft address otherwise.
+func (c *Config) advertised(listen string) string {
+	host, port, _ := net.SplitHostPort(listen)
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listen
+	}
+	host, _, err := net.SplitHostPort(cmp.Or(c.Raft.Advertise, c.Raft.Addr))
+	if err != nil || host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// String renders the effective configuration as YAML, without secrets.
+func (c *Config) String() string {
+	redacted := *c
+	if redacted.Cluster.Token != "" {
+		redacted.Cluster.Token = "<redacted>"
+	}
+	redacted.Auth.Tokens = make([]TokenConfig, len(c.Auth.Tokens))
+	for i, t := range c.Auth.Tokens {
+		t.Token = "<redacted>"
+		redacted.Auth.Tokens[i] = t
+	}
+	out, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
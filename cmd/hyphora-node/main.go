@@ -1,303 +1,275 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/filerepl"
+	"github.com/AMS003010/Hyphora/internal/filesync"
+	"github.com/AMS003010/Hyphora/internal/httpapi"
+	"github.com/AMS003010/Hyphora/internal/join"
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/memcache"
+	"github.com/AMS003010/Hyphora/internal/metrics"
 	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/resp"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/AMS003010/Hyphora/internal/tlsutil"
 	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
-	if len(os.Args) < 5 {
-		fmt.Println("Usage: hyphora-node <dataDir> <raftAddr> <nodeID> <httpPort>")
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		recoverCluster(os.Args[2:])
 	}
-
-	dataDir := os.Args[1]
-	bindAddr := os.Args[2]
-	raftID := os.Args[3]
-	httpPort := os.Args[4]
-
-	node, err := raftnode.NewNode(dataDir, bindAddr, raftID, httpPort)
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatalf("failed to start node: %v", err)
+		log.Fatalf("invalid configuration: %v", err)
 	}
-
-	go startAutoCompaction(node, dataDir)
-
-	http.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "POST required", http.StatusMethodNotAllowed)
-			return
-		}
-		if node.Raft.State() != raft.Leader {
-			http.Error(w, "Only leader accepts /put", http.StatusForbidden)
-			return
-		}
-		var req struct {
-			Key   string `json:"key"`
-			Value string `json:"value"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if err := node.Apply("PUT", req.Key, []byte(req.Value)); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	})
-
-	http.HandleFunc("/put-file", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "POST required", http.StatusMethodNotAllowed)
-			return
-		}
-		if node.Raft.State() != raft.Leader {
-			http.Error(w, "Only leader accepts /put", http.StatusForbidden)
-			return
-		}
-		var req struct {
-			Key   string `json:"key"`
-			Value string `json:"value"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		data, _ := base64.StdEncoding.DecodeString(req.Value)
-		if err := node.Apply("PUT", req.Key, data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	})
-
-	http.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Query().Get("key")
-		val, err := node.Get(key)
+	logger, level, err := logging.New(os.Stderr, cfg.Log.logging())
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	logger = logger.With("node_id", cfg.ID)
+	slog.SetDefault(logger)
+	logger.Info("configuration", "config", cfg.String())
+
+	var certs *tlsutil.Store
+	if cfg.TLS.enabled() {
+		certs, err = tlsutil.Load(tlsutil.Config{
+			CertFile:      cfg.TLS.CertFile,
+			KeyFile:       cfg.TLS.KeyFile,
+			CAFile:        cfg.TLS.CAFile,
+			VerifyClients: cfg.TLS.VerifyClients,
+			PeerNames:     cfg.TLS.PeerNames,
+		})
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		w.Write(val)
-	})
-
-	http.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			Key string `json:"key"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if err := node.Apply("DEL", req.Key, nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	})
-
-	http.HandleFunc("/addpeer", func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("id")
-		addr := r.URL.Query().Get("addr")
-		if id == "" || addr == "" {
-			http.Error(w, "id and addr required", http.StatusBadRequest)
-			return
-		}
-		if node == nil || node.Raft == nil {
-			http.Error(w, "raft not initialized", http.StatusInternalServerError)
-			return
-		}
-		if node.Raft.State() != raft.Leader {
-			http.Error(w, "not leader; send addpeer to leader", http.StatusBadRequest)
-			return
+			fatal(logger, "failed to load TLS certificates", err)
 		}
-		fut := node.Raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, time.Second*0)
-		if err := fut.Error(); err != nil {
-			http.Error(w, "failed to add peer: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		fmt.Fprintf(w, "Peer %s (%s) added successfully\n", id, addr)
-	})
+	}
 
-	http.HandleFunc("/compact", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		if node == nil || node.Raft == nil {
-			http.Error(w, "raft not initialized", http.StatusInternalServerError)
-			return
-		}
-		if node.Raft.State() != raft.Leader {
-			http.Error(w, "not leader; send compact to leader", http.StatusBadRequest)
-			return
-		}
-		if err := node.Store.InitiateCompaction(); err != nil {
-			http.Error(w, fmt.Sprintf("Compaction failed: %v", err), http.StatusInternalServerError)
-			return
-		}
-		fut := node.Raft.Barrier(5 * time.Second)
-		if err := fut.Error(); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to ensure Raft consistency post-compaction: %v", err), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "Compaction completed")
-	})
+	// Set up metrics first, so they cover Raft from its start.
+	sink, err := metrics.Setup()
+	if err != nil {
+		fatal(logger, "failed to set up metrics", err)
+	}
 
-	http.HandleFunc("/replicate", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "POST required", http.StatusMethodNotAllowed)
-			return
-		}
+	nodeCfg := cfg.node()
+	nodeCfg.TLS = certs
+	nodeCfg.Logger = logger
+	node, err := raftnode.NewNode(nodeCfg)
+	if err != nil {
+		fatal(logger, "failed to start node", err)
+	}
+	svc := service.New(node)
+	svc.SetReadiness(cfg.Readiness.service())
+	sink.Collect(node.ReportMetrics)
 
-		var req struct {
-			Path string `json:"path"`
+	grpcLis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		fatal(logger, "failed to listen for gRPC", err)
+	}
+	var grpcOpts []grpc.ServerOption
+	if certs != nil {
+		svc.SetTLS(certs)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(certs.ServerConfig())))
+	}
+	var static []auth.StaticToken
+	for _, t := range cfg.Auth.Tokens {
+		static = append(static, auth.StaticToken{Name: t.Name, Token: t.Token, Roles: t.Roles})
+	}
+	if cfg.Cluster.Token != "" {
+		static = append(static, auth.StaticToken{Name: "cluster", Token: cfg.Cluster.Token, Roles: []string{"admin"}})
+	}
+	svc.SetAuth(auth.New(node, cfg.Auth.Enabled, static), cfg.Cluster.Token)
+	grpcOpts = append(grpcOpts,
+		grpc.ChainUnaryInterceptor(svc.UnaryLogger, service.UnaryMetrics, svc.UnaryInterceptor),
+		grpc.ChainStreamInterceptor(svc.StreamLogger, service.StreamMetrics, svc.StreamInterceptor))
+	grpcServer := grpc.NewServer(grpcOpts...)
+	svc.RegisterGRPC(grpcServer)
+	ctx, stop := context.WithCancel(context.Background())
+	run := &running{logger: logger, level: level, certs: certs, grpc: grpcServer, svc: svc, node: node, stop: stop}
+	// A listener that stops by itself shuts the node down.
+	failed := make(chan error, 4)
+	go func() {
+		if err := grpcServer.Serve(grpcLis); err != nil {
+			failed <- fmt.Errorf("gRPC server: %w", err)
+		}
+	}()
+
+	if cfg.RedisAddr != "" {
+		l, err := net.Listen("tcp", cfg.RedisAddr)
+		if err != nil {
+			fatal(logger, "failed to listen for Redis clients", err)
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-		if req.Path == "" {
-			http.Error(w, "'path' required", http.StatusBadRequest)
-			return
+		if certs != nil {
+			l = tls.NewListener(l, certs.ServerConfig())
 		}
+		run.redis = resp.NewServer(svc)
+		go func() {
+			if err := run.redis.Serve(l); err != nil {
+				failed <- fmt.Errorf("Redis listener: %w", err)
+			}
+		}()
+	}
 
-		data, err := os.ReadFile(req.Path)
+	if cfg.MemcachedAddr != "" {
+		l, err := net.Listen("tcp", cfg.MemcachedAddr)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Cannot read file: %v", err), http.StatusBadRequest)
-			return
-		}
-
-		filename := filepath.Base(req.Path)
-
-		if node.Raft.State() == raft.Leader {
-			if err := node.Apply("PUT", filename, data); err != nil {
-				http.Error(w, "Raft apply failed: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]any{
-				"status": "replicated",
-				"key":    filename,
-				"size":   len(data),
-				"from":   "leader",
-			})
-			return
+			fatal(logger, "failed to listen for memcached clients", err)
 		}
-
-		leaderAddr := node.Raft.Leader()
-		if leaderAddr == "" {
-			http.Error(w, "No leader", http.StatusServiceUnavailable)
-			return
+		if certs != nil {
+			l = tls.NewListener(l, certs.ServerConfig())
 		}
+		run.memcached = memcache.NewServer(svc)
+		go func() {
+			if err := run.memcached.Serve(l); err != nil {
+				failed <- fmt.Errorf("memcached listener: %w", err)
+			}
+		}()
+	}
 
-		leaderIP := strings.Split(string(leaderAddr), ":")[0]
-		leaderRaftPort := strings.Split(string(leaderAddr), ":")[1]
-		port, _ := strconv.Atoi(leaderRaftPort)
-		leaderHTTPPort := strconv.Itoa(port - 920)
-		leaderURL := "http://" + leaderIP + ":" + leaderHTTPPort + "/put-file"
-
-		payload := map[string]string{
-			"key":   filename,
-			"value": base64.StdEncoding.EncodeToString(data),
-		}
-		body, _ := json.Marshal(payload)
+	node.Advertise(raftnode.NodeMeta{
+		ID:       cfg.ID,
+		RaftAddr: cmp.Or(cfg.Raft.Advertise, cfg.Raft.Addr),
+		HTTPAddr: cfg.advertised(cfg.HTTPAddr),
+		GRPCAddr: cfg.advertised(cfg.GRPCAddr),
+	})
 
-		resp, err := http.Post(leaderURL, "application/json", bytes.NewBuffer(body))
-		if err != nil {
-			http.Error(w, "Failed to reach leader: "+err.Error(), http.StatusBadGateway)
-			return
+	if len(cfg.Cluster.Join) > 0 {
+		jc := join.Config{Seeds: cfg.Cluster.Join, Expect: cfg.Cluster.BootstrapExpect, Token: cfg.Cluster.Token}
+		if certs != nil {
+			jc.TLS = certs.ClientConfig()
 		}
-		json.NewEncoder(w).Encode(map[string]any{
-			"status": "replicated",
-			"key":    filename,
-			"size":   len(data),
-			"from":   "follower",
-		})
-		defer resp.Body.Close()
+		go func() {
+			if err := join.Run(ctx, node, jc); err != nil && ctx.Err() == nil {
+				logger.Error("failed to join the cluster", "err", err)
+			}
+		}()
+	}
 
-		w.WriteHeader(resp.StatusCode)
-		io.Copy(w, resp.Body)
-	})
+	go startAutoCompaction(ctx, logger.With("component", "compaction"), node, cfg.DataDir, cfg.Compaction)
 
-	http.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Query().Get("key")
-		if key == "" {
-			http.Error(w, "Missing 'key' query param", http.StatusBadRequest)
-			return
-		}
+	syncer := filesync.New(svc, cfg.Sync.syncer())
+	go syncer.Run(ctx)
 
-		data, err := node.Get(key)
-		if err != nil {
-			http.Error(w, "File not found: "+err.Error(), http.StatusNotFound)
-			return
+	logger.Info("node started", "raft_addr", cfg.Raft.Addr, "grpc_addr", cfg.GRPCAddr)
+	files, err := filerepl.New(filerepl.Config{Roots: cfg.Replicate.Roots, MaxSize: cfg.Replicate.MaxSize, RelativeKeys: cfg.Replicate.RelativeKeys})
+	if err != nil {
+		fatal(logger, "failed to open the replicate roots", err)
+	}
+	handler := httpapi.New(svc)
+	handler.SetFiles(files)
+	handler.SetSync(syncer)
+	handler.SetMetrics(sink)
+	run.http = &http.Server{Addr: cfg.HTTPAddr, Handler: handler, ErrorLog: slog.NewLogLogger(logger.With("component", "http").Handler(), slog.LevelWarn)}
+	go func() {
+		var err error
+		if certs != nil {
+			run.http.TLSConfig = certs.ServerConfig()
+			err = run.http.ListenAndServeTLS("", "")
+		} else {
+			err = run.http.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- fmt.Errorf("HTTP server: %w", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	code := 0
+wait:
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				run.reload()
+				continue
+			}
+			logger.Info("shutting down", "signal", sig.String())
+			break wait
+		case err := <-failed:
+			logger.Error("shutting down after a listener stopped", "err", err)
+			code = 1
+			break wait
 		}
+	}
+	if err := run.shutdown(cfg.Shutdown); err != nil {
+		logger.Error("shutdown failed", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("shut down")
+	os.Exit(code)
+}
 
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", key))
-		w.Write(data)
-	})
-
-	log.Printf("Hyphora node started at %s with ID %s", bindAddr, raftID)
-	log.Fatal(http.ListenAndServe(":"+httpPort, nil))
+// fatal logs msg with err and exits.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
 }
 
-func shouldCompact(dataDir string) (bool, error) {
+func shouldCompact(dataDir string, maxFiles int) (bool, error) {
 	files, err := filepath.Glob(filepath.Join(dataDir, "bitcask", "data-*.db"))
 	if err != nil {
 		return false, fmt.Errorf("failed to list data files: %w", err)
 	}
 
-	const maxFiles = 3
 	return len(files) > maxFiles, nil
 }
 
-func startAutoCompaction(node *raftnode.Node, dataDir string) {
-	ticker := time.NewTicker(5 * time.Minute)
+func startAutoCompaction(ctx context.Context, logger *slog.Logger, node *raftnode.Node, dataDir string, policy CompactionConfig) {
+	ticker := time.NewTicker(policy.Interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		if node == nil || node.Raft == nil {
-			log.Println("Auto-compaction: node or Raft not initialized")
+			logger.Warn("node or Raft not initialized")
 			continue
 		}
 		if node.Raft.State() != raft.Leader {
 			continue
 		}
 
-		needCompaction, err := shouldCompact(dataDir)
+		needCompaction, err := shouldCompact(dataDir, policy.MaxFiles)
 		if err != nil {
-			log.Printf("Auto-compaction: failed to check compaction need: %v", err)
+			logger.Error("failed to check whether to compact", "err", err)
 			continue
 		}
 		if !needCompaction {
 			continue
 		}
 
-		log.Println("Auto-compaction: starting")
-		if err := node.Store.InitiateCompaction(); err != nil {
-			log.Printf("Auto-compaction: failed: %v", err)
+		logger.Info("auto-compaction starting")
+		if err := node.Compact(); err != nil {
+			logger.Error("auto-compaction failed", "err", err)
 			continue
 		}
 		fut := node.Raft.Barrier(5 * time.Second)
 		if err := fut.Error(); err != nil {
-			log.Printf("Auto-compaction: failed to ensure Raft consistency: %v", err)
+			logger.Error("failed to ensure Raft consistency after compaction", "err", err)
 			continue
 		}
-		log.Println("Auto-compaction: completed successfully")
+		logger.Info("auto-compaction completed")
 	}
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+const recoverUsage = `Usage: hyphora-node recover <peers.json> [flags]
+
+Recovers a cluster that lost its quorum, and so cannot elect a leader to
+remove the lost servers. Stop every surviving node, then run recover on each
+with the same peers file and the node's usual configuration; it replaces the
+node's Raft configuration with the servers of the file and exits. Start the
+nodes afterwards. The peers file lists the servers of the recovered cluster:
+
+  [
+    {"id": "node1", "address": "10.0.0.1:9001", "non_voter": false}
+  ]
+
+Recovering a single survivor, the one with the highest last index, and
+joining fresh nodes to it afterwards is the simplest way back.
+
+Flags are those of hyphora-node; only the settings that locate the node's
+state, its ID and logging matter.
+`
+
+const recoverWarning = `WARNING: recovery forces a new cluster configuration and may lose data.
+  - Writes the lost servers committed but this node never received are lost.
+  - Writes this node received but the cluster never committed are committed.
+  - Every surviving node must be stopped and recovered with the same peers
+    file before any of them starts.
+  - Servers left out of the peers file must never be started with their old
+    data; wipe it before they join again.
+`
+
+// recoverCluster runs "hyphora-node recover" and exits.
+func recoverCluster(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprint(os.Stderr, recoverUsage)
+		os.Exit(2)
+	}
+	peers, err := raft.ReadConfigJSON(args[0])
+	if err != nil {
+		log.Fatalf("invalid peers file %s: %v", args[0], err)
+	}
+	cfg, err := loadConfig(args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	logger, _, err := logging.New(os.Stderr, cfg.Log.logging())
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	logger = logger.With("node_id", cfg.ID)
+	slog.SetDefault(logger)
+	nodeCfg := cfg.node()
+	nodeCfg.Logger = logger
+
+	last, err := raftnode.LastEntry(nodeCfg)
+	if err != nil {
+		fatal(logger, "failed to read the node's state", err)
+	}
+	fmt.Fprint(os.Stderr, recoverWarning)
+	fmt.Fprintf(os.Stderr, "\nNode %s holds entries up to index %d (term %d). Recovering with:\n", cfg.ID, last.Index, last.Term)
+	for _, srv := range peers.Servers {
+		fmt.Fprintf(os.Stderr, "  %s at %s (%s)\n", srv.ID, srv.Address, srv.Suffrage)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if err := raftnode.Recover(nodeCfg, peers.Servers); err != nil {
+		fatal(logger, "recovery failed", err)
+	}
+	logger.Warn("cluster configuration recovered; start the node to resume", "index", last.Index, "servers", len(peers.Servers))
+	os.Exit(0)
+}
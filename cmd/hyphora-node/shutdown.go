@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/memcache"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/resp"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/AMS003010/Hyphora/internal/tlsutil"
+	"google.golang.org/grpc"
+)
+
+// running is what a node runs, to be stopped in order on shutdown.
+type running struct {
+	logger *slog.Logger
+	level  *slog.LevelVar
+	certs  *tlsutil.Store
+
+	http      *http.Server
+	grpc      *grpc.Server
+	redis     *resp.Server
+	memcached *memcache.Server
+
+	svc  *service.Service
+	node *raftnode.Node
+	// stop ends the background work: joining, sync and compaction.
+	stop context.CancelFunc
+}
+
+// shutdown stops the node within cfg.Timeout, or exits with an error.
+// Requests in flight get cfg.DrainTimeout to finish.
+func (r *running) shutdown(cfg ShutdownConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.stopAll(ctx, cfg.DrainTimeout) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		r.logger.Error("shutdown timed out", "timeout", cfg.Timeout)
+		os.Exit(1)
+		return nil
+	}
+}
+
+// stopAll stops accepting requests, drains those in flight, stops the
+// background work, hands leadership over, shuts Raft down and closes the
+// stores.
+func (r *running) stopAll(ctx context.Context, drain time.Duration) error {
+	r.svc.Drain()
+	dctx, cancel := context.WithTimeout(ctx, drain)
+	defer cancel()
+	// Requests still running when the drain times out are cut off; that
+	// does not fail the shutdown.
+	var wg sync.WaitGroup
+	warn := func(what string, err error) {
+		if err != nil {
+			r.logger.Warn("failed to drain "+what, "err", err)
+		}
+	}
+	wg.Go(func() {
+		if err := r.http.Shutdown(dctx); err != nil {
+			r.http.Close()
+			warn("HTTP requests", err)
+		}
+	})
+	wg.Go(func() {
+		stopped := make(chan struct{})
+		go func() {
+			r.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-dctx.Done():
+			r.grpc.Stop()
+			warn("gRPC calls", dctx.Err())
+		}
+	})
+	if r.redis != nil {
+		wg.Go(func() { warn("Redis connections", r.redis.Shutdown(dctx)) })
+	}
+	if r.memcached != nil {
+		wg.Go(func() { warn("memcached connections", r.memcached.Shutdown(dctx)) })
+	}
+	wg.Wait()
+	r.logger.Info("stopped serving requests")
+
+	r.stop()
+	return errors.Join(r.node.Shutdown(ctx), r.svc.Close())
+}
+
+// reload rereads the configuration and applies the settings that can
+// change while the node runs: the log level and the readiness criteria.
+// It reloads the certificates too. Other settings need a restart.
+func (r *running) reload() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		r.logger.Error("failed to reload the configuration, keeping the previous one", "err", err)
+	} else {
+		level, _ := logging.ParseLevel(cfg.Log.Level)
+		r.level.Set(level)
+		r.svc.SetReadiness(cfg.Readiness.service())
+		r.logger.Info("configuration reloaded")
+	}
+	if r.certs == nil {
+		return
+	}
+	if err := r.certs.Reload(); err != nil {
+		r.logger.Error("TLS reload failed, keeping the previous certificates", "err", err)
+		return
+	}
+	r.logger.Info("TLS certificates reloaded")
+}
@@ -0,0 +1,251 @@
+// Package auth authenticates bearer tokens and decides what they may do.
+//
+// A token carries roles, and a role's rules grant read, write or admin
+// access to the resources of a namespace whose names start with a prefix.
+// Tokens come from the node's configuration or from the replicated auth
+// namespace; roles are built in or replicated, so every node enforces the
+// same policy.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+// Access is a level of access; each level includes the ones below it.
+type Access int
+
+const (
+	// None is enough for operations open to every authenticated caller.
+	None Access = iota
+	Read
+	Write
+	Admin
+)
+
+var accessNames = []string{"none", "read", "write", "admin"}
+
+func (a Access) String() string {
+	return accessNames[a]
+}
+
+func ParseAccess(s string) (Access, error) {
+	i := slices.Index(accessNames, s)
+	if i <= 0 {
+		return None, fmt.Errorf("unknown access %q; want read, write or admin", s)
+	}
+	return Access(i), nil
+}
+
+// Namespaces of the resources rules apply to. Cluster covers membership,
+// maintenance and the management of roles and tokens; its resources have
+// no name.
+const (
+	KV      = "kv"
+	Lease   = "lease"
+	Lock    = "lock"
+	Queue   = "queue"
+	Index   = "index"
+	Cluster = "cluster"
+)
+
+var namespaces = []string{KV, Lease, Lock, Queue, Index, Cluster}
+
+var (
+	ErrUnauthenticated  = errors.New("missing or unknown token")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidRole      = errors.New("invalid role")
+)
+
+// The errors are preserved when a follower forwards a command.
+func init() {
+	raftnode.RegisterForwardError("unauthenticated", ErrUnauthenticated)
+	raftnode.RegisterForwardError("permission_denied", ErrPermissionDenied)
+	raftnode.RegisterForwardError("invalid_role", ErrInvalidRole)
+}
+
+// Builtin are the roles every cluster has. They cannot be redefined.
+var Builtin = map[string]raftnode.Role{
+	"admin":     {Name: "admin", Rules: []raftnode.Rule{{Access: "admin"}}},
+	"readwrite": {Name: "readwrite", Rules: []raftnode.Rule{{Namespace: KV, Access: "write"}, {Namespace: Lease, Access: "write"}, {Namespace: Lock, Access: "write"}, {Namespace: Queue, Access: "write"}, {Namespace: Index, Access: "write"}}},
+	"readonly":  {Name: "readonly", Rules: []raftnode.Rule{{Namespace: KV, Access: "read"}, {Namespace: Index, Access: "read"}}},
+}
+
+// ValidateRole checks that r may be stored.
+func ValidateRole(r raftnode.Role) error {
+	if r.Name == "" || strings.ContainsAny(r.Name, "/,") {
+		return fmt.Errorf("%w: a role needs a name without '/' or ','", ErrInvalidRole)
+	}
+	if _, ok := Builtin[r.Name]; ok {
+		return fmt.Errorf("%w: %s is built in", ErrInvalidRole, r.Name)
+	}
+	for _, rule := range r.Rules {
+		if rule.Namespace != "" && !slices.Contains(namespaces, rule.Namespace) {
+			return fmt.Errorf("%w: unknown namespace %q; want one of %s", ErrInvalidRole, rule.Namespace, strings.Join(namespaces, ", "))
+		}
+		if _, err := ParseAccess(rule.Access); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRole, err)
+		}
+	}
+	return nil
+}
+
+// StaticToken is a token defined in a node's configuration.
+type StaticToken struct {
+	Name  string
+	Token string
+	Roles []string
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name  string
+	Roles []string
+
+	secret string
+}
+
+// Secret returns the token the caller authenticated with, so a request
+// passed on to the leader is made on the caller's behalf.
+func (id *Identity) Secret() string {
+	return id.secret
+}
+
+// Need is the access a request needs to one resource.
+type Need struct {
+	Namespace string
+	Name      string
+	Access    Access
+}
+
+type Authorizer struct {
+	node    *raftnode.Node
+	enabled bool
+	static  []StaticToken
+}
+
+// New returns an Authorizer checking tokens against static and the tokens
+// replicated through node. Unless enabled, every request is allowed.
+func New(node *raftnode.Node, enabled bool, static []StaticToken) *Authorizer {
+	return &Authorizer{node: node, enabled: enabled, static: static}
+}
+
+func (a *Authorizer) Enabled() bool {
+	return a.enabled
+}
+
+// HashToken returns the hex SHA-256 of a token's secret, under which the
+// token is stored.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the identity of the token secret.
+func (a *Authorizer) Authenticate(secret string) (*Identity, error) {
+	if secret == "" {
+		return nil, ErrUnauthenticated
+	}
+	for _, t := range a.static {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(secret)) == 1 {
+			return &Identity{Name: t.Name, Roles: t.Roles, secret: secret}, nil
+		}
+	}
+	if t, ok := a.node.LookupToken(HashToken(secret)); ok {
+		return &Identity{Name: t.Name, Roles: t.Roles, secret: secret}, nil
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Check returns nil if the caller in ctx may access the resource name of
+// namespace ns at the level need. Names are matched by prefix, so asking
+// for a prefix, as a scan does, checks access to every name under it.
+func (a *Authorizer) Check(ctx context.Context, ns, name string, need Access) error {
+	if !a.enabled {
+		return nil
+	}
+	id := FromContext(ctx)
+	if id == nil {
+		return ErrUnauthenticated
+	}
+	// System keys hold the cluster's own state, token hashes among it;
+	// through the key-value APIs only cluster admins reach them.
+	if ns == KV && strings.HasPrefix(name, bitcask.SystemPrefix) {
+		ns, name, need = Cluster, "", Admin
+	}
+	if need == None || a.allowed(id, ns, name, need) {
+		return nil
+	}
+	if ns == Cluster {
+		return fmt.Errorf("%w: %s needs %s access to the cluster", ErrPermissionDenied, id.Name, need)
+	}
+	return fmt.Errorf("%w: %s needs %s access to %s %q", ErrPermissionDenied, id.Name, need, ns, name)
+}
+
+// CheckAll checks every need of a request and fails on the first denied.
+func (a *Authorizer) CheckAll(ctx context.Context, needs []Need) error {
+	for _, n := range needs {
+		if err := a.Check(ctx, n.Namespace, n.Name, n.Access); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) allowed(id *Identity, ns, name string, need Access) bool {
+	for _, roleName := range id.Roles {
+		role, ok := Builtin[roleName]
+		if !ok {
+			if role, ok = a.node.Role(roleName); !ok {
+				continue
+			}
+		}
+		for _, rule := range role.Rules {
+			access, _ := ParseAccess(rule.Access)
+			if access >= need && (rule.Namespace == "" || rule.Namespace == ns) && strings.HasPrefix(name, rule.Prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleExists reports whether name is a built-in or replicated role.
+func (a *Authorizer) RoleExists(name string) bool {
+	if _, ok := Builtin[name]; ok {
+		return true
+	}
+	_, ok := a.node.Role(name)
+	return ok
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller stored by WithIdentity, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// BearerToken extracts the secret of an "Authorization: Bearer" value.
+func BearerToken(header string) string {
+	scheme, secret, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(secret)
+}
@@ -0,0 +1,124 @@
+// Package backup reads and writes backup archives. An archive is a tar
+// stream of two files: the state of a node as of a Raft index, as encoded
+// by its FSM snapshot, followed by a manifest that describes it and
+// carries its checksum, so an archive can be streamed as it is produced.
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FormatVersion is the version of the archive layout written by Write.
+const FormatVersion = 1
+
+const (
+	stateName    = "state"
+	manifestName = "manifest.json"
+)
+
+const (
+	// Full archives hold the whole state.
+	Full = "full"
+	// Incremental archives hold the keys written after Since, and the
+	// names of every key, so deletions can be replayed.
+	Incremental = "incremental"
+)
+
+var ErrCorrupt = errors.New("backup archive is corrupt")
+
+// Manifest describes an archive.
+type Manifest struct {
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+	// Index and Term are those of the last Raft entry reflected in the
+	// state.
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	// Since is the index an incremental archive holds the changes after.
+	Since   uint64    `json:"since,omitempty"`
+	NodeID  string    `json:"node_id"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Write writes an archive of the size bytes of state read from r. It fills
+// in the version, size and checksum of m.
+func Write(w io.Writer, m *Manifest, size int64, r io.Reader) error {
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{Name: stateName, Mode: 0o600, Size: size, ModTime: m.Created}); err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(tw, io.TeeReader(io.LimitReader(r, size), h))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("state is %d bytes, want %d", n, size)
+	}
+	m.Version, m.Size, m.SHA256 = FormatVersion, size, hex.EncodeToString(h.Sum(nil))
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0o600, Size: int64(len(manifest)), ModTime: m.Created}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Read reads an archive and returns its manifest and state, after checking
+// the state against the checksum of the manifest.
+func Read(r io.Reader) (*Manifest, []byte, error) {
+	tr := tar.NewReader(r)
+	var (
+		state []byte
+		m     *Manifest
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		switch hdr.Name {
+		case stateName:
+			if state, err = io.ReadAll(tr); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+		case manifestName:
+			m = new(Manifest)
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, nil, fmt.Errorf("%w: manifest: %v", ErrCorrupt, err)
+			}
+		}
+	}
+	switch {
+	case m == nil:
+		return nil, nil, fmt.Errorf("%w: no manifest", ErrCorrupt)
+	case m.Version != FormatVersion:
+		return nil, nil, fmt.Errorf("backup archive version %d is not supported", m.Version)
+	case state == nil:
+		return nil, nil, fmt.Errorf("%w: no state", ErrCorrupt)
+	case int64(len(state)) != m.Size:
+		return nil, nil, fmt.Errorf("%w: state is %d bytes, manifest says %d", ErrCorrupt, len(state), m.Size)
+	}
+	sum := sha256.Sum256(state)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return m, state, nil
+}
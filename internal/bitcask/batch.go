@@ -0,0 +1,36 @@
+package bitcask
+
+import "time"
+
+// BatchOp is a single write in a batch. Value is ignored for deletes.
+type BatchOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// WriteBatch applies ops in order while holding the write lock, so readers
+// observe either none or all of them. Each op is versioned like PutAt and
+// DeleteAt.
+func (bc *Bitcask) WriteBatch(ops []BatchOp, index uint64, ts time.Time) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, op := range ops {
+		if op.Delete {
+			if err := bc.delete(op.Key); err != nil {
+				return err
+			}
+			if err := bc.recordVersion(op.Key, nil, true, index, ts); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bc.putVersion(op.Key, op.Value, index); err != nil {
+			return err
+		}
+		if err := bc.recordVersion(op.Key, op.Value, false, index, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
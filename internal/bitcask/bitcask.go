@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -22,14 +24,20 @@ const (
 
 const maxFileSize = 128 << 20 // 128 MB
 
+// SystemPrefix marks keys used internally by the store and the replicated
+// state machine. They are hidden from Keys but survive compaction and
+// snapshots like any other key.
+const SystemPrefix = "\x00"
+
 var (
 	ErrKeyNotFound = errors.New("key not found")
 )
 
 type entry struct {
-	fileId int64
-	offset int64
-	size   int64
+	fileId  int64
+	offset  int64
+	size    int64
+	version uint64
 }
 
 type Bitcask struct {
@@ -41,6 +49,14 @@ type Bitcask struct {
 	currFile   *os.File
 	currOffset int64
 	bufw       *bufio.Writer
+	retention  map[string]RetentionPolicy
+	history    map[string][]uint64
+	// live is the size of the records the keydir points to; the rest of
+	// the data files is reclaimed by compaction.
+	live           int64
+	lastCompaction Compaction
+	closed         bool
+	log            *slog.Logger
 }
 
 func extractFileId(path string) int64 {
@@ -56,27 +72,60 @@ func (bc *Bitcask) Keys() []string {
 	defer bc.mu.RUnlock()
 	keys := make([]string, 0, len(bc.keydir))
 	for k := range bc.keydir {
+		if strings.HasPrefix(k, SystemPrefix) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// KeysWithPrefix returns the sorted keys starting with prefix, including
+// system keys when prefix selects them.
+func (bc *Bitcask) KeysWithPrefix(prefix string) []string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	var keys []string
+	for k := range bc.keydir {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if prefix == "" && strings.HasPrefix(k, SystemPrefix) {
+			continue
+		}
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	return keys
 }
 
+// Close flushes buffered writes, fsyncs the data files and closes them.
+// Every file is closed even if an earlier one fails.
 func (bc *Bitcask) Close() error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	if bc.closed {
+		return nil
+	}
+	bc.closed = true
+	var errs []error
 	if bc.bufw != nil {
 		if err := bc.bufw.Flush(); err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("flush: %w", err))
 		}
 	}
 	for _, f := range bc.files {
 		if f != nil {
-			f.Sync()
-			f.Close()
+			if err := f.Sync(); err != nil {
+				errs = append(errs, fmt.Errorf("sync %s: %w", f.Name(), err))
+			}
+			if err := f.Close(); err != nil {
+				errs = append(errs, err)
+			}
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (bc *Bitcask) ScanFile(fid int64, file *os.File) error {
@@ -97,6 +146,16 @@ func (bc *Bitcask) ScanFile(fid int64, file *os.File) error {
 		keyLen := int64(binary.BigEndian.Uint64(hdr[1:9]))
 		valLen := int64(binary.BigEndian.Uint64(hdr[9:17]))
 
+		var version uint64
+		if flags&flagVersioned == flagVersioned {
+			vbuf := make([]byte, versionSize)
+			if _, err := io.ReadFull(r, vbuf); err != nil {
+				return err
+			}
+			version = binary.BigEndian.Uint64(vbuf)
+			offIncr += versionSize
+		}
+
 		key := make([]byte, keyLen)
 		if _, err := io.ReadFull(r, key); err != nil {
 			return err
@@ -110,14 +169,15 @@ func (bc *Bitcask) ScanFile(fid int64, file *os.File) error {
 		}
 		k := string(key)
 		ent := entry{
-			fileId: fid,
-			offset: off,
-			size:   offIncr,
+			fileId:  fid,
+			offset:  off,
+			size:    offIncr,
+			version: version,
 		}
-		if flags&0x1 == 0x1 {
-			delete(bc.keydir, k)
+		if flags&flagTombstone == flagTombstone {
+			bc.unindex(k)
 		} else {
-			bc.keydir[k] = ent
+			bc.index(k, ent)
 		}
 
 		off += offIncr
@@ -128,92 +188,125 @@ func (bc *Bitcask) ScanFile(fid int64, file *os.File) error {
 func (bc *Bitcask) Delete(key string) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
-	rec := make([]byte, recordHeaderSize+len(key))
-	rec[0] = 0x1
-	binary.BigEndian.PutUint64(rec[1:9], uint64(len(key)))
-	binary.BigEndian.PutUint64(rec[9:17], 0)
-	copy(rec[17:], []byte(key))
+	return bc.delete(key)
+}
 
+func (bc *Bitcask) delete(key string) error {
+	rec := encodeRecord(flagTombstone, key, nil, 0)
 	if _, err := bc.bufw.Write(rec); err != nil {
 		return err
 	}
 	if err := bc.bufw.Flush(); err != nil {
 		return err
 	}
-	delete(bc.keydir, key)
+	bc.unindex(key)
 	bc.currOffset += int64(len(rec))
 	return nil
 }
 
+// index points key at ent in the keydir.
+func (bc *Bitcask) index(key string, ent entry) {
+	bc.unindex(key)
+	bc.keydir[key] = ent
+	bc.live += ent.size
+}
+
+func (bc *Bitcask) unindex(key string) {
+	if old, ok := bc.keydir[key]; ok {
+		bc.live -= old.size
+		delete(bc.keydir, key)
+	}
+}
+
 func (bc *Bitcask) Get(key string) ([]byte, error) {
 	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.get(key)
+}
+
+func (bc *Bitcask) get(key string) ([]byte, error) {
+	rec, err := bc.read(key)
+	if err != nil {
+		return nil, err
+	}
+	return rec.value, nil
+}
+
+func (bc *Bitcask) read(key string) (record, error) {
 	ent, ok := bc.keydir[key]
-	bc.mu.RUnlock()
 	if !ok {
-		return nil, ErrKeyNotFound
+		return record{}, ErrKeyNotFound
 	}
-	bc.mu.RLock()
 	file, ok := bc.files[ent.fileId]
-	bc.mu.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("data file %d not found", ent.fileId)
+		return record{}, fmt.Errorf("data file %d not found", ent.fileId)
 	}
 	buf := make([]byte, ent.size)
 	if _, err := file.ReadAt(buf, ent.offset); err != nil {
-		return nil, err
-	}
-	if len(buf) < recordHeaderSize {
-		return nil, fmt.Errorf("corrupt record")
+		return record{}, err
 	}
-	flags := buf[0]
-	keyLen := int64(binary.BigEndian.Uint64(buf[1:9]))
-	valLen := int64(binary.BigEndian.Uint64(buf[9:17]))
-	if flags&0x1 == 0x1 {
-		return nil, ErrKeyNotFound
+	rec, err := decodeRecord(buf)
+	if err != nil {
+		return record{}, err
 	}
-	start := recordHeaderSize + keyLen
-	if int64(len(buf)) < start+valLen {
-		return nil, fmt.Errorf("corrupt value length")
+	if rec.flags&flagTombstone == flagTombstone {
+		return record{}, ErrKeyNotFound
 	}
-	value := make([]byte, valLen)
-	copy(value, buf[start:start+valLen])
-	return value, nil
+	return rec, nil
+}
+
+// Version returns the version key was last written with and whether the
+// key exists. Keys written without a version report 0.
+func (bc *Bitcask) Version(key string) (uint64, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	ent, ok := bc.keydir[key]
+	return ent.version, ok
 }
 
 func (bc *Bitcask) Put(key string, value []byte) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	return bc.put(key, value)
+}
+
+func (bc *Bitcask) put(key string, value []byte) error {
+	return bc.putVersion(key, value, 0)
+}
 
+func (bc *Bitcask) putVersion(key string, value []byte, version uint64) error {
 	if err := bc.RotateFile(); err != nil {
 		return err
 	}
 
-	rec := make([]byte, recordHeaderSize+len(key)+len(value))
-	rec[0] = 0x0
-	binary.BigEndian.PutUint64(rec[1:9], uint64(len(key)))
-	binary.BigEndian.PutUint64(rec[9:17], uint64(len(value)))
-	copy(rec[17:17+len(key)], []byte(key))
-	copy(rec[17+len(key):], value)
+	rec := encodeRecord(0, key, value, version)
 	if _, err := bc.bufw.Write(rec); err != nil {
 		return err
 	}
 	if err := bc.bufw.Flush(); err != nil {
 		return err
 	}
-	ent := entry{fileId: bc.currID, offset: bc.currOffset, size: int64(len(rec))}
-	bc.keydir[key] = ent
+	bc.index(key, entry{fileId: bc.currID, offset: bc.currOffset, size: int64(len(rec)), version: version})
 	bc.currOffset += int64(len(rec))
 	return nil
 }
 
-func Open(dir string) (*Bitcask, error) {
+// Open opens the store in dir, creating it if needed. It logs to logger,
+// or to slog's default logger if logger is nil.
+func Open(dir string, logger *slog.Logger) (*Bitcask, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return nil, err
 	}
+	if logger == nil {
+		logger = slog.Default()
+	}
 	bc := &Bitcask{
-		dir:    dir,
-		keydir: make(map[string]entry),
-		files:  make(map[int64]*os.File),
+		log:       logger,
+		dir:       dir,
+		keydir:    make(map[string]entry),
+		files:     make(map[int64]*os.File),
+		retention: make(map[string]RetentionPolicy),
+		history:   make(map[string][]uint64),
 	}
 
 	files, err := filepath.Glob(filepath.Join(dir, dataFilePrefix+"*"+dataFileSuffix))
@@ -267,6 +360,9 @@ func Open(dir string) (*Bitcask, error) {
 		bc.bufw = bufio.NewWriterSize(file, 4096)
 	}
 
+	if err := bc.loadHistory(); err != nil {
+		return nil, err
+	}
 	return bc, nil
 }
 
@@ -297,9 +393,11 @@ func (bc *Bitcask) RotateFile() error {
 }
 
 func (bc *Bitcask) Entries() (map[string][]byte, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
 	result := make(map[string][]byte, len(bc.keydir))
 	for k := range bc.keydir {
-		val, err := bc.Get(k)
+		val, err := bc.get(k)
 		if err != nil {
 			return nil, err
 		}
@@ -308,8 +406,21 @@ func (bc *Bitcask) Entries() (map[string][]byte, error) {
 	return result, nil
 }
 
-func (bc *Bitcask) compactionEntries() (map[string][]byte, error) {
-	result := make(map[string][]byte, len(bc.keydir))
+// Versions returns the version of every key written with one.
+func (bc *Bitcask) Versions() map[string]uint64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	result := make(map[string]uint64)
+	for k, ent := range bc.keydir {
+		if ent.version != 0 {
+			result[k] = ent.version
+		}
+	}
+	return result
+}
+
+func (bc *Bitcask) compactionEntries() (map[string]record, error) {
+	result := make(map[string]record, len(bc.keydir))
 	for k, ent := range bc.keydir {
 		// fmt.Printf("compactionEntries: processing key %s in file %d\n", k, ent.fileId)
 		file, ok := bc.files[ent.fileId]
@@ -325,26 +436,19 @@ func (bc *Bitcask) compactionEntries() (map[string][]byte, error) {
 		if _, err := file.ReadAt(buf, ent.offset); err != nil {
 			return nil, fmt.Errorf("failed to read key %s from file %d: %w", k, ent.fileId, err)
 		}
-		if len(buf) < recordHeaderSize {
-			return nil, fmt.Errorf("corrupt record for key %s in file %d", k, ent.fileId)
+		rec, err := decodeRecord(buf)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt record for key %s in file %d: %w", k, ent.fileId, err)
 		}
-		flags := buf[0]
-		keyLen := int64(binary.BigEndian.Uint64(buf[1:9]))
-		valLen := int64(binary.BigEndian.Uint64(buf[9:17]))
-		if flags&0x1 == 0x1 {
+		if rec.flags&flagTombstone == flagTombstone {
 			continue // Skip tombstones
 		}
-		if int64(len(buf)) < recordHeaderSize+keyLen+valLen {
-			return nil, fmt.Errorf("corrupt record size for key %s in file %d", k, ent.fileId)
-		}
-		value := buf[recordHeaderSize+keyLen : recordHeaderSize+keyLen+valLen]
-		result[k] = value
+		result[k] = rec
 	}
-	fmt.Println("compactionEntries: completed")
 	return result, nil
 }
 
-func (bc *Bitcask) RestoreFromSnapshot(data map[string][]byte) error {
+func (bc *Bitcask) RestoreFromSnapshot(data map[string][]byte, versions map[string]uint64) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
@@ -353,6 +457,7 @@ func (bc *Bitcask) RestoreFromSnapshot(data map[string][]byte) error {
 	}
 	bc.files = make(map[int64]*os.File)
 	bc.keydir = make(map[string]entry)
+	bc.live = 0
 
 	bc.currID = 0
 	path := filepath.Join(bc.dir, dataFilePrefix+"0"+dataFileSuffix)
@@ -366,30 +471,22 @@ func (bc *Bitcask) RestoreFromSnapshot(data map[string][]byte) error {
 	bc.bufw = bufio.NewWriterSize(file, 4096)
 
 	for k, v := range data {
-		if err := bc.Put(k, v); err != nil {
+		if err := bc.putVersion(k, v, versions[k]); err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-func (bc *Bitcask) ApplyCommand(op, key string, val []byte) error {
-	switch op {
-	case "PUT":
-		return bc.Put(key, val)
-	case "DEL":
-		return bc.Delete(key)
-	default:
-		return fmt.Errorf("unknown operation: %s", op)
-	}
+	return bc.loadHistory()
 }
 
 func (bc *Bitcask) InitiateCompaction() error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	fmt.Println("Compaction initiated !!")
+	bc.log.Info("compaction started")
 
+	if err := bc.pruneHistory(time.Now()); err != nil {
+		return fmt.Errorf("failed to prune history: %w", err)
+	}
 	if err := bc.bufw.Flush(); err != nil {
 		return fmt.Errorf("failed to flush buffer: %w", err)
 	}
@@ -421,6 +518,7 @@ func (bc *Bitcask) InitiateCompaction() error {
 		}
 	}
 
+	start, before := time.Now(), bc.dataBytes()
 	entries, err := bc.compactionEntries()
 	if err != nil {
 		return fmt.Errorf("failed to get entries: %w", err)
@@ -429,7 +527,7 @@ func (bc *Bitcask) InitiateCompaction() error {
 	// Close files after reading entries, tolerate sync errors
 	for fid, f := range bc.files {
 		if err := f.Sync(); err != nil {
-			fmt.Printf("Warning: failed to sync file %d: %v\n", fid, err)
+			bc.log.Warn("failed to sync data file before compaction", "file", fid, "err", err)
 			// Continue to close the file
 		}
 		if err := f.Close(); err != nil {
@@ -459,9 +557,11 @@ func (bc *Bitcask) InitiateCompaction() error {
 	bufw = bufio.NewWriterSize(currFile, 4096)
 
 	bc.keydir = make(map[string]entry)
+	bc.live = 0
 
-	for key, value := range entries {
-		recordSize := int64(recordHeaderSize + len(key) + len(value))
+	for key, entryRec := range entries {
+		rec := encodeRecord(0, key, entryRec.value, entryRec.version)
+		recordSize := int64(len(rec))
 		if currOffset+recordSize > maxFileSize {
 			if err := bufw.Flush(); err != nil {
 				currFile.Close()
@@ -486,17 +586,11 @@ func (bc *Bitcask) InitiateCompaction() error {
 			currOffset = 0
 		}
 
-		rec := make([]byte, recordHeaderSize+len(key)+len(value))
-		rec[0] = 0x0
-		binary.BigEndian.PutUint64(rec[1:9], uint64(len(key)))
-		binary.BigEndian.PutUint64(rec[9:17], uint64(len(value)))
-		copy(rec[17:17+len(key)], []byte(key))
-		copy(rec[17+len(key):], value)
 		if _, err := bufw.Write(rec); err != nil {
 			currFile.Close()
 			return fmt.Errorf("failed to write key %s: %w", key, err)
 		}
-		bc.keydir[key] = entry{fileId: currId, offset: currOffset, size: recordSize}
+		bc.index(key, entry{fileId: currId, offset: currOffset, size: recordSize, version: entryRec.version})
 		currOffset += recordSize
 	}
 
@@ -543,7 +637,6 @@ func (bc *Bitcask) InitiateCompaction() error {
 	}
 	bc.currOffset = off
 	bc.bufw = bufio.NewWriterSize(currFile, 4096)
-
-	fmt.Println("Compaction completed successfully")
+	bc.reportCompaction(start, before, bc.dataBytes())
 	return nil
 }
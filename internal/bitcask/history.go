@@ -0,0 +1,369 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	historyPrefix   = SystemPrefix + "history/"
+	retentionPrefix = SystemPrefix + "retention/"
+)
+
+var (
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// RetentionPolicy keeps older versions of every key starting with Prefix.
+// A policy for a full key name applies to that key alone; when several
+// policies match, the longest prefix wins. Versions are kept while either
+// limit allows it, and the newest version is never pruned.
+type RetentionPolicy struct {
+	Prefix      string        `json:"prefix"`
+	MaxVersions int           `json:"max_versions,omitempty"`
+	MaxAge      time.Duration `json:"max_age,omitempty"`
+}
+
+// retentionJSON is the encoding of a RetentionPolicy, with MaxAge as a
+// duration string such as "1h30m".
+type retentionJSON struct {
+	Prefix      string          `json:"prefix"`
+	MaxVersions int             `json:"max_versions,omitempty"`
+	MaxAge      json.RawMessage `json:"max_age,omitempty"`
+}
+
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	out := retentionJSON{Prefix: p.Prefix, MaxVersions: p.MaxVersions}
+	if p.MaxAge != 0 {
+		out.MaxAge, _ = json.Marshal(p.MaxAge.String())
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON also accepts MaxAge in nanoseconds, as policies stored and
+// logged by earlier versions carry it.
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	var in retentionJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*p = RetentionPolicy{Prefix: in.Prefix, MaxVersions: in.MaxVersions}
+	if len(in.MaxAge) == 0 || string(in.MaxAge) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(in.MaxAge, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(in.MaxAge, &ns); err != nil {
+			return fmt.Errorf("invalid max_age %s", in.MaxAge)
+		}
+		p.MaxAge = time.Duration(ns)
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid max_age: %w", err)
+	}
+	p.MaxAge = d
+	return nil
+}
+
+// Version describes one retained write of a key. Index is the Raft index
+// that produced it and Time the leader's append time for that entry.
+type Version struct {
+	Index   uint64    `json:"index"`
+	Time    time.Time `json:"time"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+func historyKey(key string, index uint64) string {
+	return historyPrefix + key + "\x00" + fmt.Sprintf("%020d", index)
+}
+
+func splitHistoryKey(k string) (string, uint64, bool) {
+	rest := strings.TrimPrefix(k, historyPrefix)
+	i := strings.LastIndexByte(rest, 0)
+	if i < 0 {
+		return "", 0, false
+	}
+	index, err := strconv.ParseUint(rest[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return rest[:i], index, true
+}
+
+func encodeVersion(ts time.Time, deleted bool, value []byte) []byte {
+	buf := make([]byte, 9+len(value))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(ts.UnixNano()))
+	if deleted {
+		buf[8] = 0x1
+	}
+	copy(buf[9:], value)
+	return buf
+}
+
+func decodeVersion(index uint64, buf []byte) (Version, []byte, error) {
+	if len(buf) < 9 {
+		return Version{}, nil, fmt.Errorf("corrupt version record")
+	}
+	v := Version{
+		Index:   index,
+		Time:    time.Unix(0, int64(binary.BigEndian.Uint64(buf[0:8]))).UTC(),
+		Deleted: buf[8]&0x1 == 0x1,
+	}
+	return v, buf[9:], nil
+}
+
+// loadHistory rebuilds the retention and version caches from the keydir.
+func (bc *Bitcask) loadHistory() error {
+	bc.retention = make(map[string]RetentionPolicy)
+	bc.history = make(map[string][]uint64)
+	for k := range bc.keydir {
+		switch {
+		case strings.HasPrefix(k, retentionPrefix):
+			val, err := bc.get(k)
+			if err != nil {
+				return err
+			}
+			var p RetentionPolicy
+			if err := json.Unmarshal(val, &p); err != nil {
+				return fmt.Errorf("decode retention policy %q: %w", k, err)
+			}
+			bc.retention[p.Prefix] = p
+		case strings.HasPrefix(k, historyPrefix):
+			key, index, ok := splitHistoryKey(k)
+			if !ok {
+				continue
+			}
+			bc.history[key] = append(bc.history[key], index)
+		}
+	}
+	for _, indexes := range bc.history {
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	}
+	return nil
+}
+
+func (bc *Bitcask) retentionFor(key string) (RetentionPolicy, bool) {
+	var best RetentionPolicy
+	found := false
+	for prefix, p := range bc.retention {
+		if strings.HasPrefix(key, prefix) && (!found || len(prefix) > len(best.Prefix)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// SetRetention installs or replaces the policy for p.Prefix. A policy with
+// neither limit set removes it; versions already kept are left in place.
+func (bc *Bitcask) SetRetention(p RetentionPolicy) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	k := retentionPrefix + p.Prefix
+	if p.MaxVersions <= 0 && p.MaxAge <= 0 {
+		delete(bc.retention, p.Prefix)
+		if _, ok := bc.keydir[k]; !ok {
+			return nil
+		}
+		return bc.delete(k)
+	}
+	val, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := bc.put(k, val); err != nil {
+		return err
+	}
+	bc.retention[p.Prefix] = p
+	return nil
+}
+
+func (bc *Bitcask) RetentionPolicies() []RetentionPolicy {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	policies := make([]RetentionPolicy, 0, len(bc.retention))
+	for _, p := range bc.retention {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Prefix < policies[j].Prefix })
+	return policies
+}
+
+// PutAt writes key with the given Raft index as its version and, when a
+// retention policy covers the key, records the write in its history.
+func (bc *Bitcask) PutAt(key string, value []byte, index uint64, ts time.Time) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if err := bc.putVersion(key, value, index); err != nil {
+		return err
+	}
+	return bc.recordVersion(key, value, false, index, ts)
+}
+
+// DeleteAt deletes key like Delete and records a tombstone version when a
+// retention policy covers the key.
+func (bc *Bitcask) DeleteAt(key string, index uint64, ts time.Time) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if err := bc.delete(key); err != nil {
+		return err
+	}
+	return bc.recordVersion(key, nil, true, index, ts)
+}
+
+func (bc *Bitcask) recordVersion(key string, value []byte, deleted bool, index uint64, ts time.Time) error {
+	p, ok := bc.retentionFor(key)
+	if !ok || strings.HasPrefix(key, SystemPrefix) {
+		return nil
+	}
+	indexes := bc.history[key]
+	if n := len(indexes); n > 0 && indexes[n-1] >= index {
+		// Replayed log entry; the version is already on disk.
+		return nil
+	}
+	if err := bc.put(historyKey(key, index), encodeVersion(ts, deleted, value)); err != nil {
+		return err
+	}
+	indexes = append(indexes, index)
+	drop, err := bc.expired(key, indexes, p, ts)
+	if err != nil {
+		return err
+	}
+	return bc.dropVersions(key, indexes, drop)
+}
+
+// expired counts the versions of key, oldest first, that policy p no
+// longer keeps at time now. The newest version is always kept.
+func (bc *Bitcask) expired(key string, indexes []uint64, p RetentionPolicy, now time.Time) (int, error) {
+	drop := 0
+	for drop < len(indexes)-1 {
+		if p.MaxVersions > 0 && len(indexes)-drop > p.MaxVersions {
+			drop++
+			continue
+		}
+		if p.MaxAge > 0 {
+			v, _, err := bc.version(key, indexes[drop])
+			if err != nil {
+				return 0, err
+			}
+			if now.Sub(v.Time) > p.MaxAge {
+				drop++
+				continue
+			}
+		}
+		break
+	}
+	return drop, nil
+}
+
+// dropVersions deletes the oldest drop versions of key.
+func (bc *Bitcask) dropVersions(key string, indexes []uint64, drop int) error {
+	for _, idx := range indexes[:drop] {
+		if err := bc.delete(historyKey(key, idx)); err != nil {
+			return err
+		}
+	}
+	bc.history[key] = append([]uint64(nil), indexes[drop:]...)
+	return nil
+}
+
+// pruneHistory deletes the versions that have outlived their policy's
+// MaxAge, for keys that were not written since. Compaction calls it, so
+// the space they take is reclaimed; reads skip them before then.
+func (bc *Bitcask) pruneHistory(now time.Time) error {
+	for key, indexes := range bc.history {
+		p, ok := bc.retentionFor(key)
+		if !ok || p.MaxAge <= 0 {
+			continue
+		}
+		drop, err := bc.expired(key, indexes, p, now)
+		if err != nil {
+			return err
+		}
+		if drop > 0 {
+			if err := bc.dropVersions(key, indexes, drop); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retained returns the versions of key its policy still keeps now.
+func (bc *Bitcask) retained(key string) ([]uint64, error) {
+	indexes := bc.history[key]
+	p, ok := bc.retentionFor(key)
+	if !ok {
+		return indexes, nil
+	}
+	drop, err := bc.expired(key, indexes, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return indexes[drop:], nil
+}
+
+func (bc *Bitcask) version(key string, index uint64) (Version, []byte, error) {
+	buf, err := bc.get(historyKey(key, index))
+	if err != nil {
+		return Version{}, nil, err
+	}
+	return decodeVersion(index, buf)
+}
+
+// History lists the retained versions of key, oldest first. It returns
+// ErrKeyNotFound for a key that has neither a value nor retained versions.
+func (bc *Bitcask) History(key string) ([]Version, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	indexes, err := bc.retained(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := bc.keydir[key]; !ok && len(indexes) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	versions := make([]Version, 0, len(indexes))
+	for _, idx := range indexes {
+		v, _, err := bc.version(key, idx)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// GetAt returns the value key had at the given Raft index, i.e. the newest
+// retained version written at or before it.
+func (bc *Bitcask) GetAt(key string, atIndex uint64) ([]byte, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	indexes, err := bc.retained(key)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(indexes), func(i int) bool { return indexes[i] > atIndex })
+	if i == 0 {
+		return nil, ErrVersionNotFound
+	}
+	v, val, err := bc.version(key, indexes[i-1])
+	if err != nil {
+		return nil, err
+	}
+	if v.Deleted {
+		return nil, ErrKeyNotFound
+	}
+	out := make([]byte, len(val))
+	copy(out, val)
+	return out, nil
+}
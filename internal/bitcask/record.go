@@ -0,0 +1,66 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	flagTombstone = 0x1
+	flagVersioned = 0x2
+	versionSize   = 8
+)
+
+// A record is laid out as flags, key length and value length, then an
+// 8-byte version when flagVersioned is set, then the key and the value.
+// Records written before versions existed carry none and read as version 0.
+type record struct {
+	flags   byte
+	key     string
+	value   []byte
+	version uint64
+}
+
+func encodeRecord(flags byte, key string, value []byte, version uint64) []byte {
+	size := recordHeaderSize + len(key) + len(value)
+	if version != 0 {
+		flags |= flagVersioned
+		size += versionSize
+	}
+	rec := make([]byte, size)
+	rec[0] = flags
+	binary.BigEndian.PutUint64(rec[1:9], uint64(len(key)))
+	binary.BigEndian.PutUint64(rec[9:17], uint64(len(value)))
+	off := recordHeaderSize
+	if version != 0 {
+		binary.BigEndian.PutUint64(rec[off:off+versionSize], version)
+		off += versionSize
+	}
+	copy(rec[off:off+len(key)], key)
+	copy(rec[off+len(key):], value)
+	return rec
+}
+
+func decodeRecord(buf []byte) (record, error) {
+	if len(buf) < recordHeaderSize {
+		return record{}, fmt.Errorf("corrupt record")
+	}
+	rec := record{flags: buf[0]}
+	keyLen := int64(binary.BigEndian.Uint64(buf[1:9]))
+	valLen := int64(binary.BigEndian.Uint64(buf[9:17]))
+	off := int64(recordHeaderSize)
+	if rec.flags&flagVersioned == flagVersioned {
+		if int64(len(buf)) < off+versionSize {
+			return record{}, fmt.Errorf("corrupt record version")
+		}
+		rec.version = binary.BigEndian.Uint64(buf[off : off+versionSize])
+		off += versionSize
+	}
+	if int64(len(buf)) < off+keyLen+valLen {
+		return record{}, fmt.Errorf("corrupt value length")
+	}
+	rec.key = string(buf[off : off+keyLen])
+	rec.value = make([]byte, valLen)
+	copy(rec.value, buf[off+keyLen:off+keyLen+valLen])
+	return rec, nil
+}
@@ -0,0 +1,80 @@
+package bitcask
+
+import (
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// Stats describes the size of the store on disk.
+type Stats struct {
+	// Keys counts the keys in the keydir, system keys included.
+	Keys      int
+	DataFiles int
+	// DataBytes is the size of the data files: LiveBytes of records the
+	// keydir points to and DeadBytes of overwritten or deleted ones, which
+	// compaction reclaims.
+	DataBytes int64
+	LiveBytes int64
+	DeadBytes int64
+}
+
+// Compaction describes a completed compaction.
+type Compaction struct {
+	Time      time.Time
+	Duration  time.Duration
+	Reclaimed int64
+}
+
+func (bc *Bitcask) Stats() Stats {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	data := bc.dataBytes()
+	return Stats{
+		Keys:      len(bc.keydir),
+		DataFiles: len(bc.files),
+		DataBytes: data,
+		LiveBytes: bc.live,
+		DeadBytes: max(data-bc.live, 0),
+	}
+}
+
+// dataBytes sums the size of the data files, counting what is buffered
+// for the current one.
+func (bc *Bitcask) dataBytes() int64 {
+	var n int64
+	for fid, f := range bc.files {
+		if fid == bc.currID {
+			n += bc.currOffset
+			continue
+		}
+		if info, err := f.Stat(); err == nil {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+// LastCompaction returns the last compaction since the store was opened;
+// ok is false if there was none.
+func (bc *Bitcask) LastCompaction() (c Compaction, ok bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.lastCompaction, !bc.lastCompaction.Time.IsZero()
+}
+
+// Closed reports whether Close was called.
+func (bc *Bitcask) Closed() bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.closed
+}
+
+// reportCompaction records and logs how long a compaction took and how
+// many bytes it reclaimed.
+func (bc *Bitcask) reportCompaction(start time.Time, before, after int64) {
+	bc.lastCompaction = Compaction{Time: start, Duration: time.Since(start), Reclaimed: max(before-after, 0)}
+	bc.log.Info("compaction completed", "duration", bc.lastCompaction.Duration, "reclaimed_bytes", bc.lastCompaction.Reclaimed)
+	metrics.MeasureSince([]string{"bitcask", "compaction", "duration"}, start)
+	metrics.IncrCounter([]string{"bitcask", "compaction", "reclaimed_bytes"}, float32(bc.lastCompaction.Reclaimed))
+}
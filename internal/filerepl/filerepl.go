@@ -0,0 +1,184 @@
+// Package filerepl reads local files for replication into the store,
+// confined to configured root directories.
+//
+// A path is accepted only if, after resolving symlinks and "..", it names
+// a regular file under one of the roots. The file is then opened through
+// an os.Root, so a symlink swapped in after the check cannot lead out of
+// the root either.
+package filerepl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxSize bounds the files read when Config.MaxSize is zero. Every
+// file becomes one Raft entry, so it should stay well below what the
+// cluster can ship to a follower within its timeouts.
+const DefaultMaxSize = 16 << 20
+
+var (
+	ErrDisabled     = errors.New("file replication is disabled: no roots are configured")
+	ErrOutsideRoots = errors.New("path is outside the allowed roots")
+	ErrNotRegular   = errors.New("not a regular file")
+	ErrTooLarge     = errors.New("file exceeds the size limit")
+)
+
+type Config struct {
+	// Roots are the directories files may be read from. Without roots
+	// nothing can be read.
+	Roots []string
+	// MaxSize is the largest file read, in bytes.
+	MaxSize int64
+	// RelativeKeys keys files by their path relative to their root,
+	// rather than by their base name, which collides across directories.
+	RelativeKeys bool
+}
+
+type root struct {
+	dir string
+	fs  *os.Root
+}
+
+type Reader struct {
+	roots        []root
+	maxSize      int64
+	relativeKeys bool
+}
+
+// New opens the roots of cfg, which must be existing directories.
+func New(cfg Config) (*Reader, error) {
+	r := &Reader{maxSize: cfg.MaxSize, relativeKeys: cfg.RelativeKeys}
+	if r.maxSize <= 0 {
+		r.maxSize = DefaultMaxSize
+	}
+	for _, dir := range cfg.Roots {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if abs, err = filepath.EvalSymlinks(abs); err != nil {
+			return nil, fmt.Errorf("root %s: %w", dir, err)
+		}
+		fsys, err := os.OpenRoot(abs)
+		if err != nil {
+			return nil, fmt.Errorf("root %s: %w", dir, err)
+		}
+		r.roots = append(r.roots, root{dir: abs, fs: fsys})
+	}
+	return r, nil
+}
+
+// Enabled reports whether any root is configured.
+func (r *Reader) Enabled() bool {
+	return r != nil && len(r.roots) > 0
+}
+
+// Close closes the roots.
+func (r *Reader) Close() error {
+	var errs []error
+	for _, rt := range r.roots {
+		errs = append(errs, rt.fs.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// File is a file read for replication.
+type File struct {
+	// Path is the file's resolved absolute path.
+	Path string
+	// Rel is Path relative to its root, with forward slashes.
+	Rel  string
+	Key  string
+	Data []byte
+}
+
+// Read reads the file at path, an absolute path or one relative to the
+// roots, tried in order. relativeKey overrides Config.RelativeKeys when
+// not nil.
+func (r *Reader) Read(path string, relativeKey *bool) (*File, error) {
+	if !r.Enabled() {
+		return nil, ErrDisabled
+	}
+	if path == "" {
+		return nil, errors.New("path required")
+	}
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = candidates[:0]
+		for _, rt := range r.roots {
+			candidates = append(candidates, filepath.Join(rt.dir, path))
+		}
+	}
+	var lastErr error
+	for _, c := range candidates {
+		f, err := r.read(c, relativeKey)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (r *Reader) read(path string, relativeKey *bool) (*File, error) {
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	rt, rel, ok := r.within(resolved)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrOutsideRoots, resolved)
+	}
+	f, err := rt.fs.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %s", ErrNotRegular, resolved)
+	}
+	if info.Size() > r.maxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrTooLarge, resolved, info.Size(), r.maxSize)
+	}
+	// The file may grow after the Stat.
+	data, err := io.ReadAll(io.LimitReader(f, r.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > r.maxSize {
+		return nil, fmt.Errorf("%w: %s grew past %d bytes", ErrTooLarge, resolved, r.maxSize)
+	}
+	out := &File{Path: resolved, Rel: filepath.ToSlash(rel), Data: data}
+	useRel := r.relativeKeys
+	if relativeKey != nil {
+		useRel = *relativeKey
+	}
+	out.Key = filepath.Base(path)
+	if useRel {
+		out.Key = out.Rel
+	}
+	return out, nil
+}
+
+// within finds the root containing path, a resolved absolute path, and
+// returns path relative to it.
+func (r *Reader) within(path string) (root, string, bool) {
+	for _, rt := range r.roots {
+		rel, err := filepath.Rel(rt.dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			continue
+		}
+		return rt, rel, true
+	}
+	return root{}, "", false
+}
@@ -0,0 +1,53 @@
+package filesync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Entry is the metadata stored with a file's content.
+type Entry struct {
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+var errEntry = errors.New("not a synced file")
+
+// encode stores e as a JSON line ahead of the content, which stays as is.
+func encode(e Entry, data []byte) []byte {
+	head, _ := json.Marshal(e)
+	out := make([]byte, 0, len(head)+1+len(data))
+	out = append(out, head...)
+	out = append(out, '\n')
+	return append(out, data...)
+}
+
+func decode(val []byte) (Entry, []byte, error) {
+	head, data, ok := bytes.Cut(val, []byte{'\n'})
+	if !ok {
+		return Entry{}, nil, errEntry
+	}
+	var e Entry
+	if err := json.Unmarshal(head, &e); err != nil {
+		return Entry{}, nil, errEntry
+	}
+	return e, data, nil
+}
+
+// state is what tells a file changed without reading it.
+type state struct {
+	mode  fs.FileMode
+	mtime int64
+	size  int64
+}
+
+func stateOf(info fs.FileInfo) state {
+	return state{mode: info.Mode().Perm(), mtime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+func (e Entry) state(size int) state {
+	return state{mode: e.Mode.Perm(), mtime: e.ModTime.UnixNano(), size: int64(size)}
+}
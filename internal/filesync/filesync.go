@@ -0,0 +1,128 @@
+// Package filesync mirrors directories through the store.
+//
+// A node publishing a directory writes each regular file in it under a key
+// prefix, with the file's mode and modification time, and deletes the keys
+// of files that disappear; it rescans the directory periodically. A node
+// subscribed to a prefix materialises its keys into a local directory,
+// applying changes in the order they are committed to the Raft log. The
+// prefix belongs to the publication, and other keys under it are deleted;
+// a subscription only removes the files it wrote itself.
+package filesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/service"
+)
+
+// Dir pairs a local directory with the key prefix it is mirrored under.
+type Dir struct {
+	Dir    string `json:"dir"`
+	Prefix string `json:"prefix"`
+}
+
+type Config struct {
+	Publish   []Dir
+	Subscribe []Dir
+	// Interval is the time between two scans of a published directory.
+	Interval time.Duration
+	// MaxSize is the largest file published, in bytes; larger files are
+	// skipped.
+	MaxSize int64
+}
+
+// Validate checks that no two prefixes of cfg overlap, since a directory
+// published and subscribed under the same keys would feed itself, and two
+// publications would delete each other's files.
+func (cfg Config) Validate() error {
+	var errs []error
+	all := append(append([]Dir(nil), cfg.Publish...), cfg.Subscribe...)
+	for i, a := range all {
+		if a.Dir == "" || a.Prefix == "" || strings.HasPrefix(a.Prefix, bitcask.SystemPrefix) {
+			errs = append(errs, fmt.Errorf("%q=%q: a synced directory needs a directory and a prefix", a.Prefix, a.Dir))
+			continue
+		}
+		for _, b := range all[i+1:] {
+			if strings.HasPrefix(a.Prefix, b.Prefix) || strings.HasPrefix(b.Prefix, a.Prefix) {
+				errs = append(errs, fmt.Errorf("prefixes %q and %q overlap", a.Prefix, b.Prefix))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status describes one published or subscribed directory.
+type Status struct {
+	Kind      string    `json:"kind"`
+	Dir       string    `json:"dir"`
+	Prefix    string    `json:"prefix"`
+	Files     int       `json:"files"`
+	LastSync  time.Time `json:"last_sync,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type Syncer struct {
+	svc *service.Service
+	cfg Config
+	log *slog.Logger
+
+	mu     sync.Mutex
+	status []Status
+}
+
+func New(svc *service.Service, cfg Config) *Syncer {
+	s := &Syncer{svc: svc, cfg: cfg, log: svc.Logger().With("component", "sync")}
+	for _, d := range cfg.Publish {
+		s.status = append(s.status, Status{Kind: "publish", Dir: d.Dir, Prefix: d.Prefix})
+	}
+	for _, d := range cfg.Subscribe {
+		s.status = append(s.status, Status{Kind: "subscribe", Dir: d.Dir, Prefix: d.Prefix})
+	}
+	return s
+}
+
+// Run syncs every directory until ctx is done.
+func (s *Syncer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i, d := range s.cfg.Publish {
+		p := &publisher{s: s, slot: i, d: d}
+		wg.Go(func() { p.run(ctx) })
+	}
+	for i, d := range s.cfg.Subscribe {
+		sub := &subscriber{s: s, slot: len(s.cfg.Publish) + i, d: d}
+		wg.Go(func() { sub.run(ctx) })
+	}
+	wg.Wait()
+}
+
+// Status reports on every directory.
+func (s *Syncer) Status() []Status {
+	if s == nil {
+		return []Status{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Status{}, s.status...)
+}
+
+// report records the outcome of a sync of the directory in slot.
+func (s *Syncer) report(slot, files int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &s.status[slot]
+	if err != nil {
+		if st.LastError != err.Error() {
+			s.log.Warn("sync failed", "kind", st.Kind, "dir", st.Dir, "err", err)
+		}
+		st.LastError = err.Error()
+		return
+	}
+	st.Files, st.LastSync, st.LastError = files, time.Now(), ""
+}
@@ -0,0 +1,166 @@
+package filesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+type publisher struct {
+	s    *Syncer
+	slot int
+	d    Dir
+	// known is the state of every file as last written to the store,
+	// read back from the store on the first scan.
+	known map[string]state
+	// skipped holds the files over the size limit, to warn once per
+	// change.
+	skipped map[string]state
+}
+
+func (p *publisher) run(ctx context.Context) {
+	ticker := time.NewTicker(p.s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := p.scan(ctx)
+		if err != nil {
+			// Some writes may or may not have been applied; read the
+			// state back from the store.
+			p.known = nil
+		}
+		p.s.report(p.slot, n, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan writes the files that changed since the last scan and deletes the
+// keys of those removed. It returns the number of files published.
+func (p *publisher) scan(ctx context.Context) (int, error) {
+	root, err := os.OpenRoot(p.d.Dir)
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+	if p.known == nil {
+		// Wait for a leader, so the store has caught up with what an
+		// earlier run published.
+		if _, err := p.s.svc.Node().Leader(); err != nil {
+			return 0, err
+		}
+		p.known = p.stored()
+	}
+
+	local := make(map[string]fs.FileInfo)
+	skipped := make(map[string]state)
+	err = fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Symlinks are not followed, so nothing outside the directory is
+		// published.
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Size() > p.s.cfg.MaxSize {
+			if st, ok := p.skipped[path]; !ok || st != stateOf(info) {
+				p.s.log.Warn("skipping file over the size limit", "dir", p.d.Dir, "path", path, "size", info.Size(), "max_size", p.s.cfg.MaxSize)
+			}
+			skipped[path] = stateOf(info)
+			return nil
+		}
+		local[path] = info
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	p.skipped = skipped
+
+	svc := p.s.svc
+	for path, info := range local {
+		st := stateOf(info)
+		if old, ok := p.known[path]; ok && old == st {
+			continue
+		}
+		data, err := readFile(root, path, p.s.cfg.MaxSize)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		val := encode(Entry{Mode: info.Mode().Perm(), ModTime: info.ModTime()}, data)
+		if err := svc.Put(ctx, p.d.Prefix+path, val, 0); err != nil {
+			return 0, fmt.Errorf("publish %s: %w", path, err)
+		}
+		p.known[path] = st
+	}
+	for path := range p.known {
+		if _, ok := local[path]; ok {
+			continue
+		}
+		if err := svc.Delete(ctx, p.d.Prefix+path); err != nil && !api.IsCode(err, api.CodeNotFound) {
+			return 0, fmt.Errorf("unpublish %s: %w", path, err)
+		}
+		delete(p.known, path)
+	}
+	return len(local), nil
+}
+
+// stored reads the state of the files already published under the prefix,
+// so a restarted node only writes what changed meanwhile. Keys that are
+// not synced files get a state no file has, so they are overwritten or
+// deleted.
+func (p *publisher) stored() map[string]state {
+	node := p.s.svc.Node()
+	known := make(map[string]state)
+	for _, key := range node.Store.KeysWithPrefix(p.d.Prefix) {
+		path := key[len(p.d.Prefix):]
+		val, err := node.Get(key)
+		if err != nil {
+			continue
+		}
+		e, data, err := decode(val)
+		if err != nil {
+			known[path] = state{size: -1}
+			continue
+		}
+		known[path] = e.state(len(data))
+	}
+	return known
+}
+
+// readFile reads at most max bytes of a file, which may be written to
+// while it is read.
+func readFile(root *os.Root, path string, max int64) ([]byte, error) {
+	f, err := root.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%s grew past %d bytes", path, max)
+	}
+	return data, nil
+}
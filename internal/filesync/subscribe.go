@@ -0,0 +1,239 @@
+package filesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+const (
+	// tmpPrefix marks files being written, which a reconcile removes.
+	tmpPrefix = ".hyphora-sync-"
+	// manifestName lists the files the subscriber wrote. Only those are
+	// ever removed, so files that were in the directory before it was
+	// subscribed are left alone.
+	manifestName = ".hyphora-sync.json"
+)
+
+type subscriber struct {
+	s     *Syncer
+	slot  int
+	d     Dir
+	files int
+	owned map[string]bool
+}
+
+func (sub *subscriber) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := sub.follow(ctx)
+		sub.s.report(sub.slot, sub.files, err)
+		if err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(sub.s.cfg.Interval):
+		}
+	}
+}
+
+// follow reconciles the directory with the store, then applies changes as
+// this node commits them. It returns nil when the watch falls behind or the
+// store is restored from a snapshot, to start over.
+func (sub *subscriber) follow(ctx context.Context) error {
+	if err := os.MkdirAll(sub.d.Dir, 0755); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(sub.d.Dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if sub.owned, err = readManifest(root); err != nil {
+		return err
+	}
+	// Watch first, so no change committed during the reconcile is missed;
+	// applying one twice is harmless.
+	events, cancel := sub.s.svc.Node().Watch(sub.d.Prefix)
+	defer cancel()
+	if err := sub.reconcile(root); err != nil {
+		return err
+	}
+	sub.s.report(sub.slot, sub.files, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := sub.apply(root, ev); err != nil {
+				return err
+			}
+			sub.s.report(sub.slot, sub.files, nil)
+		}
+	}
+}
+
+// reconcile makes the directory match the keys under the prefix, removing
+// the files it wrote earlier for keys that are gone.
+func (sub *subscriber) reconcile(root *os.Root) error {
+	node := sub.s.svc.Node()
+	want := make(map[string]bool)
+	for _, key := range node.Store.KeysWithPrefix(sub.d.Prefix) {
+		name := key[len(sub.d.Prefix):]
+		val, err := node.Get(key)
+		if err != nil || !fs.ValidPath(name) || name == manifestName {
+			continue
+		}
+		e, data, err := decode(val)
+		if err != nil {
+			continue
+		}
+		want[name] = true
+		sub.owned[name] = true
+		if info, err := root.Lstat(name); err == nil && info.Mode().IsRegular() && stateOf(info) == e.state(len(data)) {
+			continue
+		}
+		if err := write(root, name, e, data); err != nil {
+			return err
+		}
+	}
+	var stale []string
+	err := fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !want[name] && (sub.owned[name] || strings.HasPrefix(path.Base(name), tmpPrefix)) {
+			stale = append(stale, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err := remove(root, name); err != nil {
+			return err
+		}
+	}
+	for name := range sub.owned {
+		if !want[name] {
+			delete(sub.owned, name)
+		}
+	}
+	sub.files = len(want)
+	return writeManifest(root, sub.owned)
+}
+
+func (sub *subscriber) apply(root *os.Root, ev raftnode.Event) error {
+	name := ev.Key[len(sub.d.Prefix):]
+	if !fs.ValidPath(name) || name == "." || name == manifestName {
+		return nil
+	}
+	if ev.Type == raftnode.EventDelete {
+		if !sub.owned[name] {
+			return nil
+		}
+		if err := remove(root, name); err != nil {
+			return err
+		}
+		sub.files--
+		delete(sub.owned, name)
+		return writeManifest(root, sub.owned)
+	}
+	e, data, err := decode(ev.Value)
+	if err != nil {
+		return nil
+	}
+	if !sub.owned[name] {
+		sub.files++
+	}
+	if err := sub.own(root, name); err != nil {
+		return err
+	}
+	return write(root, name, e, data)
+}
+
+// own records name in the manifest before the file is first written, so a
+// crash in between cannot leave a file the subscriber does not know about.
+func (sub *subscriber) own(root *os.Root, name string) error {
+	if sub.owned[name] {
+		return nil
+	}
+	sub.owned[name] = true
+	return writeManifest(root, sub.owned)
+}
+
+func readManifest(root *os.Root) (map[string]bool, error) {
+	owned := make(map[string]bool)
+	data, err := root.ReadFile(manifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return owned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("read %s: %w", manifestName, err)
+	}
+	for _, name := range names {
+		owned[name] = true
+	}
+	return owned, nil
+}
+
+func writeManifest(root *os.Root, owned map[string]bool) error {
+	data, err := json.Marshal(slices.Sorted(maps.Keys(owned)))
+	if err != nil {
+		return err
+	}
+	return write(root, manifestName, Entry{Mode: 0644, ModTime: time.Now()}, data)
+}
+
+// write replaces a file at once by renaming a complete copy over it.
+func write(root *os.Root, name string, e Entry, data []byte) error {
+	dir := path.Dir(name)
+	if err := root.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, tmpPrefix+path.Base(name))
+	if err := root.WriteFile(tmp, data, e.Mode.Perm()); err != nil {
+		return err
+	}
+	err := errors.Join(root.Chmod(tmp, e.Mode.Perm()), root.Chtimes(tmp, e.ModTime, e.ModTime))
+	if err == nil {
+		err = root.Rename(tmp, name)
+	}
+	if err != nil {
+		root.Remove(tmp)
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// remove deletes a file and the directories it leaves empty.
+func remove(root *os.Root, name string) error {
+	if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if root.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// policy returns what a request needs. body is the request's body, which
+// the handler can still read.
+type policy func(r *http.Request, body []byte) ([]auth.Need, error)
+
+// policies lists what each endpoint needs. Endpoints missing from it need
+// admin access to the cluster.
+var policies = map[string]policy{
+	"/put":      field(auth.KV, "key", auth.Write),
+	"/put-file": field(auth.KV, "key", auth.Write),
+	"/incr":     field(auth.KV, "key", auth.Write),
+	"/del":      field(auth.KV, "key", auth.Write),
+	"/get":      query(auth.KV, "key", auth.Read),
+	"/history":  query(auth.KV, "key", auth.Read),
+	"/download": query(auth.KV, "key", auth.Read),
+	"/txn":      txnNeeds,
+	"/retention": byMethod(map[string]policy{
+		http.MethodGet:  fixed(auth.KV, auth.Read),
+		http.MethodPost: field(auth.KV, "prefix", auth.Admin),
+	}),
+
+	"/lease/grant":     fixed(auth.Lease, auth.Write),
+	"/lease/keepalive": fixed(auth.Lease, auth.Write),
+	"/lease/revoke":    fixed(auth.Lease, auth.Write),
+	"/leases":          fixed(auth.Lease, auth.Read),
+	"/lock/acquire":    field(auth.Lock, "name", auth.Write),
+	"/lock/release":    field(auth.Lock, "name", auth.Write),
+
+	"/queue/enqueue": field(auth.Queue, "queue", auth.Write),
+	"/queue/dequeue": field(auth.Queue, "queue", auth.Write),
+	"/queue/ack":     field(auth.Queue, "queue", auth.Write),
+	"/queue/peek":    query(auth.Queue, "queue", auth.Read),
+	"/queue/len":     query(auth.Queue, "queue", auth.Read),
+
+	"/index": byMethod(map[string]policy{
+		http.MethodGet:    fixed(auth.Index, auth.Read),
+		http.MethodPost:   field(auth.Index, "name", auth.Admin),
+		http.MethodDelete: query(auth.Index, "name", auth.Admin),
+	}),
+	"/query": query(auth.Index, "index", auth.Read),
+
+	"/v1/kv/get":          query(auth.KV, "key", auth.Read),
+	"/v1/kv/scan":         query(auth.KV, "prefix", auth.Read),
+	"/v1/kv/watch":        query(auth.KV, "prefix", auth.Read),
+	"/v1/kv/put":          field(auth.KV, "key", auth.Write),
+	"/v1/kv/delete":       field(auth.KV, "key", auth.Write),
+	"/v1/cluster/members": fixed(auth.Cluster, auth.None),
+	"/v1/cluster/node":    fixed(auth.Cluster, auth.None),
+	"/v1/auth/whoami":     fixed(auth.Cluster, auth.None),
+	"/v1/cluster/status":  fixed(auth.Cluster, auth.Read),
+	"/metrics":            fixed(auth.Cluster, auth.Read),
+	"/status":             fixed(auth.Cluster, auth.Read),
+}
+
+// public lists the endpoints served without a token: the probes of load
+// balancers and supervisors, which have none.
+var public = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// authenticate wraps next so that, with auth enabled, every request
+// carries a known bearer token granting what its endpoint needs.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	a := s.svc.Auth()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() || public[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := a.Authenticate(auth.BearerToken(r.Header.Get("Authorization")))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hyphora"`)
+			writeError(w, err)
+			return
+		}
+		r = r.WithContext(auth.WithIdentity(r.Context(), id))
+
+		var body []byte
+		if r.Body != nil && r.Method != http.MethodGet {
+			if body, err = io.ReadAll(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		p, ok := policies[r.URL.Path]
+		if !ok {
+			p = fixed(auth.Cluster, auth.Admin)
+		}
+		needs, err := p(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.CheckAll(r.Context(), needs); err != nil {
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fixed needs access to a whole namespace.
+func fixed(ns string, access auth.Access) policy {
+	return func(*http.Request, []byte) ([]auth.Need, error) {
+		return []auth.Need{{Namespace: ns, Access: access}}, nil
+	}
+}
+
+// query needs access to the resource named by a query parameter.
+func query(ns, param string, access auth.Access) policy {
+	return func(r *http.Request, _ []byte) ([]auth.Need, error) {
+		return []auth.Need{{Namespace: ns, Name: r.URL.Query().Get(param), Access: access}}, nil
+	}
+}
+
+// field needs access to the resource named by a field of the JSON body.
+// A body that does not decode is left for the handler to reject, with the
+// name taken as empty, which only a rule for the whole namespace allows.
+func field(ns, name string, access auth.Access) policy {
+	return func(r *http.Request, body []byte) ([]auth.Need, error) {
+		var fields map[string]any
+		json.Unmarshal(body, &fields)
+		v, _ := fields[name].(string)
+		return []auth.Need{{Namespace: ns, Name: v, Access: access}}, nil
+	}
+}
+
+// byMethod applies the policy of the request's method. Other methods need
+// admin access to the cluster; the handler rejects them anyway.
+func byMethod(m map[string]policy) policy {
+	return func(r *http.Request, body []byte) ([]auth.Need, error) {
+		if p, ok := m[r.Method]; ok {
+			return p(r, body)
+		}
+		return fixed(auth.Cluster, auth.Admin)(r, body)
+	}
+}
+
+// txnNeeds reads every compared key and reads or writes every key the
+// transaction operates on.
+func txnNeeds(r *http.Request, body []byte) ([]auth.Need, error) {
+	var txn raftnode.Txn
+	if err := json.Unmarshal(body, &txn); err != nil {
+		return nil, err
+	}
+	var needs []auth.Need
+	for _, c := range txn.Compare {
+		needs = append(needs, auth.Need{Namespace: auth.KV, Name: c.Key, Access: auth.Read})
+	}
+	for _, op := range append(txn.Success, txn.Failure...) {
+		access := auth.Write
+		if op.Op == "get" {
+			access = auth.Read
+		}
+		needs = append(needs, auth.Need{Namespace: auth.KV, Name: op.Key, Access: access})
+	}
+	return needs, nil
+}
+
+// registerAuthHandlers serves the management of roles and tokens.
+func (s *Server) registerAuthHandlers() {
+	svc := s.svc
+
+	s.mux.HandleFunc("/v1/auth/roles", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, api.RolesResponse{Roles: svc.Roles(r.Context())})
+	})
+
+	s.mux.HandleFunc("/v1/auth/roles/put", func(w http.ResponseWriter, r *http.Request) {
+		var req api.PutRoleRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.PutRole(r.Context(), req.Role); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.PutRoleResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/auth/roles/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req api.DeleteRoleRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.DeleteRole(r.Context(), req.Name); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.DeleteRoleResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, api.TokensResponse{Tokens: svc.Tokens(r.Context())})
+	})
+
+	s.mux.HandleFunc("/v1/auth/tokens/create", func(w http.ResponseWriter, r *http.Request) {
+		var req api.CreateTokenRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		resp, err := svc.CreateToken(r.Context(), req.Name, req.Roles)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, resp)
+	})
+
+	s.mux.HandleFunc("/v1/auth/tokens/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req api.DeleteTokenRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.DeleteToken(r.Context(), req.ID); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.DeleteTokenResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/auth/whoami", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, svc.WhoAmI(r.Context()))
+	})
+}
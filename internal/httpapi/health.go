@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+)
+
+// registerHealthHandlers serves the probes of load balancers and
+// supervisors, and the node's status.
+func (s *Server) registerHealthHandlers() {
+	// /healthz answers as long as the process serves HTTP.
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+
+	// /readyz answers 503 with the reason while the node is not ready.
+	s.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.svc.Ready(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+
+	status := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.svc.Status(r.Context()))
+	}
+	s.mux.HandleFunc("/status", status)
+	s.mux.HandleFunc("/v1/cluster/status", status)
+}
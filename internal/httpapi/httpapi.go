@@ -0,0 +1,84 @@
+// Package httpapi serves the HTTP API of a node. Every node answers every
+// request; writes made on a follower are forwarded to the leader.
+package httpapi
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/AMS003010/Hyphora/internal/filerepl"
+	"github.com/AMS003010/Hyphora/internal/filesync"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/service"
+)
+
+type Server struct {
+	svc     *service.Service
+	node    *raftnode.Node
+	mux     *http.ServeMux
+	handler http.Handler
+	log     *slog.Logger
+	files   *filerepl.Reader
+	sync    *filesync.Syncer
+}
+
+func New(svc *service.Service) *Server {
+	s := &Server{svc: svc, node: svc.Node(), mux: http.NewServeMux(), log: svc.Logger().With("component", "http")}
+	s.registerKVHandlers()
+	s.registerLeaseHandlers()
+	s.registerQueueHandlers()
+	s.registerIndexHandlers()
+	s.registerV1Handlers()
+	s.registerAuthHandlers()
+	s.registerHealthHandlers()
+	s.handler = s.instrument(s.authenticate(s.mux))
+	return s
+}
+
+// SetFiles lets /replicate read files through f. Without it, file
+// replication is disabled.
+func (s *Server) SetFiles(f *filerepl.Reader) {
+	s.files = f
+}
+
+// SetSync lets /v1/sync report on the directories synced by sync.
+func (s *Server) SetSync(sync *filesync.Syncer) {
+	s.sync = sync
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// writeError answers with the status matching err's API code, so every
+// handler reports the same failure the same way as the gRPC server.
+func writeError(w http.ResponseWriter, err error) {
+	e := service.AsError(err)
+	http.Error(w, e.Message, e.Code.HTTPStatus())
+}
+
+// decodePost requires a POST and decodes its JSON body into v.
+func decodePost(w http.ResponseWriter, r *http.Request, v any) bool {
+	if !requirePost(w, r) {
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+func (s *Server) registerIndexHandlers() {
+	svc := s.svc
+	s.mux.HandleFunc("/index", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeJSON(w, svc.Indexes(r.Context()))
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "GET, POST or DELETE required", http.StatusMethodNotAllowed)
+			return
+		}
+		var err error
+		if r.Method == http.MethodDelete {
+			err = svc.DropIndex(r.Context(), r.URL.Query().Get("name"))
+		} else {
+			var def raftnode.IndexDef
+			if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = svc.DefineIndex(r.Context(), def)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		name := q.Get("index")
+		if name == "" {
+			http.Error(w, "Missing 'index' query param", http.StatusBadRequest)
+			return
+		}
+		var from, to *string
+		if q.Has("from") {
+			v := q.Get("from")
+			from = &v
+		}
+		if q.Has("to") {
+			v := q.Get("to")
+			to = &v
+		}
+		if q.Has("eq") {
+			v := q.Get("eq")
+			from, to = &v, &v
+		}
+		keys, err := svc.QueryIndex(r.Context(), name, from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{"index": name, "keys": keys})
+	})
+}
@@ -0,0 +1,319 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/filerepl"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/hashicorp/raft"
+)
+
+// registerKVHandlers serves the original, unversioned endpoints.
+func (s *Server) registerKVHandlers() {
+	node, svc := s.node, s.svc
+
+	s.mux.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+			Lease uint64 `json:"lease"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.Put(r.Context(), req.Key, []byte(req.Value), req.Lease); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/incr", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Key   string `json:"key"`
+			Delta *int64 `json:"delta"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Key == "" {
+			http.Error(w, "'key' required", http.StatusBadRequest)
+			return
+		}
+		delta := int64(1)
+		if req.Delta != nil {
+			delta = *req.Delta
+		}
+		n, err := svc.Add(r.Context(), req.Key, delta)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"key":   req.Key,
+			"value": n,
+		})
+	})
+
+	s.mux.HandleFunc("/put-file", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Value)
+		if err != nil {
+			http.Error(w, "invalid base64 value: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.Put(r.Context(), req.Key, data, 0); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		var index uint64
+		if version := r.URL.Query().Get("version"); version != "" {
+			var err error
+			if index, err = strconv.ParseUint(version, 10, 64); err != nil {
+				http.Error(w, "invalid version", http.StatusBadRequest)
+				return
+			}
+		}
+		kv, err := svc.Get(r.Context(), key, index)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if kv.Version != 0 {
+			w.Header().Set("X-Hyphora-Version", strconv.FormatUint(kv.Version, 10))
+		}
+		w.Write(kv.Value)
+	})
+
+	s.mux.HandleFunc("/txn", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var txn raftnode.Txn
+		if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := svc.Txn(r.Context(), txn)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !res.Succeeded {
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+
+	s.mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "Missing 'key' query param", http.StatusBadRequest)
+			return
+		}
+		versions, err := svc.History(r.Context(), key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"key":      key,
+			"versions": versions,
+		})
+	})
+
+	s.mux.HandleFunc("/retention", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(svc.RetentionPolicies(r.Context()))
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var policy bitcask.RetentionPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.SetRetention(r.Context(), policy); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/del", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.Delete(r.Context(), req.Key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/addpeer", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		addr := r.URL.Query().Get("addr")
+		if err := svc.AddPeer(r.Context(), id, addr, false); err != nil {
+			writeError(w, err)
+			return
+		}
+		fmt.Fprintf(w, "Peer %s (%s) added successfully\n", id, addr)
+	})
+
+	s.mux.HandleFunc("/compact", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := svc.Compact(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Compaction completed")
+	})
+
+	// /replicate stores a file read from this node's disk. Only files
+	// under the configured roots can be read, and every request is logged.
+	s.mux.HandleFunc("/replicate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Path        string `json:"path"`
+			RelativeKey *bool  `json:"relative_key"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReplicateBody)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			http.Error(w, "'path' required", http.StatusBadRequest)
+			return
+		}
+
+		f, err := s.files.Read(req.Path, req.RelativeKey)
+		if err != nil {
+			s.auditReplicate(r, req.Path, nil, err)
+			writeReplicateError(w, err)
+			return
+		}
+
+		from := "follower"
+		if node.Raft.State() == raft.Leader {
+			from = "leader"
+		}
+		err = svc.Put(r.Context(), f.Key, f.Data, 0)
+		s.auditReplicate(r, req.Path, f, err)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "replicated",
+			"key":    f.Key,
+			"size":   len(f.Data),
+			"from":   from,
+		})
+	})
+
+	s.mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "Missing 'key' query param", http.StatusBadRequest)
+			return
+		}
+
+		kv, err := svc.Get(r.Context(), key, 0)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", key))
+		w.Write(kv.Value)
+	})
+}
+
+// maxReplicateBody bounds the JSON body of /replicate.
+const maxReplicateBody = 64 << 10
+
+func writeReplicateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, filerepl.ErrDisabled), errors.Is(err, filerepl.ErrOutsideRoots), errors.Is(err, fs.ErrPermission):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, filerepl.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, fmt.Sprintf("Cannot read file: %v", err), http.StatusBadRequest)
+	}
+}
+
+// auditReplicate logs who asked to replicate which file and the outcome.
+func (s *Server) auditReplicate(r *http.Request, path string, f *filerepl.File, err error) {
+	caller := "-"
+	if id := auth.FromContext(r.Context()); id != nil {
+		caller = id.Name
+	}
+	resolved, key, size := "-", "-", 0
+	if f != nil {
+		resolved, key, size = f.Path, f.Key, len(f.Data)
+	}
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	s.log.InfoContext(r.Context(), "replicate", "caller", caller, "remote", r.RemoteAddr, "path", path, "resolved", resolved, "key", key, "size", size, "result", result)
+}
@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+func (s *Server) registerLeaseHandlers() {
+	svc := s.svc
+	s.mux.HandleFunc("/lease/grant", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			TTL string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		lease, err := svc.GrantLease(r.Context(), ttl)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, lease)
+	})
+
+	s.mux.HandleFunc("/lease/keepalive", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lease, err := svc.KeepAliveLease(r.Context(), req.ID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, lease)
+	})
+
+	s.mux.HandleFunc("/lease/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.RevokeLease(r.Context(), req.ID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/leases", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, svc.Leases(r.Context()))
+	})
+
+	s.mux.HandleFunc("/lock/acquire", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			Name  string `json:"name"`
+			Owner string `json:"owner"`
+			TTL   string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "'name' required", http.StatusBadRequest)
+			return
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		lock, err := svc.AcquireLock(r.Context(), req.Name, req.Owner, ttl)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, lock)
+	})
+
+	s.mux.HandleFunc("/lock/release", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			Name  string `json:"name"`
+			Token uint64 `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.ReleaseLock(r.Context(), req.Name, req.Token); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
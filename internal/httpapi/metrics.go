@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/service"
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// SetMetrics serves m, usually a metrics.Sink, on /metrics.
+func (s *Server) SetMetrics(m http.Handler) {
+	s.mux.Handle("/metrics", m)
+}
+
+// instrument gives every request a request ID, the X-Request-ID header
+// the client sent or a new one, which is echoed in the response and named
+// by every line logged for the request. It logs requests at debug level,
+// and records their count and latency by endpoint and method, and their
+// count by status too. Endpoints are the mux patterns, so unknown paths
+// do not each get a series.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := service.RequestID(r.Header.Get(service.RequestIDHeader))
+		w.Header().Set(service.RequestIDHeader, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		_, pattern := s.mux.Handler(r)
+		if pattern == "" {
+			pattern = "other"
+		}
+		s.log.DebugContext(r.Context(), "http request", "method", r.Method, "path", r.URL.Path, "status", sw.status, "duration", time.Since(start), "remote", r.RemoteAddr)
+		labels := []metrics.Label{{Name: "endpoint", Value: pattern}, {Name: "method", Value: r.Method}}
+		metrics.MeasureSinceWithLabels([]string{"http", "latency"}, start, labels)
+		labels = append(labels, metrics.Label{Name: "code", Value: strconv.Itoa(sw.status)})
+		metrics.IncrCounterWithLabels([]string{"http", "requests"}, 1, labels)
+	})
+}
+
+// statusWriter remembers the status a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets watches stream through the writer.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
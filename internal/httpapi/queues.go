@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+func (s *Server) registerQueueHandlers() {
+	svc := s.svc
+	s.mux.HandleFunc("/queue/enqueue", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			Queue string `json:"queue"`
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		seq, err := svc.Enqueue(r.Context(), req.Queue, []byte(req.Value))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, map[string]any{"queue": req.Queue, "seq": seq})
+	})
+
+	s.mux.HandleFunc("/queue/dequeue", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			Queue      string `json:"queue"`
+			Visibility string `json:"visibility"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var visibility time.Duration
+		if req.Visibility != "" {
+			d, err := time.ParseDuration(req.Visibility)
+			if err != nil {
+				http.Error(w, "invalid visibility: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			visibility = d
+		}
+		item, err := svc.Dequeue(r.Context(), req.Queue, visibility)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeQueueItem(w, req.Queue, item)
+	})
+
+	s.mux.HandleFunc("/queue/ack", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req struct {
+			Queue   string `json:"queue"`
+			Receipt uint64 `json:"receipt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := svc.AckQueue(r.Context(), req.Queue, req.Receipt); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.mux.HandleFunc("/queue/peek", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("queue")
+		item, err := svc.PeekQueue(r.Context(), name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeQueueItem(w, name, item)
+	})
+
+	s.mux.HandleFunc("/queue/len", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := svc.QueueLen(r.Context(), r.URL.Query().Get("queue"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, stats)
+	})
+}
+
+func writeQueueItem(w http.ResponseWriter, queue string, item *raftnode.QueueItem) {
+	writeJSON(w, struct {
+		Queue string `json:"queue"`
+		*raftnode.QueueItem
+		Value string `json:"value"`
+	}{queue, item, string(item.Value)})
+}
@@ -0,0 +1,239 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/AMS003010/Hyphora/internal/backup"
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// registerV1Handlers serves the versioned HTTP API, which mirrors the gRPC
+// services message for message.
+func (s *Server) registerV1Handlers() {
+	svc := s.svc
+	s.mux.HandleFunc("/v1/kv/get", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var version uint64
+		if v := q.Get("version"); v != "" {
+			var err error
+			if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "invalid version", http.StatusBadRequest)
+				return
+			}
+		}
+		kv, err := svc.Get(r.Context(), q.Get("key"), version)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.GetResponse{KeyValue: *kv})
+	})
+
+	s.mux.HandleFunc("/v1/kv/put", func(w http.ResponseWriter, r *http.Request) {
+		var req api.PutRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.Put(r.Context(), req.Key, req.Value, req.Lease); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.PutResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/kv/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req api.DeleteRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.Delete(r.Context(), req.Key); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.DeleteResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/kv/scan", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		limit := 0
+		if l := q.Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		items, more, err := svc.Scan(r.Context(), q.Get("prefix"), q.Get("after"), limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.ScanResponse{Items: items, More: more})
+	})
+
+	// /v1/kv/watch streams one JSON event per line until the client goes
+	// away. A watcher that falls behind is disconnected.
+	s.mux.HandleFunc("/v1/kv/watch", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		events, cancel := svc.Watch(r.Context(), r.URL.Query().Get("prefix"))
+		defer cancel()
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(api.WatchEvent{Type: ev.Type, Key: ev.Key, Value: ev.Value, Version: ev.Version}); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+
+	s.mux.HandleFunc("/v1/cluster/members", func(w http.ResponseWriter, r *http.Request) {
+		members, err := svc.Members(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.MembersResponse{Members: members})
+	})
+
+	s.mux.HandleFunc("/v1/cluster/node", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, svc.NodeInfo(r.Context()))
+	})
+
+	s.mux.HandleFunc("/v1/cluster/add-peer", func(w http.ResponseWriter, r *http.Request) {
+		var req api.AddPeerRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.AddPeer(r.Context(), req.ID, req.Address, req.NonVoter); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.AddPeerResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/cluster/remove-peer", func(w http.ResponseWriter, r *http.Request) {
+		var req api.RemovePeerRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.RemovePeer(r.Context(), req.ID, req.Force); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.RemovePeerResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/cluster/demote-peer", func(w http.ResponseWriter, r *http.Request) {
+		var req api.DemotePeerRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.DemotePeer(r.Context(), req.ID, req.Force); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.DemotePeerResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/cluster/transfer-leader", func(w http.ResponseWriter, r *http.Request) {
+		var req api.TransferLeaderRequest
+		if !decodePost(w, r, &req) {
+			return
+		}
+		if err := svc.TransferLeader(r.Context(), req.ID, req.Force); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.TransferLeaderResponse{})
+	})
+
+	s.mux.HandleFunc("/v1/admin/compact", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		if err := svc.Compact(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, api.CompactResponse{})
+	})
+
+	// /v1/admin/snapshot takes an empty body as well as a SnapshotRequest.
+	s.mux.HandleFunc("/v1/admin/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		var req api.SnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snap, err := svc.Snapshot(r.Context(), req.Truncate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, snap)
+	})
+
+	s.mux.HandleFunc("/v1/admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		snaps, err := svc.Snapshots(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, snaps)
+	})
+
+	// /v1/admin/backup streams a backup archive of this node's state; with
+	// ?since=N, of the changes after index N only.
+	s.mux.HandleFunc("/v1/admin/backup", func(w http.ResponseWriter, r *http.Request) {
+		var since uint64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+		m, size, state, err := svc.Backup(r.Context(), since)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer state.Close()
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="hyphora-%s-%d.tar"`, m.Kind, m.Index))
+		if err := backup.Write(w, &m, size, state); err != nil {
+			// The status is sent; the client sees a truncated archive.
+			s.log.ErrorContext(r.Context(), "failed to write backup", "err", err)
+			return
+		}
+		s.log.InfoContext(r.Context(), "backup written", "kind", m.Kind, "index", m.Index, "since", m.Since, "size", m.Size)
+	})
+
+	// /v1/sync reports on the directories this node publishes and
+	// subscribes to.
+	s.mux.HandleFunc("/v1/sync", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"dirs": s.sync.Status()})
+	})
+}
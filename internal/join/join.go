@@ -0,0 +1,133 @@
+// Package join makes a node part of a cluster through seed nodes: it asks
+// an existing cluster to add the node, or, when a cluster is to be formed
+// by a number of nodes starting together, bootstraps it once that many
+// nodes know of each other.
+package join
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"github.com/AMS003010/Hyphora/pkg/client"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	retryInterval = time.Second
+	probeTimeout  = 2 * time.Second
+)
+
+type Config struct {
+	// Seeds are the HTTP addresses of other nodes; they may include this
+	// node's own.
+	Seeds []string
+	// Expect, when above zero, is the number of nodes that form a new
+	// cluster together.
+	Expect int
+	// TLS is used to reach the seeds over HTTPS.
+	TLS *tls.Config
+	// Token authenticates the node to seeds that require one.
+	Token string
+}
+
+// Run returns once node is part of a cluster or ctx is done. With
+// cfg.Expect > 0, Run bootstraps a cluster of the first Expect nodes,
+// ordered by ID, as soon as that many seeds are up and none of them
+// belongs to a cluster yet. Every node of a new cluster must be started
+// with the same seeds and expect, so all of them bootstrap the same
+// configuration.
+func Run(ctx context.Context, node *raftnode.Node, cfg Config) error {
+	if node.InCluster() {
+		return nil
+	}
+	seeds, expect := cfg.Seeds, cfg.Expect
+	c, err := client.New(client.Config{Endpoints: seeds, MaxRetries: -1, TLS: cfg.TLS, Token: cfg.Token})
+	if err != nil {
+		return err
+	}
+	self := node.Meta()
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		done, err := attempt(ctx, c, node, self, seeds, expect)
+		if done {
+			return err
+		}
+		if err != nil {
+			logger(node).Warn("not in a cluster yet", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// attempt tries once to join or bootstrap and reports whether node is
+// now part of a cluster.
+func attempt(ctx context.Context, c *client.Client, node *raftnode.Node, self raftnode.NodeMeta, seeds []string, expect int) (bool, error) {
+	if node.InCluster() {
+		return true, nil
+	}
+	pending := []api.NodeInfoResponse{{ID: self.ID, RaftAddress: self.RaftAddr}}
+	clustered := false
+	for _, seed := range seeds {
+		pctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		info, err := c.NodeInfo(pctx, seed)
+		cancel()
+		if err != nil || info.ID == self.ID {
+			continue
+		}
+		if info.InCluster {
+			clustered = true
+			break
+		}
+		if !slices.ContainsFunc(pending, func(p api.NodeInfoResponse) bool { return p.ID == info.ID }) {
+			pending = append(pending, *info)
+		}
+	}
+
+	if clustered || expect == 0 {
+		actx, cancel := context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+		if err := c.AddPeer(actx, self.ID, self.RaftAddr); err != nil {
+			return false, fmt.Errorf("ask the cluster to add %s: %w", self.ID, err)
+		}
+		logger(node).Info("joined the cluster", "seeds", strings.Join(seeds, ","))
+		return true, nil
+	}
+
+	if len(pending) < expect {
+		return false, fmt.Errorf("waiting for %d nodes to bootstrap, found %d", expect, len(pending))
+	}
+	slices.SortFunc(pending, func(a, b api.NodeInfoResponse) int { return strings.Compare(a.ID, b.ID) })
+	pending = pending[:expect]
+	if !slices.ContainsFunc(pending, func(p api.NodeInfoResponse) bool { return p.ID == self.ID }) {
+		// The cluster is formed by the others; wait to be added to it.
+		return false, nil
+	}
+	servers := make([]raft.Server, len(pending))
+	ids := make([]string, len(pending))
+	for i, p := range pending {
+		servers[i] = raft.Server{ID: raft.ServerID(p.ID), Address: raft.ServerAddress(p.RaftAddress)}
+		ids[i] = p.ID
+	}
+	if err := node.BootstrapCluster(servers); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		return false, fmt.Errorf("bootstrap: %w", err)
+	}
+	logger(node).Info("bootstrapped a cluster", "members", strings.Join(ids, ","))
+	return true, nil
+}
+
+func logger(node *raftnode.Node) *slog.Logger {
+	return node.Logger().With("component", "join")
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// levelTrace is below slog's debug level, for hclog's trace lines.
+const levelTrace = slog.LevelDebug - 4
+
+// HCLog adapts l to hclog, for the Raft library. Lines are logged with a
+// component attribute of name; the level is l's, so SetLevel does nothing.
+func HCLog(l *slog.Logger, name string) hclog.Logger {
+	return &hcLogger{l: l.With("component", name), base: l, name: name}
+}
+
+type hcLogger struct {
+	l    *slog.Logger
+	base *slog.Logger
+	name string
+	args []any
+}
+
+func slogLevel(level hclog.Level) slog.Level {
+	switch level {
+	case hclog.Trace:
+		return levelTrace
+	case hclog.Debug:
+		return slog.LevelDebug
+	case hclog.Warn:
+		return slog.LevelWarn
+	case hclog.Error:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (h *hcLogger) Log(level hclog.Level, msg string, args ...any) {
+	h.l.Log(context.Background(), slogLevel(level), msg, args...)
+}
+
+func (h *hcLogger) Trace(msg string, args ...any) { h.Log(hclog.Trace, msg, args...) }
+func (h *hcLogger) Debug(msg string, args ...any) { h.Log(hclog.Debug, msg, args...) }
+func (h *hcLogger) Info(msg string, args ...any)  { h.Log(hclog.Info, msg, args...) }
+func (h *hcLogger) Warn(msg string, args ...any)  { h.Log(hclog.Warn, msg, args...) }
+func (h *hcLogger) Error(msg string, args ...any) { h.Log(hclog.Error, msg, args...) }
+
+func (h *hcLogger) enabled(level slog.Level) bool {
+	return h.l.Enabled(context.Background(), level)
+}
+
+func (h *hcLogger) IsTrace() bool { return h.enabled(levelTrace) }
+func (h *hcLogger) IsDebug() bool { return h.enabled(slog.LevelDebug) }
+func (h *hcLogger) IsInfo() bool  { return h.enabled(slog.LevelInfo) }
+func (h *hcLogger) IsWarn() bool  { return h.enabled(slog.LevelWarn) }
+func (h *hcLogger) IsError() bool { return h.enabled(slog.LevelError) }
+
+func (h *hcLogger) ImpliedArgs() []any {
+	return h.args
+}
+
+func (h *hcLogger) With(args ...any) hclog.Logger {
+	return &hcLogger{l: h.l.With(args...), base: h.base, name: h.name, args: append(append([]any(nil), h.args...), args...)}
+}
+
+func (h *hcLogger) Name() string {
+	return h.name
+}
+
+func (h *hcLogger) Named(name string) hclog.Logger {
+	if h.name != "" {
+		name = h.name + "." + name
+	}
+	return h.ResetNamed(name)
+}
+
+func (h *hcLogger) ResetNamed(name string) hclog.Logger {
+	return &hcLogger{l: h.base.With("component", name).With(h.args...), base: h.base, name: name, args: h.args}
+}
+
+func (h *hcLogger) SetLevel(hclog.Level) {}
+
+func (h *hcLogger) GetLevel() hclog.Level {
+	for _, level := range []hclog.Level{hclog.Trace, hclog.Debug, hclog.Info, hclog.Warn} {
+		if h.enabled(slogLevel(level)) {
+			return level
+		}
+	}
+	return hclog.Error
+}
+
+func (h *hcLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	level := slog.LevelInfo
+	if opts != nil && opts.ForceLevel != hclog.NoLevel {
+		level = slogLevel(opts.ForceLevel)
+	}
+	return slog.NewLogLogger(h.l.Handler(), level)
+}
+
+func (h *hcLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return h.StandardLogger(opts).Writer()
+}
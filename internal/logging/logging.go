@@ -0,0 +1,100 @@
+// Package logging builds the node's structured logger and carries request
+// IDs through contexts, so every line logged while serving a request names
+// it.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Config selects the output format, "text" or "json", and the lowest level
+// logged: "debug", "info", "warn" or "error".
+type Config struct {
+	Format string
+	Level  string
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("log level %q: want debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// Validate checks the format and level.
+func (c Config) Validate() error {
+	switch strings.ToLower(c.Format) {
+	case "text", "json":
+	default:
+		return fmt.Errorf("log format %q: want text or json", c.Format)
+	}
+	_, err := ParseLevel(c.Level)
+	return err
+}
+
+// New returns a logger writing to w as cfg says. The level can be changed
+// later through the returned LevelVar.
+func New(w io.Writer, cfg Config) (*slog.Logger, *slog.LevelVar, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	level := new(slog.LevelVar)
+	l, _ := ParseLevel(cfg.Level)
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if strings.ToLower(cfg.Format) == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h}), level, nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or "" if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// contextHandler adds the request ID of the context a line is logged with.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
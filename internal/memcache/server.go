@@ -0,0 +1,464 @@
+// Package memcache serves the memcached text protocol on top of the
+// replicated store. CAS tokens are the per-key versions, exptime maps onto
+// leases and the client flags are stored with each value.
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/netutil"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+const (
+	maxKeyLen   = 250
+	maxItemSize = 1 << 20
+	maxLineLen  = 2048
+
+	// An exptime up to 30 days is relative to now, anything larger is a
+	// Unix timestamp, as in memcached.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+
+	// casRetries bounds how often incr, decr and replace re-read a key that
+	// changed under them.
+	casRetries = 10
+)
+
+var errBadFormat = errors.New("bad command line format")
+
+// Server answers memcached clients. Writes go through the service, so a
+// follower proxies them to the leader; reads come from the local copy.
+type Server struct {
+	svc  *service.Service
+	node *raftnode.Node
+	log  *slog.Logger
+
+	conns netutil.Conns
+}
+
+func NewServer(svc *service.Service) *Server {
+	return &Server{svc: svc, node: svc.Node(), log: svc.Logger().With("component", "memcache")}
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l, s.handle)
+}
+
+// Shutdown stops accepting connections and closes each open one once the
+// command it is running is answered, waiting for them until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReaderSize(conn, maxLineLen)
+	w := bufio.NewWriter(conn)
+	ctx := context.Background()
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			w.WriteString("CLIENT_ERROR line too long\r\n")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if !s.dispatch(ctx, r, w, fields) {
+			w.Flush()
+			return
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				s.log.Debug("failed to write reply", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// dispatch runs one command and reports whether the connection stays open.
+func (s *Server) dispatch(ctx context.Context, r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	cmd, args := fields[0], fields[1:]
+	var reply string
+	noreply := cmd != "get" && cmd != "gets" && len(args) > 0 && args[len(args)-1] == "noreply"
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			reply = "ERROR"
+			break
+		}
+		s.get(ctx, w, args, cmd == "gets")
+		return true
+	case "set", "add", "replace", "cas":
+		var ok bool
+		reply, ok = s.storage(ctx, r, cmd, args)
+		if !ok {
+			if reply != "" {
+				w.WriteString(reply + "\r\n")
+			}
+			return false
+		}
+	case "delete":
+		// "delete <key> 0" is an old form still sent by some clients.
+		if len(args) == 2 && args[1] == "0" {
+			args = args[:1]
+		}
+		if len(args) != 1 {
+			reply = "CLIENT_ERROR " + errBadFormat.Error()
+			break
+		}
+		reply = s.delete(args[0])
+	case "incr", "decr":
+		if len(args) != 2 {
+			reply = "CLIENT_ERROR " + errBadFormat.Error()
+			break
+		}
+		reply = s.incr(args[0], args[1], cmd == "decr")
+	case "touch":
+		if len(args) != 2 {
+			reply = "CLIENT_ERROR " + errBadFormat.Error()
+			break
+		}
+		reply = s.touch(args[0], args[1])
+	case "version":
+		reply = "VERSION hyphora"
+	case "verbosity":
+		reply = "OK"
+	case "quit":
+		return false
+	default:
+		reply = "ERROR"
+	}
+	if !noreply {
+		w.WriteString(reply + "\r\n")
+	}
+	return true
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLen || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// expiry turns a memcached exptime into a lease TTL. It reports expired
+// for times already in the past, which store nothing.
+func expiry(exptime string) (ttl time.Duration, expired bool, err error) {
+	n, err := strconv.ParseInt(exptime, 10, 64)
+	switch {
+	case err != nil:
+		return 0, false, errBadFormat
+	case n == 0:
+		return 0, false, nil
+	case n < 0:
+		return 0, true, nil
+	case n <= maxRelativeExptime:
+		ttl = time.Duration(n) * time.Second
+	default:
+		ttl = time.Until(time.Unix(n, 0))
+	}
+	if ttl <= 0 {
+		return 0, true, nil
+	}
+	// Leases last at least a second.
+	return max(ttl, time.Second), false, nil
+}
+
+func serverError(err error) string {
+	return "SERVER_ERROR " + service.AsError(err).Message
+}
+
+func (s *Server) get(ctx context.Context, w *bufio.Writer, keys []string, withCAS bool) {
+	for _, key := range keys {
+		if !validKey(key) {
+			continue
+		}
+		kv, err := s.svc.Get(ctx, key, 0)
+		if err != nil {
+			continue
+		}
+		flags := s.node.Flags(key)
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, flags, len(kv.Value), kv.Version)
+		} else {
+			fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, flags, len(kv.Value))
+		}
+		w.Write(kv.Value)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// storage handles set, add, replace and cas. It returns false when the
+// data block could not be read and the connection must be closed.
+func (s *Server) storage(ctx context.Context, r *bufio.Reader, cmd string, args []string) (string, bool) {
+	want := 4
+	if cmd == "cas" {
+		want = 5
+	}
+	if len(args) != want {
+		return "CLIENT_ERROR " + errBadFormat.Error(), true
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return "CLIENT_ERROR " + errBadFormat.Error(), false
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", false
+	}
+	if string(data[size:]) != "\r\n" {
+		return "CLIENT_ERROR bad data chunk", false
+	}
+	data = data[:size]
+
+	key := args[0]
+	flags, ferr := strconv.ParseUint(args[1], 10, 32)
+	ttl, expired, eerr := expiry(args[2])
+	if !validKey(key) || ferr != nil || eerr != nil {
+		return "CLIENT_ERROR " + errBadFormat.Error(), true
+	}
+	if size > maxItemSize {
+		return "SERVER_ERROR object too large for cache", true
+	}
+	var cas uint64
+	if cmd == "cas" {
+		if cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			return "CLIENT_ERROR " + errBadFormat.Error(), true
+		}
+	}
+
+	var lease uint64
+	if ttl > 0 {
+		l, err := s.node.GrantLease(ttl)
+		if err != nil {
+			return serverError(err), true
+		}
+		lease = l.ID
+	}
+	reply, err := s.store(cmd, key, data, uint32(flags), lease, expired, cas)
+	if err != nil {
+		reply = serverError(err)
+	}
+	if reply != "STORED" && lease != 0 {
+		if err := s.node.RevokeLease(lease); err != nil {
+			s.log.Warn("failed to revoke unused lease", "lease", lease, "err", err)
+		}
+	}
+	return reply, true
+}
+
+func (s *Server) store(cmd, key string, data []byte, flags uint32, lease uint64, expired bool, cas uint64) (string, error) {
+	// An item that expires on arrival is stored and immediately gone, so
+	// the write becomes a delete.
+	write := raftnode.TxnOp{Op: "put", Key: key, Value: data, Lease: lease, Flags: flags}
+	if expired {
+		write = raftnode.TxnOp{Op: "del", Key: key}
+	}
+	if cmd == "set" && !expired {
+		_, err := s.node.ApplyCommand(raftnode.Command{Op: raftnode.OpPut, Key: key, Val: data, Lease: lease, Flags: flags})
+		if err != nil {
+			return "", err
+		}
+		return "STORED", nil
+	}
+
+	read := raftnode.TxnOp{Op: "get", Key: key}
+	version, exists := s.node.Version(key)
+	if !exists && cmd != "add" {
+		cur, err := s.current(key)
+		if err != nil {
+			return "", err
+		}
+		version, exists = cur.Version, cur.Found
+	}
+	for range casRetries {
+		txn := raftnode.Txn{Success: []raftnode.TxnOp{write}, Failure: []raftnode.TxnOp{read}}
+		switch cmd {
+		case "add":
+			txn.Compare = []raftnode.Compare{{Key: key}}
+		case "replace":
+			if !exists {
+				return "NOT_STORED", nil
+			}
+			txn.Compare = []raftnode.Compare{{Key: key, Version: version}}
+		case "cas":
+			if cas == 0 {
+				// Version 0 would mean "absent"; no stored item has it.
+				if exists {
+					return "EXISTS", nil
+				}
+				return "NOT_FOUND", nil
+			}
+			txn.Compare = []raftnode.Compare{{Key: key, Version: cas}}
+		}
+		res, err := s.node.Txn(txn)
+		if err != nil {
+			return "", err
+		}
+		if res.Succeeded {
+			return "STORED", nil
+		}
+		cur := res.Responses[0]
+		switch cmd {
+		case "add":
+			return "NOT_STORED", nil
+		case "cas":
+			if cur.Found {
+				return "EXISTS", nil
+			}
+			return "NOT_FOUND", nil
+		}
+		// replace lost a race or read a stale version on a follower; use
+		// the version the leader saw.
+		version, exists = cur.Version, cur.Found
+	}
+	return "", fmt.Errorf("too much contention on %s", key)
+}
+
+// current reads key through the log. It is used when the key is missing
+// locally, since a follower may not have applied a write that the same
+// client just made through another node.
+func (s *Server) current(key string) (raftnode.TxnResponse, error) {
+	res, err := s.node.Txn(raftnode.Txn{Success: []raftnode.TxnOp{{Op: "get", Key: key}}})
+	if err != nil {
+		return raftnode.TxnResponse{}, err
+	}
+	return res.Responses[0], nil
+}
+
+func (s *Server) delete(key string) string {
+	if !validKey(key) {
+		return "CLIENT_ERROR " + errBadFormat.Error()
+	}
+	res, err := s.node.Txn(raftnode.Txn{Success: []raftnode.TxnOp{{Op: "get", Key: key}, {Op: "del", Key: key}}})
+	if err != nil {
+		return serverError(err)
+	}
+	if !res.Responses[0].Found {
+		return "NOT_FOUND"
+	}
+	return "DELETED"
+}
+
+// incr updates a counter with compare-and-swap on its version, so values
+// are unsigned 64-bit, incr wraps and decr stops at 0 like in memcached.
+// The key keeps its flags and expiry.
+func (s *Server) incr(key, delta string, decr bool) string {
+	if !validKey(key) {
+		return "CLIENT_ERROR " + errBadFormat.Error()
+	}
+	d, err := strconv.ParseUint(delta, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument"
+	}
+	var cur raftnode.TxnResponse
+	kv, err := s.svc.Get(context.Background(), key, 0)
+	switch {
+	case api.IsCode(err, api.CodeNotFound):
+		if cur, err = s.current(key); err != nil {
+			return serverError(err)
+		}
+	case err != nil:
+		return serverError(err)
+	default:
+		cur = raftnode.TxnResponse{Key: key, Value: kv.Value, Version: kv.Version, Flags: s.node.Flags(key), Found: true}
+	}
+	for range casRetries {
+		if !cur.Found {
+			return "NOT_FOUND"
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(string(cur.Value)), 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+		}
+		switch {
+		case !decr:
+			n += d
+		case d > n:
+			n = 0
+		default:
+			n -= d
+		}
+		val := strconv.FormatUint(n, 10)
+		var lease uint64
+		if l, ok := s.node.KeyLease(key); ok {
+			lease = l.ID
+		}
+		res, err := s.node.Txn(raftnode.Txn{
+			Compare: []raftnode.Compare{{Key: key, Version: cur.Version}},
+			Success: []raftnode.TxnOp{{Op: "put", Key: key, Value: []byte(val), Lease: lease, Flags: cur.Flags}},
+			Failure: []raftnode.TxnOp{{Op: "get", Key: key}},
+		})
+		if errors.Is(err, raftnode.ErrLeaseNotFound) {
+			// The key's lease expired meanwhile; so has the key.
+			return "NOT_FOUND"
+		}
+		if err != nil {
+			return serverError(err)
+		}
+		if res.Succeeded {
+			return val
+		}
+		cur = res.Responses[0]
+	}
+	return serverError(fmt.Errorf("too much contention on %s", key))
+}
+
+func (s *Server) touch(key, exptime string) string {
+	ttl, expired, err := expiry(exptime)
+	if !validKey(key) || err != nil {
+		return "CLIENT_ERROR " + errBadFormat.Error()
+	}
+	if expired {
+		if s.delete(key) == "DELETED" {
+			return "TOUCHED"
+		}
+		return "NOT_FOUND"
+	}
+	var lease uint64
+	if ttl > 0 {
+		l, err := s.node.GrantLease(ttl)
+		if err != nil {
+			return serverError(err)
+		}
+		lease = l.ID
+	}
+	ok, err := s.node.AttachLease(key, lease)
+	if err != nil {
+		return serverError(err)
+	}
+	if !ok {
+		if lease != 0 {
+			s.node.RevokeLease(lease)
+		}
+		return "NOT_FOUND"
+	}
+	return "TOUCHED"
+}
@@ -0,0 +1,259 @@
+// Package metrics serves a node's metrics in the Prometheus text format.
+//
+// Hyphora and the Raft library record metrics through go-metrics. Setup
+// installs a Sink as its global sink; the sink keeps the latest state of
+// every series and renders it on each scrape. Timings are in milliseconds,
+// as go-metrics measures them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	gometrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// ServiceName prefixes every metric name.
+const ServiceName = "hyphora"
+
+// window is how many recent samples a summary keeps to compute quantiles.
+const window = 1024
+
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+type kind int
+
+const (
+	gauge kind = iota
+	counter
+	summary
+)
+
+func (k kind) String() string {
+	switch k {
+	case counter:
+		return "counter"
+	case summary:
+		return "summary"
+	default:
+		return "gauge"
+	}
+}
+
+type series struct {
+	labels string
+	// value is a gauge's last value or a counter's total.
+	value  float64
+	count  uint64
+	sum    float64
+	recent []float64
+	next   int
+}
+
+func (s *series) observe(v float64) {
+	s.count++
+	s.sum += v
+	if len(s.recent) < window {
+		s.recent = append(s.recent, v)
+		return
+	}
+	s.recent[s.next] = v
+	s.next = (s.next + 1) % window
+}
+
+type family struct {
+	kind   kind
+	series map[string]*series
+}
+
+// Sink is a go-metrics sink that keeps what it is sent for /metrics.
+type Sink struct {
+	mu       sync.Mutex
+	families map[string]*family
+
+	collectMu  sync.Mutex
+	collectors []func()
+}
+
+func NewSink() *Sink {
+	return &Sink{families: make(map[string]*family)}
+}
+
+// Setup makes a new Sink the global go-metrics sink and returns it.
+func Setup() (*Sink, error) {
+	sink := NewSink()
+	conf := gometrics.DefaultConfig(ServiceName)
+	conf.EnableHostname = false
+	if _, err := gometrics.NewGlobal(conf, sink); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Collect registers fn to run before every scrape, to set gauges read
+// from state kept elsewhere.
+func (s *Sink) Collect(fn func()) {
+	s.collectMu.Lock()
+	defer s.collectMu.Unlock()
+	s.collectors = append(s.collectors, fn)
+}
+
+func (s *Sink) SetGauge(key []string, val float32) {
+	s.SetGaugeWithLabels(key, val, nil)
+}
+
+func (s *Sink) SetGaugeWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.update(gauge, key, labels, func(se *series) { se.value = float64(val) })
+}
+
+func (s *Sink) EmitKey(key []string, val float32) {
+	s.SetGauge(key, val)
+}
+
+func (s *Sink) IncrCounter(key []string, val float32) {
+	s.IncrCounterWithLabels(key, val, nil)
+}
+
+func (s *Sink) IncrCounterWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.update(counter, key, labels, func(se *series) { se.value += float64(val) })
+}
+
+func (s *Sink) AddSample(key []string, val float32) {
+	s.AddSampleWithLabels(key, val, nil)
+}
+
+func (s *Sink) AddSampleWithLabels(key []string, val float32, labels []gometrics.Label) {
+	s.update(summary, key, labels, func(se *series) { se.observe(float64(val)) })
+}
+
+// update applies fn to the series of key and labels. A name keeps the kind
+// it was first recorded with; a different kind under it is dropped.
+func (s *Sink) update(k kind, key []string, labels []gometrics.Label, fn func(*series)) {
+	name := metricName(key)
+	if k == counter {
+		name += "_total"
+	}
+	ls := labelString(labels)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.families[name]
+	if !ok {
+		f = &family{kind: k, series: make(map[string]*series)}
+		s.families[name] = f
+	}
+	if f.kind != k {
+		return
+	}
+	se, ok := f.series[ls]
+	if !ok {
+		se = &series{labels: ls}
+		f.series[ls] = se
+	}
+	fn(se)
+}
+
+// ServeHTTP runs the collectors and writes every series.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.collectMu.Lock()
+	for _, fn := range s.collectors {
+		fn()
+	}
+	s.collectMu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.WriteText(w)
+}
+
+// WriteText writes every series in the Prometheus text format.
+func (s *Sink) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	s.mu.Lock()
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := s.families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for ls := range f.series {
+			keys = append(keys, ls)
+		}
+		sort.Strings(keys)
+		for _, ls := range keys {
+			writeSeries(bw, name, f.kind, f.series[ls])
+		}
+	}
+	s.mu.Unlock()
+	return bw.Flush()
+}
+
+func writeSeries(w io.Writer, name string, k kind, se *series) {
+	if k != summary {
+		fmt.Fprintf(w, "%s%s %s\n", name, braces(se.labels), formatFloat(se.value))
+		return
+	}
+	if len(se.recent) > 0 {
+		sorted := slices.Clone(se.recent)
+		slices.Sort(sorted)
+		for _, q := range quantiles {
+			v := sorted[int(q*float64(len(sorted)-1))]
+			ql := `quantile="` + strconv.FormatFloat(q, 'g', -1, 64) + `"`
+			if se.labels != "" {
+				ql = se.labels + "," + ql
+			}
+			fmt.Fprintf(w, "%s{%s} %s\n", name, ql, formatFloat(v))
+		}
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(se.labels), formatFloat(se.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(se.labels), se.count)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricName joins the parts of a go-metrics key with underscores and
+// replaces the characters Prometheus does not allow.
+func metricName(key []string) string {
+	return sanitize(strings.Join(key, "_"))
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// labelString renders labels sorted by name, without the braces.
+func labelString(labels []gometrics.Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = sanitize(l.Name) + `="` + escaper.Replace(l.Value) + `"`
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,115 @@
+// Package netutil serves connection-oriented protocols, such as those of
+// Redis and memcached, so they can be shut down gracefully.
+package netutil
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conns accepts connections and keeps track of them until their handler
+// returns. The zero value is ready to use.
+type Conns struct {
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// Serve accepts connections on l and runs handle for each in its own
+// goroutine. handle must close the connection. Serve returns nil once
+// Shutdown is called.
+func (c *Conns) Serve(l net.Listener, handle func(net.Conn)) error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	if c.listeners == nil {
+		c.listeners = make(map[net.Listener]struct{})
+	}
+	c.listeners[l] = struct{}{}
+	c.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if c.shuttingDown() {
+				return nil
+			}
+			return err
+		}
+		if !c.add(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer c.remove(conn)
+			handle(conn)
+		}()
+	}
+}
+
+func (c *Conns) shuttingDown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+func (c *Conns) add(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	if c.conns == nil {
+		c.conns = make(map[net.Conn]struct{})
+	}
+	c.conns[conn] = struct{}{}
+	c.wg.Add(1)
+	return true
+}
+
+func (c *Conns) remove(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+	c.wg.Done()
+}
+
+// Shutdown stops accepting connections and ends reads on the open ones,
+// so each handler returns once it has answered the command it is running.
+// It waits for every handler to return, or until ctx is done, when it
+// closes the connections left and returns ctx's error.
+func (c *Conns) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closing = true
+	for l := range c.listeners {
+		l.Close()
+	}
+	for conn := range c.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		for conn := range c.conns {
+			conn.Close()
+		}
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,208 @@
+package raftnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+const (
+	rolePrefix  = bitcask.SystemPrefix + "auth/role/"
+	tokenPrefix = bitcask.SystemPrefix + "auth/token/"
+)
+
+var (
+	ErrRoleNotFound  = errors.New("role not found")
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// Role grants access to resources through its rules. Roles are replicated
+// so every node enforces the same policy.
+type Role struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants Access, "read", "write" or "admin", to the resources of
+// Namespace whose names start with Prefix. An empty namespace covers them
+// all.
+type Rule struct {
+	Namespace string `json:"namespace,omitempty"`
+	Prefix    string `json:"prefix"`
+	Access    string `json:"access"`
+}
+
+// Token describes a bearer token. Only the SHA-256 hash of the secret is
+// stored; ID is the start of that hash, enough to name the token.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Roles   []string  `json:"roles"`
+	Created time.Time `json:"created"`
+}
+
+// TokenID derives a token's ID from the hash of its secret.
+func TokenID(hash string) string {
+	return hash[:min(16, len(hash))]
+}
+
+func (f *FSM) loadAuth() error {
+	roles := make(map[string]Role)
+	for _, k := range f.store.KeysWithPrefix(rolePrefix) {
+		var r Role
+		if err := f.loadJSON(k, &r); err != nil {
+			return fmt.Errorf("decode role %q: %w", strings.TrimPrefix(k, rolePrefix), err)
+		}
+		roles[r.Name] = r
+	}
+	tokens := make(map[string]Token)
+	for _, k := range f.store.KeysWithPrefix(tokenPrefix) {
+		var t Token
+		if err := f.loadJSON(k, &t); err != nil {
+			return fmt.Errorf("decode token %q: %w", strings.TrimPrefix(k, tokenPrefix), err)
+		}
+		tokens[strings.TrimPrefix(k, tokenPrefix)] = t
+	}
+	f.authMu.Lock()
+	f.roles, f.tokens = roles, tokens
+	f.authMu.Unlock()
+	return nil
+}
+
+func (f *FSM) loadJSON(key string, v any) error {
+	val, err := f.store.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(val, v)
+}
+
+func (f *FSM) applyRolePut(val []byte) error {
+	var r Role
+	if err := json.Unmarshal(val, &r); err != nil {
+		return err
+	}
+	if err := f.store.Put(rolePrefix+r.Name, val); err != nil {
+		return err
+	}
+	f.authMu.Lock()
+	f.roles[r.Name] = r
+	f.authMu.Unlock()
+	return nil
+}
+
+func (f *FSM) applyRoleDelete(name string) error {
+	f.authMu.Lock()
+	_, ok := f.roles[name]
+	delete(f.roles, name)
+	f.authMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, name)
+	}
+	return f.store.Delete(rolePrefix + name)
+}
+
+func (f *FSM) applyTokenPut(hash string, val []byte) error {
+	var t Token
+	if err := json.Unmarshal(val, &t); err != nil {
+		return err
+	}
+	if err := f.store.Put(tokenPrefix+hash, val); err != nil {
+		return err
+	}
+	f.authMu.Lock()
+	f.tokens[hash] = t
+	f.authMu.Unlock()
+	return nil
+}
+
+// applyTokenDelete deletes the token whose ID is id.
+func (f *FSM) applyTokenDelete(id string) error {
+	f.authMu.Lock()
+	var hash string
+	for h, t := range f.tokens {
+		if t.ID == id {
+			hash = h
+			break
+		}
+	}
+	delete(f.tokens, hash)
+	f.authMu.Unlock()
+	if hash == "" {
+		return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+	}
+	return f.store.Delete(tokenPrefix + hash)
+}
+
+func (n *Node) PutRole(r Role) error {
+	val, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = n.ApplyCommand(Command{Op: OpRolePut, Key: r.Name, Val: val})
+	return err
+}
+
+func (n *Node) DeleteRole(name string) error {
+	_, err := n.ApplyCommand(Command{Op: OpRoleDelete, Key: name})
+	return err
+}
+
+// Role reads this node's copy of a role.
+func (n *Node) Role(name string) (Role, bool) {
+	n.fsm.authMu.RLock()
+	defer n.fsm.authMu.RUnlock()
+	r, ok := n.fsm.roles[name]
+	return r, ok
+}
+
+func (n *Node) Roles() []Role {
+	n.fsm.authMu.RLock()
+	defer n.fsm.authMu.RUnlock()
+	roles := make([]Role, 0, len(n.fsm.roles))
+	for _, r := range n.fsm.roles {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles
+}
+
+// PutToken stores t under hash, the hex SHA-256 of its secret.
+func (n *Node) PutToken(hash string, t Token) error {
+	val, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = n.ApplyCommand(Command{Op: OpTokenPut, Key: hash, Val: val})
+	return err
+}
+
+func (n *Node) DeleteToken(id string) error {
+	_, err := n.ApplyCommand(Command{Op: OpTokenDelete, Key: id})
+	return err
+}
+
+// LookupToken finds the token whose secret hashes to hash in this node's
+// copy of the tokens.
+func (n *Node) LookupToken(hash string) (Token, bool) {
+	n.fsm.authMu.RLock()
+	defer n.fsm.authMu.RUnlock()
+	t, ok := n.fsm.tokens[hash]
+	return t, ok
+}
+
+func (n *Node) Tokens() []Token {
+	n.fsm.authMu.RLock()
+	defer n.fsm.authMu.RUnlock()
+	tokens := make([]Token, 0, len(n.fsm.tokens))
+	for _, t := range n.fsm.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens
+}
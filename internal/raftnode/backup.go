@@ -0,0 +1,201 @@
+package raftnode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/backup"
+	"github.com/hashicorp/raft"
+)
+
+// ErrBackupSince is returned for an incremental backup of the changes
+// after an index this node has not reached.
+var ErrBackupSince = errors.New("backup base index is past this node's state")
+
+// State is the content of the store as captured by an FSM snapshot: every
+// key with its value, and the version of the keys written with one.
+type State struct {
+	Data     map[string][]byte
+	Versions map[string]uint64
+}
+
+// ReadState decodes a state written by Write, the format of FSM snapshots.
+func ReadState(r io.Reader) (*State, error) {
+	dec := gob.NewDecoder(r)
+	st := &State{Data: make(map[string][]byte), Versions: make(map[string]uint64)}
+	if err := dec.Decode(&st.Data); err != nil {
+		return nil, err
+	}
+	// Key versions follow the data; snapshots taken before versions
+	// existed end after it.
+	if err := dec.Decode(&st.Versions); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *State) Write(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(st.Data); err != nil {
+		return err
+	}
+	return enc.Encode(st.Versions)
+}
+
+// Delta holds the changes to a state after an index: the keys written
+// since, and the names of every key, so a key missing from them was
+// deleted. Keys without a version, which hold internal state such as
+// leases and queues, are always part of the changes.
+type Delta struct {
+	Since   uint64
+	Changed State
+	Keys    []string
+}
+
+// Since returns the changes of st after index.
+func (st *State) Since(index uint64) *Delta {
+	d := &Delta{Since: index, Changed: State{Data: make(map[string][]byte), Versions: make(map[string]uint64)}}
+	for k, v := range st.Data {
+		d.Keys = append(d.Keys, k)
+		version, ok := st.Versions[k]
+		if ok && version <= index {
+			continue
+		}
+		d.Changed.Data[k] = v
+		if ok {
+			d.Changed.Versions[k] = version
+		}
+	}
+	sort.Strings(d.Keys)
+	return d
+}
+
+// Apply brings st, which must reflect at least the index d was taken
+// since, up to date with d.
+func (st *State) Apply(d *Delta) {
+	keep := make(map[string]bool, len(d.Keys))
+	for _, k := range d.Keys {
+		keep[k] = true
+	}
+	for k := range st.Data {
+		if !keep[k] {
+			delete(st.Data, k)
+			delete(st.Versions, k)
+		}
+	}
+	for k, v := range d.Changed.Data {
+		st.Data[k] = v
+		delete(st.Versions, k)
+		if version, ok := d.Changed.Versions[k]; ok {
+			st.Versions[k] = version
+		}
+	}
+}
+
+func ReadDelta(r io.Reader) (*Delta, error) {
+	var d Delta
+	if err := gob.NewDecoder(r).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.Changed.Data == nil {
+		d.Changed.Data = make(map[string][]byte)
+	}
+	return &d, nil
+}
+
+func (d *Delta) Write(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(d)
+}
+
+// Backup captures the node's state for a backup archive through a Raft
+// snapshot, or the latest one if nothing was applied since it. With
+// since > 0 only the changes after that index are kept. It returns the
+// manifest of the archive, and the state with its size; the caller closes
+// the state.
+func (n *Node) Backup(since uint64) (backup.Manifest, int64, io.ReadCloser, error) {
+	meta, rc, err := n.openSnapshot()
+	if err != nil {
+		return backup.Manifest{}, 0, nil, err
+	}
+	m := backup.Manifest{Kind: backup.Full, Index: meta.Index, Term: meta.Term, NodeID: string(n.id), Created: time.Now().UTC()}
+	if since == 0 {
+		return m, meta.Size, rc, nil
+	}
+	defer rc.Close()
+	if since > meta.Index {
+		return backup.Manifest{}, 0, nil, fmt.Errorf("%w: %d is past %d", ErrBackupSince, since, meta.Index)
+	}
+	st, err := ReadState(rc)
+	if err != nil {
+		return backup.Manifest{}, 0, nil, err
+	}
+	var buf bytes.Buffer
+	if err := st.Since(since).Write(&buf); err != nil {
+		return backup.Manifest{}, 0, nil, err
+	}
+	m.Kind, m.Since = backup.Incremental, since
+	return m, int64(buf.Len()), io.NopCloser(&buf), nil
+}
+
+// openSnapshot takes a snapshot and opens it, or opens the latest one
+// when nothing was applied since.
+func (n *Node) openSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	fut, err := n.snapshot(false)
+	if err == nil {
+		return fut.Open()
+	}
+	if !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+		return nil, nil, err
+	}
+	snaps, lerr := n.snapshots.List()
+	if lerr != nil {
+		return nil, nil, lerr
+	}
+	if len(snaps) == 0 {
+		return nil, nil, err
+	}
+	return n.snapshots.Open(snaps[0].ID)
+}
+
+// Seed prepares dataDir for a node that forms a new cluster from st, the
+// state of a backup at index: it writes st as the node's only Raft
+// snapshot, with the node as the only voter. The node restores it when it
+// starts with the same ID and Raft address, and other nodes can then join
+// it. dataDir must not hold a node already.
+func Seed(dataDir, id, raftAddr string, index uint64, st *State) error {
+	raftDir := filepath.Join(dataDir, "raft")
+	for _, dir := range []string{raftDir, filepath.Join(dataDir, "bitcask")} {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+			return fmt.Errorf("%s is not empty", dir)
+		}
+	}
+	if err := os.MkdirAll(raftDir, 0o755); err != nil {
+		return err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(raftDir, 1, io.Discard)
+	if err != nil {
+		return err
+	}
+	conf := raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter, ID: raft.ServerID(id), Address: raft.ServerAddress(raftAddr)}}}
+	// The store encodes the legacy peer list through a transport; the
+	// in-memory one encodes addresses as the network one does.
+	_, trans := raft.NewInmemTransport("")
+	// The new cluster counts its own terms, but carries on from the
+	// index: key versions are indexes, and must keep growing.
+	sink, err := snapshots.Create(raft.SnapshotVersionMax, index, 1, conf, index, trans)
+	if err != nil {
+		return err
+	}
+	if err := st.Write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
@@ -0,0 +1,86 @@
+package raftnode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+const (
+	OpPut    = "PUT"
+	OpDelete = "DEL"
+	OpRetain = "RETAIN"
+	OpIncr   = "INCR"
+	OpDecr   = "DECR"
+	OpAdd    = "ADD"
+
+	OpLeaseGrant     = "LEASE_GRANT"
+	OpLeaseKeepAlive = "LEASE_KEEPALIVE"
+	OpLeaseRevoke    = "LEASE_REVOKE"
+	OpLeaseExpire    = "LEASE_EXPIRE"
+	OpLeaseAttach    = "LEASE_ATTACH"
+	OpLockAcquire    = "LOCK_ACQUIRE"
+	OpLockRelease    = "LOCK_RELEASE"
+
+	OpEnqueue   = "ENQUEUE"
+	OpDequeue   = "DEQUEUE"
+	OpQueueAck  = "ACK"
+	OpQueuePeek = "PEEK"
+	OpQueueLen  = "QLEN"
+
+	OpIndexDefine = "INDEX_DEFINE"
+	OpIndexDrop   = "INDEX_DROP"
+
+	OpTxn = "TXN"
+
+	OpNodeMeta = "NODE_META"
+
+	OpRolePut     = "ROLE_PUT"
+	OpRoleDelete  = "ROLE_DEL"
+	OpTokenPut    = "TOKEN_PUT"
+	OpTokenDelete = "TOKEN_DEL"
+)
+
+// Command is the payload of every Raft log entry applied by the FSM.
+type Command struct {
+	Op    string
+	Key   string
+	Val   []byte
+	Delta int64
+	Lease uint64
+	TTL   time.Duration
+	Token uint64
+	Flags uint32
+	// Txn holds the transactions logged by earlier versions, which gob
+	// cannot decode into Transaction.
+	Txn         *textTxn
+	Transaction *Txn
+}
+
+// idempotent reports whether applying c again on top of later state leaves
+// the store unchanged, so it can be replayed without tracking.
+func (c Command) idempotent() bool {
+	switch c.Op {
+	case OpPut:
+		return c.Lease == 0
+	case OpDelete, OpRetain, OpQueuePeek, OpQueueLen, OpIndexDefine, OpIndexDrop, OpNodeMeta,
+		OpRolePut, OpRoleDelete, OpTokenPut, OpTokenDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Command) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCommand(data []byte) (Command, error) {
+	var cmd Command
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cmd)
+	return cmd, err
+}
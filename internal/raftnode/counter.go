@@ -0,0 +1,66 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+)
+
+// Counters are stored as base-10 ASCII integers, so they read back through
+// /get as plain text and any key written with such a value can be counted.
+func encodeCounter(n int64) []byte {
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+func decodeCounter(val []byte) (int64, error) {
+	return strconv.ParseInt(string(val), 10, 64)
+}
+
+// applyAdd adds delta to the integer stored at key, treating a missing key
+// as zero, and returns the new value. The key is left untouched on error.
+func (f *FSM) applyAdd(key string, delta int64, index uint64, ts time.Time) (int64, error) {
+	if err := checkUserKey(key); err != nil {
+		return 0, err
+	}
+	var n int64
+	val, err := f.store.Get(key)
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+	case err != nil:
+		return 0, err
+	default:
+		n, err = decodeCounter(val)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", ErrNotInteger, key)
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%w: %s", ErrOverflow, key)
+	}
+	n += delta
+	if err := f.store.PutAt(key, encodeCounter(n), index, ts); err != nil {
+		return 0, err
+	}
+	if err := f.keyChanged(key, index); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Add atomically adds delta to the counter at key and returns its new value.
+func (n *Node) Add(key string, delta int64) (int64, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpAdd, Key: key, Delta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return resp.(int64), nil
+}
@@ -0,0 +1,38 @@
+package raftnode
+
+import (
+	"encoding/binary"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+const flagsPrefix = bitcask.SystemPrefix + "flags/"
+
+// setFlags stores the opaque flags a client attached to key, as memcached
+// clients do to record how a value was serialized. Writes without flags
+// and deletes clear them, so they always describe the current value.
+func (f *FSM) setFlags(key string, flags uint32) error {
+	k := flagsPrefix + key
+	if flags == 0 {
+		if _, ok := f.store.Version(k); !ok {
+			return nil
+		}
+		return f.store.Delete(k)
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], flags)
+	return f.store.Put(k, buf[:])
+}
+
+func (f *FSM) flags(key string) uint32 {
+	val, err := f.store.Get(flagsPrefix + key)
+	if err != nil || len(val) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(val)
+}
+
+// Flags returns the flags stored with key's current value, or 0.
+func (n *Node) Flags(key string) uint32 {
+	return n.fsm.flags(key)
+}
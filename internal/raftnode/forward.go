@@ -0,0 +1,273 @@
+package raftnode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	memberPrefix      = bitcask.SystemPrefix + "node/"
+	advertiseInterval = 2 * time.Second
+)
+
+var ErrNoLeader = errors.New("no known leader")
+
+// NodeMeta is how a node can be reached by clients and peers. Each node
+// publishes its own record through the log, so every replica can turn the
+// Raft leader ID into an API address.
+type NodeMeta struct {
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+	HTTPAddr string `json:"http_addr"`
+	GRPCAddr string `json:"grpc_addr,omitempty"`
+}
+
+// Forwarder carries an encoded command to the leader and returns the
+// leader's encoded result from ApplyForwarded.
+type Forwarder func(leader NodeMeta, req []byte) ([]byte, error)
+
+// forwardError is a sentinel error preserved across forwarding, so callers
+// on a follower can match it with errors.Is. It travels by name, which
+// must never change, so nodes running different builds agree on it.
+type forwardError struct {
+	name string
+	err  error
+}
+
+var forwardErrors = []forwardError{
+	{"not_leader", raft.ErrNotLeader},
+	{"leadership_lost", raft.ErrLeadershipLost},
+	{"nothing_new_to_snapshot", raft.ErrNothingNewToSnapshot},
+	{"no_leader", ErrNoLeader},
+	{"key_not_found", bitcask.ErrKeyNotFound},
+	{"version_not_found", bitcask.ErrVersionNotFound},
+	{"invalid_key", ErrInvalidKey},
+	{"not_integer", ErrNotInteger},
+	{"overflow", ErrOverflow},
+	{"lease_not_found", ErrLeaseNotFound},
+	{"lease_ttl", ErrLeaseTTL},
+	{"lock_held", ErrLockHeld},
+	{"lock_not_held", ErrLockNotHeld},
+	{"queue_empty", ErrQueueEmpty},
+	{"queue_no_receipt", ErrQueueNoReceipt},
+	{"queue_name", ErrQueueName},
+	{"index_not_found", ErrIndexNotFound},
+	{"index_def", ErrIndexDef},
+	{"txn_op", ErrTxnOp},
+	{"backup_since", ErrBackupSince},
+	{"role_not_found", ErrRoleNotFound},
+	{"token_not_found", ErrTokenNotFound},
+}
+
+// RegisterForwardError preserves err across forwarding under name, for
+// sentinel errors of packages that raftnode cannot import. Call it from an
+// init function.
+func RegisterForwardError(name string, err error) {
+	for _, fe := range forwardErrors {
+		if fe.name == name {
+			panic("raftnode: forward error " + name + " registered twice")
+		}
+	}
+	forwardErrors = append(forwardErrors, forwardError{name, err})
+}
+
+func init() {
+	gob.Register(&Lease{})
+	gob.Register(&Lock{})
+	gob.Register(&QueueItem{})
+	gob.Register(&QueueStats{})
+	gob.Register(&TxnResult{})
+}
+
+type forwardResult struct {
+	Resp    interface{}
+	Err     string
+	ErrName string
+}
+
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.kind }
+
+func (f *FSM) loadMembers() error {
+	members := make(map[string]NodeMeta)
+	for _, k := range f.store.KeysWithPrefix(memberPrefix) {
+		val, err := f.store.Get(k)
+		if err != nil {
+			return err
+		}
+		var m NodeMeta
+		if err := json.Unmarshal(val, &m); err != nil {
+			return fmt.Errorf("decode node %q: %w", strings.TrimPrefix(k, memberPrefix), err)
+		}
+		members[m.ID] = m
+	}
+	f.metaMu.Lock()
+	f.members = members
+	f.metaMu.Unlock()
+	return nil
+}
+
+func (f *FSM) applyNodeMeta(m NodeMeta) error {
+	val, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := f.store.Put(memberPrefix+m.ID, val); err != nil {
+		return err
+	}
+	f.metaMu.Lock()
+	f.members[m.ID] = m
+	f.metaMu.Unlock()
+	return nil
+}
+
+func (f *FSM) member(id string) (NodeMeta, bool) {
+	f.metaMu.RLock()
+	defer f.metaMu.RUnlock()
+	m, ok := f.members[id]
+	return m, ok
+}
+
+// Member returns the published addresses of the node with the given ID.
+func (n *Node) Member(id string) (NodeMeta, bool) {
+	return n.fsm.member(id)
+}
+
+// Leader returns the published addresses of the current leader.
+func (n *Node) Leader() (NodeMeta, error) {
+	_, id := n.Raft.LeaderWithID()
+	if id == "" {
+		return NodeMeta{}, ErrNoLeader
+	}
+	m, ok := n.fsm.member(string(id))
+	if !ok {
+		return NodeMeta{}, fmt.Errorf("%w: leader %s has not published its address", ErrNoLeader, id)
+	}
+	return m, nil
+}
+
+func (n *Node) SetForwarder(f Forwarder) {
+	n.forwarder = f
+}
+
+// Advertise publishes meta as this node's addresses and keeps the record
+// current. Followers publish through the leader once its address is known.
+// The record is also republished whenever the leader or the membership
+// changes, so nodes that joined after it was first written receive it too.
+func (n *Node) Advertise(meta NodeMeta) {
+	n.meta = meta
+	go func() {
+		ticker := time.NewTicker(advertiseInterval)
+		defer ticker.Stop()
+		var published string
+		for {
+			published = n.publishMeta(meta, published)
+			select {
+			case <-n.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// publishMeta writes meta through the log unless it is already current for
+// the cluster view last published to, and returns the view it is current
+// for.
+func (n *Node) publishMeta(meta NodeMeta, published string) string {
+	_, leader := n.Raft.LeaderWithID()
+	cfg := n.Raft.GetConfiguration()
+	if leader == "" || cfg.Error() != nil {
+		return published
+	}
+	view := string(leader)
+	for _, srv := range cfg.Configuration().Servers {
+		view += "," + string(srv.ID)
+	}
+	if m, ok := n.fsm.member(meta.ID); ok && m == meta && view == published {
+		return published
+	}
+	val, err := json.Marshal(meta)
+	if err != nil {
+		n.log.Error("failed to encode node address", "err", err)
+		return published
+	}
+	if _, err := n.ApplyCommand(Command{Op: OpNodeMeta, Key: meta.ID, Val: val}); err != nil {
+		if !errors.Is(err, ErrNoLeader) {
+			n.log.Warn("failed to publish node address", "err", err)
+		}
+		return published
+	}
+	return view
+}
+
+func (n *Node) forward(cmd Command) (interface{}, error) {
+	leader, err := n.Leader()
+	if err != nil {
+		return nil, err
+	}
+	req, err := cmd.encode()
+	if err != nil {
+		return nil, err
+	}
+	out, err := n.forwarder(leader, req)
+	if err != nil {
+		return nil, fmt.Errorf("forward to leader %s: %w", leader.ID, err)
+	}
+	var res forwardResult
+	if err := gob.NewDecoder(bytes.NewReader(out)).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Err != "" {
+		rerr := &remoteError{msg: res.Err}
+		for _, fe := range forwardErrors {
+			if fe.name == res.ErrName {
+				rerr.kind = fe.err
+				break
+			}
+		}
+		return nil, rerr
+	}
+	return res.Resp, nil
+}
+
+// ApplyForwarded applies a command forwarded by a follower and encodes the
+// result for it. It never forwards again, so a node that lost leadership in
+// the meantime answers with raft.ErrNotLeader.
+func (n *Node) ApplyForwarded(req []byte) ([]byte, error) {
+	cmd, err := decodeCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	var res forwardResult
+	resp, err := n.applyLocal(cmd)
+	if err != nil {
+		res.Err = err.Error()
+		for _, fe := range forwardErrors {
+			if errors.Is(err, fe.err) {
+				res.ErrName = fe.name
+				break
+			}
+		}
+	} else {
+		res.Resp = resp
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(res); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
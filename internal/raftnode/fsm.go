@@ -2,58 +2,278 @@ package raftnode
 
 import (
 	"bytes"
-	"encoding/gob"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/AMS003010/Hyphora/internal/bitcask"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/raft"
 )
 
+// appliedKey records the index of the last applied entry whose effect is
+// not idempotent. Raft replays the log after the last snapshot on restart,
+// and entries at or below this index are already reflected in the store.
+const appliedKey = bitcask.SystemPrefix + "applied"
+
+// ErrInvalidKey rejects a key under bitcask.SystemPrefix given to an
+// operation on user keys; those keys hold the FSM's own state.
+var ErrInvalidKey = errors.New("invalid key")
+
+// checkUserKey returns ErrInvalidKey for a key that is not a user key.
+func checkUserKey(key string) error {
+	if key == "" || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// ErrCompacting fails the snapshots Raft takes by itself while the store
+// compacts; Raft tries again at its next snapshot interval.
+var ErrCompacting = errors.New("store is compacting")
+
 type FSM struct {
-	store *bitcask.Bitcask
+	store   *bitcask.Bitcask
+	applied uint64
+	// compacting is set while Node.Compact runs. Capturing a snapshot
+	// would wait for the store's lock, holding up every Apply meanwhile.
+	compacting atomic.Bool
+
+	mu       sync.Mutex
+	leases   map[uint64]*Lease
+	keyLease map[string]uint64
+
+	indexMu sync.RWMutex
+	indexes map[string]*secondaryIndex
+
+	// queues holds the in-flight items of each queue by sequence number.
+	// Only Apply and Restore touch it.
+	queues map[string]map[uint64]inflight
+
+	watchMu   sync.Mutex
+	watchers  map[int]*watcher
+	nextWatch int
+
+	metaMu  sync.RWMutex
+	members map[string]NodeMeta
+
+	authMu sync.RWMutex
+	roles  map[string]Role
+	tokens map[string]Token
 }
 
-func NewFSM(store *bitcask.Bitcask) *FSM {
-	return &FSM{store: store}
+func NewFSM(store *bitcask.Bitcask) (*FSM, error) {
+	f := &FSM{store: store, watchers: make(map[int]*watcher)}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load rebuilds the FSM's in-memory state from the store.
+func (f *FSM) load() error {
+	f.loadApplied()
+	if err := f.loadLeases(); err != nil {
+		return err
+	}
+	if err := f.loadMembers(); err != nil {
+		return err
+	}
+	if err := f.loadAuth(); err != nil {
+		return err
+	}
+	if err := f.loadQueues(); err != nil {
+		return err
+	}
+	return f.loadIndexes()
+}
+
+func (f *FSM) loadApplied() {
+	f.applied = 0
+	if val, err := f.store.Get(appliedKey); err == nil && len(val) == 8 {
+		f.applied = binary.BigEndian.Uint64(val)
+	}
+}
+
+func (f *FSM) markApplied(index uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+	if err := f.store.Put(appliedKey, buf[:]); err != nil {
+		return err
+	}
+	f.applied = index
+	return nil
 }
 
 func (f *FSM) Apply(log *raft.Log) interface{} {
-	var cmd struct {
-		Op  string
-		Key string
-		Val []byte
+	if log.Index <= f.applied {
+		return nil
+	}
+	cmd, err := decodeCommand(log.Data)
+	if err != nil {
+		return err
 	}
-	if err := gob.NewDecoder(bytes.NewReader(log.Data)).Decode(&cmd); err != nil {
+	defer metrics.MeasureSinceWithLabels([]string{"fsm", "apply"}, time.Now(), []metrics.Label{{Name: "op", Value: cmd.Op}})
+	resp, err := f.apply(cmd, log.Index, log.AppendedAt)
+	if err != nil {
 		return err
 	}
-	return f.store.ApplyCommand(cmd.Op, cmd.Key, cmd.Val)
+	if !cmd.idempotent() {
+		if err := f.markApplied(log.Index); err != nil {
+			return err
+		}
+	}
+	return resp
+}
+
+func (f *FSM) apply(cmd Command, index uint64, ts time.Time) (interface{}, error) {
+	switch cmd.Op {
+	case OpPut:
+		if cmd.Lease != 0 {
+			f.mu.Lock()
+			_, ok := f.leases[cmd.Lease]
+			f.mu.Unlock()
+			if !ok {
+				return nil, fmt.Errorf("%w: %d", ErrLeaseNotFound, cmd.Lease)
+			}
+		}
+		if err := f.store.PutAt(cmd.Key, cmd.Val, index, ts); err != nil {
+			return nil, err
+		}
+		if err := f.setFlags(cmd.Key, cmd.Flags); err != nil {
+			return nil, err
+		}
+		if err := f.keyChanged(cmd.Key, index); err != nil {
+			return nil, err
+		}
+		return nil, f.attachLease(cmd.Key, cmd.Lease)
+	case OpDelete:
+		if err := f.store.DeleteAt(cmd.Key, index, ts); err != nil {
+			return nil, err
+		}
+		if err := f.keyChanged(cmd.Key, index); err != nil {
+			return nil, err
+		}
+		return nil, f.attachLease(cmd.Key, 0)
+	case OpRetain:
+		var p bitcask.RetentionPolicy
+		if err := json.Unmarshal(cmd.Val, &p); err != nil {
+			return nil, err
+		}
+		return nil, f.store.SetRetention(p)
+	case OpIncr, OpDecr, OpAdd:
+		delta := cmd.Delta
+		switch cmd.Op {
+		case OpIncr:
+			delta = 1
+		case OpDecr:
+			delta = -1
+		}
+		return f.applyAdd(cmd.Key, delta, index, ts)
+	case OpLeaseGrant:
+		return f.applyLeaseGrant(cmd.TTL, index, ts)
+	case OpLeaseKeepAlive:
+		return f.applyLeaseKeepAlive(cmd.Lease, ts)
+	case OpLeaseRevoke:
+		return nil, f.applyLeaseRevoke(cmd.Lease, index, ts, false)
+	case OpLeaseExpire:
+		return nil, f.applyLeaseRevoke(cmd.Lease, index, ts, true)
+	case OpLeaseAttach:
+		return f.applyLeaseAttach(cmd.Key, cmd.Lease)
+	case OpLockAcquire:
+		return f.applyLockAcquire(cmd.Key, string(cmd.Val), cmd.TTL, index, ts)
+	case OpLockRelease:
+		return nil, f.applyLockRelease(cmd.Key, cmd.Token, index, ts)
+	case OpEnqueue:
+		return f.applyEnqueue(cmd.Key, cmd.Val)
+	case OpDequeue:
+		return f.applyDequeue(cmd.Key, cmd.TTL, index, ts)
+	case OpQueueAck:
+		return nil, f.applyAck(cmd.Key, cmd.Token)
+	case OpQueuePeek:
+		return f.applyPeek(cmd.Key, ts)
+	case OpQueueLen:
+		return f.applyQueueLen(cmd.Key)
+	case OpIndexDefine:
+		var def IndexDef
+		if err := json.Unmarshal(cmd.Val, &def); err != nil {
+			return nil, err
+		}
+		return nil, f.applyIndexDefine(def)
+	case OpIndexDrop:
+		return nil, f.applyIndexDrop(cmd.Key)
+	case OpTxn:
+		switch {
+		case cmd.Transaction != nil:
+			return f.applyTxn(*cmd.Transaction, index, ts)
+		case cmd.Txn != nil:
+			return f.applyTxn(cmd.Txn.txn(), index, ts)
+		}
+		// The oldest entries carry the transaction as JSON.
+		var t textTxn
+		if err := json.Unmarshal(cmd.Val, &t); err != nil {
+			return nil, err
+		}
+		return f.applyTxn(t.txn(), index, ts)
+	case OpNodeMeta:
+		var m NodeMeta
+		if err := json.Unmarshal(cmd.Val, &m); err != nil {
+			return nil, err
+		}
+		return nil, f.applyNodeMeta(m)
+	case OpRolePut:
+		return nil, f.applyRolePut(cmd.Val)
+	case OpRoleDelete:
+		return nil, f.applyRoleDelete(cmd.Key)
+	case OpTokenPut:
+		return nil, f.applyTokenPut(cmd.Key, cmd.Val)
+	case OpTokenDelete:
+		return nil, f.applyTokenDelete(cmd.Key)
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", cmd.Op)
+	}
 }
 
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	if f.compacting.Load() {
+		return nil, ErrCompacting
+	}
 	entries, err := f.store.Entries()
 	if err != nil {
 		return nil, err
 	}
-	return &snapshot{data: entries}, nil
+	return &snapshot{State{Data: entries, Versions: f.store.Versions()}}, nil
 }
 
 func (f *FSM) Restore(rc io.ReadCloser) error {
 	defer rc.Close()
-	dec := gob.NewDecoder(rc)
-	data := make(map[string][]byte)
-	if err := dec.Decode(&data); err != nil {
+	st, err := ReadState(rc)
+	if err != nil {
+		return err
+	}
+	if err := f.store.RestoreFromSnapshot(st.Data, st.Versions); err != nil {
+		return err
+	}
+	if err := f.load(); err != nil {
 		return err
 	}
-	return f.store.RestoreFromSnapshot(data)
+	f.resetWatchers()
+	return nil
 }
 
 type snapshot struct {
-	data map[string][]byte
+	state State
 }
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(s.data); err != nil {
+	if err := s.state.Write(&buf); err != nil {
 		sink.Cancel()
 		return err
 	}
@@ -61,6 +281,7 @@ func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 		sink.Cancel()
 		return err
 	}
+	metrics.SetGauge([]string{"fsm", "snapshot", "size_bytes"}, float32(buf.Len()))
 	return sink.Close()
 }
 
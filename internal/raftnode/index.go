@@ -0,0 +1,292 @@
+package raftnode
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+const indexPrefix = bitcask.SystemPrefix + "index/"
+
+var (
+	ErrIndexNotFound = errors.New("index not found")
+	ErrIndexDef      = errors.New("index needs a name without '/', a path and a prefix outside the system keys")
+)
+
+// IndexDef indexes the JSON values of keys under Prefix by the scalar found
+// at Path, a dot-separated list of object fields and array positions such as
+// "host.roles.0". Values that are not JSON, or lack the path, are skipped.
+type IndexDef struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Path   string `json:"path"`
+}
+
+// secondaryIndex maps every indexed key to its value at the path, and
+// keeps the entries sorted by value and then key for range lookups. It
+// lives only in memory and is rebuilt from the store on open and restore,
+// so it is unaffected by compaction.
+type secondaryIndex struct {
+	def     IndexDef
+	values  map[string]indexValue
+	entries []indexEntry
+}
+
+type indexEntry struct {
+	val indexValue
+	key string
+}
+
+func (e indexEntry) compare(o indexEntry) int {
+	if c := e.val.compare(o.val); c != 0 {
+		return c
+	}
+	return strings.Compare(e.key, o.key)
+}
+
+// set indexes key under v, or removes it from the index when ok is false.
+func (idx *secondaryIndex) set(key string, v indexValue, ok bool) {
+	if old, found := idx.values[key]; found {
+		if i, exact := slices.BinarySearchFunc(idx.entries, indexEntry{old, key}, indexEntry.compare); exact {
+			idx.entries = slices.Delete(idx.entries, i, i+1)
+		}
+		delete(idx.values, key)
+	}
+	if !ok {
+		return
+	}
+	e := indexEntry{v, key}
+	i, _ := slices.BinarySearchFunc(idx.entries, e, indexEntry.compare)
+	idx.entries = slices.Insert(idx.entries, i, e)
+	idx.values[key] = v
+}
+
+// lookup returns the keys whose value lies within [from, to] in index
+// order; a nil bound is open.
+func (idx *secondaryIndex) lookup(from, to *indexValue) []string {
+	start, end := 0, len(idx.entries)
+	if from != nil {
+		start = sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].val.compare(*from) >= 0 })
+	}
+	if to != nil {
+		end = sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].val.compare(*to) > 0 })
+	}
+	keys := make([]string, 0, max(end-start, 0))
+	for _, e := range idx.entries[start:max(end, start)] {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+type indexValue struct {
+	str string
+	num float64
+	isN bool
+}
+
+// compare orders every number before every string, numbers by value and
+// strings byte-wise.
+func (v indexValue) compare(o indexValue) int {
+	switch {
+	case v.isN && o.isN:
+		return cmp.Compare(v.num, o.num)
+	case v.isN:
+		return -1
+	case o.isN:
+		return 1
+	}
+	return strings.Compare(v.str, o.str)
+}
+
+// parseIndexValue interprets a query bound the way extract interprets
+// numbers, so "3" matches the JSON number 3.
+func parseIndexValue(s string) indexValue {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return indexValue{str: s, num: n, isN: true}
+	}
+	return indexValue{str: s}
+}
+
+func (d IndexDef) extract(val []byte) (indexValue, bool) {
+	var doc any
+	if err := json.Unmarshal(val, &doc); err != nil {
+		return indexValue{}, false
+	}
+	for _, part := range strings.Split(d.Path, ".") {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[part]
+			if !ok {
+				return indexValue{}, false
+			}
+			doc = v
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return indexValue{}, false
+			}
+			doc = node[i]
+		default:
+			return indexValue{}, false
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return indexValue{str: v}, true
+	case float64:
+		return indexValue{str: strconv.FormatFloat(v, 'f', -1, 64), num: v, isN: true}, true
+	case bool:
+		return indexValue{str: strconv.FormatBool(v)}, true
+	default:
+		return indexValue{}, false
+	}
+}
+
+func (f *FSM) loadIndexes() error {
+	indexes := make(map[string]*secondaryIndex)
+	for _, k := range f.store.KeysWithPrefix(indexPrefix) {
+		val, err := f.store.Get(k)
+		if err != nil {
+			return err
+		}
+		var def IndexDef
+		if err := json.Unmarshal(val, &def); err != nil {
+			return fmt.Errorf("decode index %q: %w", strings.TrimPrefix(k, indexPrefix), err)
+		}
+		idx, err := f.buildIndex(def)
+		if err != nil {
+			return err
+		}
+		indexes[def.Name] = idx
+	}
+	f.indexMu.Lock()
+	f.indexes = indexes
+	f.indexMu.Unlock()
+	return nil
+}
+
+func (f *FSM) buildIndex(def IndexDef) (*secondaryIndex, error) {
+	idx := &secondaryIndex{def: def, values: make(map[string]indexValue)}
+	for _, key := range f.store.KeysWithPrefix(def.Prefix) {
+		val, err := f.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := def.extract(val); ok {
+			idx.values[key] = v
+			idx.entries = append(idx.entries, indexEntry{v, key})
+		}
+	}
+	slices.SortFunc(idx.entries, indexEntry.compare)
+	return idx, nil
+}
+
+// updateIndexes refreshes every index whose prefix covers key with its
+// value after a write; val is nil when the key was deleted.
+func (f *FSM) updateIndexes(key string, val []byte) {
+	f.indexMu.Lock()
+	defer f.indexMu.Unlock()
+	for _, idx := range f.indexes {
+		if !strings.HasPrefix(key, idx.def.Prefix) {
+			continue
+		}
+		v, ok := idx.def.extract(val)
+		idx.set(key, v, ok && val != nil)
+	}
+}
+
+func (f *FSM) applyIndexDefine(def IndexDef) error {
+	if def.Name == "" || strings.Contains(def.Name, "/") || def.Path == "" ||
+		strings.HasPrefix(def.Prefix, bitcask.SystemPrefix) {
+		return ErrIndexDef
+	}
+	val, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	if err := f.store.Put(indexPrefix+def.Name, val); err != nil {
+		return err
+	}
+	idx, err := f.buildIndex(def)
+	if err != nil {
+		return err
+	}
+	f.indexMu.Lock()
+	f.indexes[def.Name] = idx
+	f.indexMu.Unlock()
+	return nil
+}
+
+func (f *FSM) applyIndexDrop(name string) error {
+	f.indexMu.Lock()
+	_, ok := f.indexes[name]
+	delete(f.indexes, name)
+	f.indexMu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	return f.store.Delete(indexPrefix + name)
+}
+
+func (f *FSM) Indexes() []IndexDef {
+	f.indexMu.RLock()
+	defer f.indexMu.RUnlock()
+	defs := make([]IndexDef, 0, len(f.indexes))
+	for _, idx := range f.indexes {
+		defs = append(defs, idx.def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// QueryIndex returns the keys whose indexed value lies within [from, to],
+// ordered by value and then key. A nil bound is open.
+func (f *FSM) QueryIndex(name string, from, to *string) ([]string, error) {
+	f.indexMu.RLock()
+	defer f.indexMu.RUnlock()
+	idx, ok := f.indexes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+	var lo, hi *indexValue
+	if from != nil {
+		v := parseIndexValue(*from)
+		lo = &v
+	}
+	if to != nil {
+		v := parseIndexValue(*to)
+		hi = &v
+	}
+	return idx.lookup(lo, hi), nil
+}
+
+func (n *Node) DefineIndex(def IndexDef) error {
+	val, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	_, err = n.ApplyCommand(Command{Op: OpIndexDefine, Key: def.Name, Val: val})
+	return err
+}
+
+func (n *Node) DropIndex(name string) error {
+	_, err := n.ApplyCommand(Command{Op: OpIndexDrop, Key: name})
+	return err
+}
+
+func (n *Node) Indexes() []IndexDef {
+	return n.fsm.Indexes()
+}
+
+// QueryIndex reads this node's copy of the index; like Get it may lag the
+// leader on a follower.
+func (n *Node) QueryIndex(name string, from, to *string) ([]string, error) {
+	return n.fsm.QueryIndex(name, from, to)
+}
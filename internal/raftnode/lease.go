@@ -0,0 +1,314 @@
+package raftnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	leasePrefix   = bitcask.SystemPrefix + "lease/"
+	minLeaseTTL   = time.Second
+	leaseInterval = 500 * time.Millisecond
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease not found")
+	ErrLeaseTTL      = fmt.Errorf("lease ttl must be at least %s", minLeaseTTL)
+)
+
+// Lease is a replicated timer. Its ID is the Raft index that granted it and
+// its expiry is measured against the leader's append time of each entry, so
+// every replica agrees on whether it is live. Keys attached to a lease are
+// deleted when it is revoked or expires.
+type Lease struct {
+	ID        uint64        `json:"id"`
+	TTL       time.Duration `json:"ttl"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	Keys      []string      `json:"keys,omitempty"`
+}
+
+func leaseKey(id uint64) string {
+	return leasePrefix + strconv.FormatUint(id, 10)
+}
+
+func (f *FSM) loadLeases() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leases = make(map[uint64]*Lease)
+	f.keyLease = make(map[string]uint64)
+	for _, k := range f.store.KeysWithPrefix(leasePrefix) {
+		val, err := f.store.Get(k)
+		if err != nil {
+			return err
+		}
+		var l Lease
+		if err := json.Unmarshal(val, &l); err != nil {
+			return fmt.Errorf("decode lease %q: %w", strings.TrimPrefix(k, leasePrefix), err)
+		}
+		f.leases[l.ID] = &l
+		for _, key := range l.Keys {
+			f.keyLease[key] = l.ID
+		}
+	}
+	return nil
+}
+
+func (f *FSM) saveLease(l *Lease) error {
+	val, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return f.store.Put(leaseKey(l.ID), val)
+}
+
+func (f *FSM) applyLeaseGrant(ttl time.Duration, index uint64, ts time.Time) (*Lease, error) {
+	if ttl < minLeaseTTL {
+		return nil, ErrLeaseTTL
+	}
+	l := &Lease{ID: index, TTL: ttl, ExpiresAt: ts.Add(ttl)}
+	if err := f.saveLease(l); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.leases[l.ID] = l
+	f.mu.Unlock()
+	return l, nil
+}
+
+func (f *FSM) applyLeaseKeepAlive(id uint64, ts time.Time) (*Lease, error) {
+	f.mu.Lock()
+	l, ok := f.leases[id]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrLeaseNotFound, id)
+	}
+	renewed := *l
+	renewed.ExpiresAt = ts.Add(l.TTL)
+	if err := f.saveLease(&renewed); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.leases[id] = &renewed
+	f.mu.Unlock()
+	return &renewed, nil
+}
+
+// applyLeaseRevoke deletes the lease and every key attached to it. When
+// onlyExpired is set the lease is kept unless it has expired as of ts, which
+// lets a keepalive that was committed first win over a racing expiry.
+func (f *FSM) applyLeaseRevoke(id uint64, index uint64, ts time.Time, onlyExpired bool) error {
+	f.mu.Lock()
+	l, ok := f.leases[id]
+	f.mu.Unlock()
+	if !ok {
+		if onlyExpired {
+			return nil
+		}
+		return fmt.Errorf("%w: %d", ErrLeaseNotFound, id)
+	}
+	if onlyExpired && ts.Before(l.ExpiresAt) {
+		return nil
+	}
+	for _, key := range l.Keys {
+		if err := f.store.DeleteAt(key, index, ts); err != nil {
+			return err
+		}
+		if err := f.keyChanged(key, index); err != nil {
+			return err
+		}
+	}
+	if err := f.store.Delete(leaseKey(id)); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	for _, key := range l.Keys {
+		delete(f.keyLease, key)
+	}
+	delete(f.leases, id)
+	f.mu.Unlock()
+	return nil
+}
+
+// attachLease moves key onto lease id, detaching it from any previous lease.
+// An id of zero only detaches.
+func (f *FSM) attachLease(key string, id uint64) error {
+	f.mu.Lock()
+	prev, attached := f.keyLease[key]
+	var next *Lease
+	if id != 0 {
+		l, ok := f.leases[id]
+		if !ok {
+			f.mu.Unlock()
+			return fmt.Errorf("%w: %d", ErrLeaseNotFound, id)
+		}
+		next = l
+	}
+	f.mu.Unlock()
+	if attached && prev == id {
+		return nil
+	}
+
+	if attached {
+		f.mu.Lock()
+		old := *f.leases[prev]
+		f.mu.Unlock()
+		keys := make([]string, 0, len(old.Keys))
+		for _, k := range old.Keys {
+			if k != key {
+				keys = append(keys, k)
+			}
+		}
+		old.Keys = keys
+		if err := f.saveLease(&old); err != nil {
+			return err
+		}
+		f.mu.Lock()
+		f.leases[prev] = &old
+		delete(f.keyLease, key)
+		f.mu.Unlock()
+	}
+	if next == nil {
+		return nil
+	}
+	updated := *next
+	updated.Keys = append(append([]string(nil), next.Keys...), key)
+	sort.Strings(updated.Keys)
+	if err := f.saveLease(&updated); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.leases[id] = &updated
+	f.keyLease[key] = id
+	f.mu.Unlock()
+	return nil
+}
+
+// applyLeaseAttach moves an existing key to lease id, or detaches it from
+// its lease when id is 0, without rewriting the value. It reports whether
+// the key exists.
+func (f *FSM) applyLeaseAttach(key string, id uint64) (bool, error) {
+	if err := checkUserKey(key); err != nil {
+		return false, err
+	}
+	if _, err := f.store.Get(key); errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := f.attachLease(key, id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FSM) leaseOf(key string) (Lease, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.keyLease[key]
+	if !ok {
+		return Lease{}, false
+	}
+	return *f.leases[id], true
+}
+
+// expiredLeases lists leases whose expiry has passed by now.
+func (f *FSM) expiredLeases(now time.Time) []uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []uint64
+	for id, l := range f.leases {
+		if !now.Before(l.ExpiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (f *FSM) Leases() []Lease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	leases := make([]Lease, 0, len(f.leases))
+	for _, l := range f.leases {
+		leases = append(leases, *l)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ID < leases[j].ID })
+	return leases
+}
+
+// runLeaseExpiry proposes LEASE_EXPIRE entries for overdue leases while this
+// node is leader. Followers never expire leases on their own.
+func (n *Node) runLeaseExpiry() {
+	ticker := time.NewTicker(leaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		if n.Raft.State() != raft.Leader {
+			continue
+		}
+		for _, id := range n.fsm.expiredLeases(time.Now()) {
+			if _, err := n.ApplyCommand(Command{Op: OpLeaseExpire, Lease: id}); err != nil {
+				n.log.Warn("failed to expire lease", "lease", id, "err", err)
+			}
+		}
+	}
+}
+
+func (n *Node) GrantLease(ttl time.Duration) (*Lease, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpLeaseGrant, TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Lease), nil
+}
+
+func (n *Node) KeepAliveLease(id uint64) (*Lease, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpLeaseKeepAlive, Lease: id})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Lease), nil
+}
+
+func (n *Node) RevokeLease(id uint64) error {
+	_, err := n.ApplyCommand(Command{Op: OpLeaseRevoke, Lease: id})
+	return err
+}
+
+// PutWithLease writes key and attaches it to lease id.
+func (n *Node) PutWithLease(key string, val []byte, id uint64) error {
+	_, err := n.ApplyCommand(Command{Op: OpPut, Key: key, Val: val, Lease: id})
+	return err
+}
+
+// AttachLease puts an existing key under lease id, or makes it permanent
+// again when id is 0. It reports false if the key does not exist.
+func (n *Node) AttachLease(key string, id uint64) (bool, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpLeaseAttach, Key: key, Lease: id})
+	if err != nil {
+		return false, err
+	}
+	return resp.(bool), nil
+}
+
+// KeyLease returns the lease key is attached to, as seen by this node.
+func (n *Node) KeyLease(key string) (Lease, bool) {
+	return n.fsm.leaseOf(key)
+}
+
+func (n *Node) Leases() []Lease {
+	return n.fsm.Leases()
+}
@@ -0,0 +1,32 @@
+package raftnode
+
+import (
+	"strconv"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// ReportMetrics sets the gauges describing Raft and the store, for a
+// metrics sink to call before it is scraped. Counters and timings are
+// recorded as they happen, by the Raft library and by this package.
+//
+// raft_state is 0 for a follower, 1 for a candidate, 2 for the leader and
+// 3 once shut down.
+func (n *Node) ReportMetrics() {
+	r := n.Raft
+	metrics.SetGauge([]string{"raft", "state"}, float32(r.State()))
+	metrics.SetGauge([]string{"raft", "term"}, float32(r.CurrentTerm()))
+	metrics.SetGauge([]string{"raft", "commit_index"}, float32(r.CommitIndex()))
+	metrics.SetGauge([]string{"raft", "applied_index"}, float32(r.AppliedIndex()))
+	metrics.SetGauge([]string{"raft", "last_log_index"}, float32(r.LastIndex()))
+	if idx, err := strconv.ParseUint(r.Stats()["last_snapshot_index"], 10, 64); err == nil {
+		metrics.SetGauge([]string{"raft", "last_snapshot_index"}, float32(idx))
+	}
+
+	st := n.Store.Stats()
+	metrics.SetGauge([]string{"bitcask", "keys"}, float32(st.Keys))
+	metrics.SetGauge([]string{"bitcask", "data_files"}, float32(st.DataFiles))
+	metrics.SetGauge([]string{"bitcask", "data_bytes"}, float32(st.DataBytes))
+	metrics.SetGauge([]string{"bitcask", "live_bytes"}, float32(st.LiveBytes))
+	metrics.SetGauge([]string{"bitcask", "dead_bytes"}, float32(st.DeadBytes))
+}
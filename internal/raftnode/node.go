@@ -1,14 +1,23 @@
 package raftnode
 
 import (
-	"bytes"
-	"encoding/gob"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/AMS003010/Hyphora/internal/tlsutil"
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 )
@@ -17,69 +26,150 @@ type Node struct {
 	Raft     *raft.Raft
 	Store    *bitcask.Bitcask
 	HTTPPort string
+	fsm      *FSM
+
+	id        raft.ServerID
+	meta      NodeMeta
+	forwarder Forwarder
+	peers     *peerHealth
+
+	log         *slog.Logger
+	transport   *raft.NetworkTransport
+	logStore    *raftboltdb.BoltStore
+	stableStore *raftboltdb.BoltStore
+	snapshots   *raft.FileSnapshotStore
+	// maintenance keeps compactions and requested snapshots apart.
+	maintenance sync.Mutex
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
-func NewNode(dataDir string, bindAddr string, raftID string, httpPort string) (*Node, error) {
-	// Register Raft command struct
-	gob.Register(struct {
-		Op  string
-		Key string
-		Val []byte
-	}{})
+// Config describes a node. A node with Bootstrap set forms a new
+// single-node cluster when it has no Raft state yet; any other new node
+// waits to be added to a cluster, or for BootstrapCluster.
+type Config struct {
+	DataDir   string
+	BindAddr  string
+	ID        string
+	HTTPPort  string
+	Bootstrap bool
 
-	// Setup directories
-	raftDir := filepath.Join(dataDir, "raft")
-	if err := os.MkdirAll(raftDir, 0755); err != nil {
-		return nil, err
-	}
+	// AdvertiseAddr is the Raft address other nodes dial, when BindAddr
+	// is not one they can reach, such as 0.0.0.0:9001.
+	AdvertiseAddr string
 
-	// Open Bitcask
-	Store, err := bitcask.Open(filepath.Join(dataDir, "bitcask"))
-	if err != nil {
-		return nil, err
-	}
+	// Raft tuning; zero values keep raft.DefaultConfig's.
+	HeartbeatTimeout   time.Duration
+	ElectionTimeout    time.Duration
+	LeaderLeaseTimeout time.Duration
+	CommitTimeout      time.Duration
+	SnapshotInterval   time.Duration
+	SnapshotThreshold  uint64
+	TrailingLogs       uint64
 
-	// Raft config
+	// SnapshotRetain is how many snapshots to keep on disk; 0 keeps 1.
+	SnapshotRetain int
+	// MaxPool is the number of connections kept open to each peer; 0
+	// keeps 3. TransportTimeout bounds each transport I/O; 0 is 10s.
+	MaxPool          int
+	TransportTimeout time.Duration
+
+	// TLS, when set, carries Raft traffic over mutual TLS.
+	TLS *tlsutil.Store
+
+	// Logger receives the node's logs, the store's and Raft's; nil uses
+	// slog's default logger.
+	Logger *slog.Logger
+}
+
+// RaftConfig returns the Raft configuration cfg describes.
+func (cfg Config) RaftConfig() *raft.Config {
 	config := raft.DefaultConfig()
-	config.LocalID = raft.ServerID(raftID)
+	config.LocalID = raft.ServerID(cfg.ID)
+	setIf(&config.HeartbeatTimeout, cfg.HeartbeatTimeout)
+	setIf(&config.ElectionTimeout, cfg.ElectionTimeout)
+	setIf(&config.LeaderLeaseTimeout, cfg.LeaderLeaseTimeout)
+	setIf(&config.CommitTimeout, cfg.CommitTimeout)
+	setIf(&config.SnapshotInterval, cfg.SnapshotInterval)
+	setIf(&config.SnapshotThreshold, cfg.SnapshotThreshold)
+	setIf(&config.TrailingLogs, cfg.TrailingLogs)
+	// Per-peer metrics are recorded with a peer label rather than under
+	// names that embed the peer's ID.
+	config.NoLegacyTelemetry = true
+	return config
+}
 
-	// Raft communication
-	addr, err := raft.NewTCPTransport(bindAddr, nil, 3, 10*time.Second, os.Stderr)
-	if err != nil {
-		return nil, err
+func setIf[T comparable](dst *T, v T) {
+	var zero T
+	if v != zero {
+		*dst = v
 	}
+}
 
-	// Stable Store (BoltDB)
-	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "stable.db"))
-	if err != nil {
+func NewNode(cfg Config) (*Node, error) {
+	bindAddr, httpPort := cfg.BindAddr, cfg.HTTPPort
+
+	logger := cmp.Or(cfg.Logger, slog.Default())
+	raftLog := logging.HCLog(logger, "raft")
+
+	// Raft config
+	config := cfg.RaftConfig()
+	config.Logger = raftLog
+	if err := raft.ValidateConfig(config); err != nil {
 		return nil, err
 	}
 
-	// Log Store (BoltDB)
-	logStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "raft-log.db"))
+	st, err := openStores(cfg, logger, raftLog)
 	if err != nil {
 		return nil, err
 	}
+	Store, stableStore, logStore, snapshots := st.data, st.stable, st.logs, st.snapshots
 
-	// Snapshot Store
-	snapshots, err := raft.NewFileSnapshotStore(raftDir, 1, os.Stderr)
-	if err != nil {
+	// Raft communication
+	var advertise net.Addr
+	if cfg.AdvertiseAddr != "" {
+		if advertise, err = net.ResolveTCPAddr("tcp", cfg.AdvertiseAddr); err != nil {
+			return nil, fmt.Errorf("raft advertise address: %w", err)
+		}
+	}
+	maxPool, timeout := cmp.Or(cfg.MaxPool, 3), cmp.Or(cfg.TransportTimeout, 10*time.Second)
+	var addr *raft.NetworkTransport
+	if cfg.TLS != nil {
+		stream, err := newTLSStreamLayer(bindAddr, advertise, cfg.TLS.PeerServerConfig(), cfg.TLS.ClientConfig())
+		if err != nil {
+			return nil, err
+		}
+		addr = raft.NewNetworkTransportWithLogger(stream, maxPool, timeout, raftLog.Named("transport"))
+	} else if addr, err = raft.NewTCPTransportWithLogger(bindAddr, advertise, maxPool, timeout, raftLog.Named("transport")); err != nil {
 		return nil, err
 	}
 
 	// FSM
-	fsm := NewFSM(Store)
+	fsm, err := NewFSM(Store)
+	if err != nil {
+		return nil, err
+	}
 
 	// Raft instance
-	r, err := raft.NewRaft(config, fsm, logStore, stableStore, snapshots, addr)
+	peers := newPeerHealth()
+	r, err := raft.NewRaft(config, fsm, logStore, stableStore, snapshots, &contactTransport{addr, peers})
 	if err != nil {
 		return nil, err
 	}
 
 	node := &Node{
-		Raft:     r,
-		Store:    Store,
-		HTTPPort: httpPort,
+		Raft:        r,
+		Store:       Store,
+		HTTPPort:    httpPort,
+		fsm:         fsm,
+		log:         logger,
+		id:          config.LocalID,
+		transport:   addr,
+		logStore:    logStore,
+		stableStore: stableStore,
+		snapshots:   snapshots,
+		done:        make(chan struct{}),
+		peers:       peers,
 	}
 
 	// Check if Raft has any existing configuration
@@ -88,8 +178,8 @@ func NewNode(dataDir string, bindAddr string, raftID string, httpPort string) (*
 		return nil, fmt.Errorf("checking existing raft state: %w", err)
 	}
 
-	// If no state, bootstrap the cluster with this node
-	if !hasState {
+	// If no state, bootstrap the cluster with this node when told to
+	if !hasState && cfg.Bootstrap {
 		configuration := raft.Configuration{
 			Servers: []raft.Server{
 				{
@@ -104,25 +194,215 @@ func NewNode(dataDir string, bindAddr string, raftID string, httpPort string) (*
 		}
 	}
 
+	go node.runLeaseExpiry()
+	go node.watchPeers()
+
 	return node, nil
 }
 
+// stores are the node's state on disk.
+type stores struct {
+	data      *bitcask.Bitcask
+	stable    *raftboltdb.BoltStore
+	logs      *raftboltdb.BoltStore
+	snapshots *raft.FileSnapshotStore
+}
+
+// openStores opens the stores under cfg.DataDir, creating them if needed.
+func openStores(cfg Config, logger *slog.Logger, raftLog hclog.Logger) (*stores, error) {
+	// Setup directories
+	raftDir := filepath.Join(cfg.DataDir, "raft")
+	if err := os.MkdirAll(raftDir, 0755); err != nil {
+		return nil, err
+	}
+
+	st := &stores{}
+	var err error
+	// Stable Store (BoltDB)
+	if st.stable, err = openBolt(filepath.Join(raftDir, "stable.db")); err != nil {
+		return nil, err
+	}
+
+	// Log Store (BoltDB)
+	if st.logs, err = openBolt(filepath.Join(raftDir, "raft-log.db")); err != nil {
+		st.close()
+		return nil, err
+	}
+
+	// Open Bitcask, once the Raft stores show no other process has the
+	// data directory open.
+	if st.data, err = bitcask.Open(filepath.Join(cfg.DataDir, "bitcask"), logger.With("component", "bitcask")); err != nil {
+		st.close()
+		return nil, err
+	}
+
+	// Snapshot Store
+	if st.snapshots, err = raft.NewFileSnapshotStoreWithLogger(raftDir, cmp.Or(cfg.SnapshotRetain, 1), raftLog.Named("snapshot")); err != nil {
+		st.close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// openBolt opens a Raft store, failing rather than waiting while another
+// process holds it.
+func openBolt(path string) (*raftboltdb.BoltStore, error) {
+	store, err := raftboltdb.New(raftboltdb.Options{Path: path, BoltOptions: &bolt.Options{Timeout: time.Second}})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use; is the node still running?", path)
+	}
+	return store, err
+}
+
+// close closes the stores that were opened.
+func (st *stores) close() error {
+	var errs []error
+	if st.data != nil {
+		errs = append(errs, st.data.Close())
+	}
+	if st.logs != nil {
+		errs = append(errs, st.logs.Close())
+	}
+	if st.stable != nil {
+		errs = append(errs, st.stable.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// BootstrapCluster forms a new cluster of servers, which must include
+// this node. Every initial member may be bootstrapped with the same
+// servers; a node that already has state returns raft.ErrCantBootstrap.
+func (n *Node) BootstrapCluster(servers []raft.Server) error {
+	return n.Raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+}
+
+// InCluster reports whether this node is part of the latest Raft
+// configuration it knows. A new node that was not bootstrapped is not,
+// until a leader adds it.
+func (n *Node) InCluster() bool {
+	fut := n.Raft.GetConfiguration()
+	if fut.Error() != nil {
+		return false
+	}
+	for _, srv := range fut.Configuration().Servers {
+		if srv.ID == n.id {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger returns the logger the node was configured with.
+func (n *Node) Logger() *slog.Logger {
+	return n.log
+}
+
+// Meta returns the addresses passed to Advertise.
+func (n *Node) Meta() NodeMeta {
+	return n.meta
+}
+
+// Close stops the node's background work, shuts Raft down and closes its
+// stores. The node cannot be used afterwards.
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = errors.Join(
+			n.Raft.Shutdown().Error(),
+			n.transport.Close(),
+			n.logStore.Close(),
+			n.stableStore.Close(),
+			n.Store.Close(),
+		)
+	})
+	return err
+}
+
+// Shutdown hands leadership to another voter if this node leads, so the
+// cluster need not wait out an election, then closes the node like Close.
+// The transfer is given up when ctx is done.
+func (n *Node) Shutdown(ctx context.Context) error {
+	if n.Raft.State() == raft.Leader && n.hasOtherVoters() {
+		fut := n.Raft.LeadershipTransfer()
+		errc := make(chan error, 1)
+		go func() { errc <- fut.Error() }()
+		select {
+		case err := <-errc:
+			if err != nil {
+				n.log.Warn("failed to transfer leadership", "err", err)
+			} else {
+				n.log.Info("transferred leadership")
+			}
+		case <-ctx.Done():
+			n.log.Warn("gave up transferring leadership", "err", ctx.Err())
+		}
+	}
+	return n.Close()
+}
+
+// hasOtherVoters reports whether another server can take leadership.
+func (n *Node) hasOtherVoters() bool {
+	fut := n.Raft.GetConfiguration()
+	if fut.Error() != nil {
+		return false
+	}
+	for _, srv := range fut.Configuration().Servers {
+		if srv.ID != n.id && srv.Suffrage == raft.Voter {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *Node) Apply(op, key string, val []byte) error {
-	var buf bytes.Buffer
-	cmd := struct {
-		Op  string
-		Key string
-		Val []byte
-	}{op, key, val}
-
-	if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
-		return err
+	_, err := n.ApplyCommand(Command{Op: op, Key: key, Val: val})
+	return err
+}
+
+// ApplyCommand replicates cmd and returns the FSM's response. An error
+// returned by the FSM is reported as the error, not as the response. On a
+// follower the command is forwarded to the leader when a Forwarder is set.
+func (n *Node) ApplyCommand(cmd Command) (interface{}, error) {
+	if n.forwarder != nil && n.Raft.State() != raft.Leader {
+		return n.forward(cmd)
+	}
+	return n.applyLocal(cmd)
+}
+
+func (n *Node) applyLocal(cmd Command) (interface{}, error) {
+	data, err := cmd.encode()
+	if err != nil {
+		return nil, err
 	}
 
-	f := n.Raft.Apply(buf.Bytes(), 5*time.Second)
-	return f.Error()
+	f := n.Raft.Apply(data, 5*time.Second)
+	if err := f.Error(); err != nil {
+		return nil, err
+	}
+	resp := f.Response()
+	if err, ok := resp.(error); ok {
+		return nil, err
+	}
+	return resp, nil
 }
 
 func (n *Node) Get(key string) ([]byte, error) {
 	return n.Store.Get(key)
 }
+
+func (n *Node) GetAt(key string, atIndex uint64) ([]byte, error) {
+	return n.Store.GetAt(key, atIndex)
+}
+
+func (n *Node) History(key string) ([]bitcask.Version, error) {
+	return n.Store.History(key)
+}
+
+func (n *Node) SetRetention(p bitcask.RetentionPolicy) error {
+	val, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return n.Apply(OpRetain, p.Prefix, val)
+}
@@ -0,0 +1,119 @@
+package raftnode
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/raft"
+)
+
+// peerHealth records, on the leader, when each follower last answered an
+// AppendEntries or InstallSnapshot call, and which followers the leader
+// currently fails to heartbeat, from Raft's observations. Both are reset
+// whenever leadership changes.
+type peerHealth struct {
+	mu          sync.Mutex
+	contact     map[raft.ServerID]time.Time
+	unreachable map[raft.ServerID]time.Time
+}
+
+func newPeerHealth() *peerHealth {
+	return &peerHealth{
+		contact:     make(map[raft.ServerID]time.Time),
+		unreachable: make(map[raft.ServerID]time.Time),
+	}
+}
+
+func (p *peerHealth) contacted(id raft.ServerID) {
+	p.mu.Lock()
+	p.contact[id] = time.Now()
+	p.mu.Unlock()
+}
+
+// contactTransport records every answer a peer gives to the calls only
+// the leader makes. Raft sends heartbeats through AppendEntries, so a
+// follower's contact time stays current while the leader reaches it.
+type contactTransport struct {
+	*raft.NetworkTransport
+	peers *peerHealth
+}
+
+func (t *contactTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	err := t.NetworkTransport.AppendEntries(id, target, args, resp)
+	if err == nil {
+		t.peers.contacted(id)
+	}
+	return err
+}
+
+func (t *contactTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	err := t.NetworkTransport.InstallSnapshot(id, target, args, resp, data)
+	if err == nil {
+		t.peers.contacted(id)
+	}
+	return err
+}
+
+func (n *Node) watchPeers() {
+	ch := make(chan raft.Observation, 64)
+	obs := raft.NewObserver(ch, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation, raft.PeerObservation, raft.LeaderObservation:
+			return true
+		}
+		return false
+	})
+	n.Raft.RegisterObserver(obs)
+	defer n.Raft.DeregisterObserver(obs)
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case o := <-ch:
+			n.peers.mu.Lock()
+			switch d := o.Data.(type) {
+			case raft.FailedHeartbeatObservation:
+				n.peers.unreachable[d.PeerID] = d.LastContact
+			case raft.ResumedHeartbeatObservation:
+				delete(n.peers.unreachable, d.PeerID)
+			case raft.PeerObservation:
+				if d.Removed {
+					delete(n.peers.unreachable, d.Peer.ID)
+					delete(n.peers.contact, d.Peer.ID)
+				}
+			case raft.LeaderObservation:
+				clear(n.peers.unreachable)
+				clear(n.peers.contact)
+				if d.LeaderID != "" {
+					metrics.IncrCounter([]string{"raft", "leader_changes"}, 1)
+				}
+			}
+			n.peers.mu.Unlock()
+		}
+	}
+}
+
+// PeerContact reports whether the leader is in contact with the server
+// id and when it last heard from it. A peer is reachable once it has
+// answered the leader and while its heartbeats succeed. Only the leader
+// contacts its peers, so on a follower, and for a peer that never
+// answered, the peer is unreachable and the time is zero. The leader
+// itself is always reachable.
+func (n *Node) PeerContact(id string) (reachable bool, lastContact time.Time) {
+	if n.Raft.State() != raft.Leader {
+		return false, time.Time{}
+	}
+	if raft.ServerID(id) == n.id {
+		return true, time.Time{}
+	}
+	n.peers.mu.Lock()
+	defer n.peers.mu.Unlock()
+	last, ok := n.peers.contact[raft.ServerID(id)]
+	if _, failing := n.peers.unreachable[raft.ServerID(id)]; failing {
+		return false, last
+	}
+	return ok, last
+}
@@ -0,0 +1,316 @@
+package raftnode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+const queuePrefix = bitcask.SystemPrefix + "queue/"
+
+var (
+	ErrQueueEmpty     = errors.New("queue is empty")
+	ErrQueueNoReceipt = errors.New("no in-flight item with this receipt")
+	ErrQueueName      = errors.New("queue name must be non-empty and contain no '/'")
+)
+
+// queueMeta holds the queue's pointers. Items in [Head, Tail) have never
+// been delivered; delivered items stay on disk as in-flight until acked.
+type queueMeta struct {
+	Head uint64 `json:"head"`
+	Tail uint64 `json:"tail"`
+}
+
+type inflight struct {
+	Receipt    uint64    `json:"receipt"`
+	Deadline   time.Time `json:"deadline"`
+	Deliveries int       `json:"deliveries"`
+}
+
+// QueueItem is a delivered queue entry. Receipt is the Raft index of the
+// delivery and must be passed to AckQueue before Deadline, otherwise the
+// item becomes visible again and is redelivered with a new receipt.
+type QueueItem struct {
+	Seq        uint64    `json:"seq"`
+	Receipt    uint64    `json:"receipt,omitempty"`
+	Deadline   time.Time `json:"deadline,omitzero"`
+	Deliveries int       `json:"deliveries,omitempty"`
+	Value      []byte    `json:"-"`
+}
+
+type QueueStats struct {
+	Pending  uint64 `json:"pending"`
+	InFlight int    `json:"in_flight"`
+}
+
+func queueMetaKey(name string) string {
+	return queuePrefix + name + "/meta"
+}
+
+func queueItemKey(name string, seq uint64) string {
+	return fmt.Sprintf("%s%s/item/%020d", queuePrefix, name, seq)
+}
+
+func queueInflightPrefix(name string) string {
+	return queuePrefix + name + "/inflight/"
+}
+
+func queueInflightKey(name string, seq uint64) string {
+	return fmt.Sprintf("%s%020d", queueInflightPrefix(name), seq)
+}
+
+func (f *FSM) queueMeta(name string) (queueMeta, error) {
+	var m queueMeta
+	val, err := f.store.Get(queueMetaKey(name))
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(val, &m)
+	return m, err
+}
+
+func (f *FSM) putJSON(key string, v any) error {
+	val, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.store.Put(key, val)
+}
+
+func checkQueueName(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return ErrQueueName
+	}
+	return nil
+}
+
+// loadQueues rebuilds the in-flight items of every queue from the store.
+func (f *FSM) loadQueues() error {
+	queues := make(map[string]map[uint64]inflight)
+	for _, k := range f.store.KeysWithPrefix(queuePrefix) {
+		name, rest, ok := strings.Cut(strings.TrimPrefix(k, queuePrefix), "/inflight/")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseUint(rest, 10, 64)
+		if err != nil {
+			return fmt.Errorf("corrupt in-flight key %q", k)
+		}
+		val, err := f.store.Get(k)
+		if err != nil {
+			return err
+		}
+		var it inflight
+		if err := json.Unmarshal(val, &it); err != nil {
+			return fmt.Errorf("decode in-flight item %q: %w", k, err)
+		}
+		if queues[name] == nil {
+			queues[name] = make(map[uint64]inflight)
+		}
+		queues[name][seq] = it
+	}
+	f.queues = queues
+	return nil
+}
+
+// inflightSeqs returns the sequence numbers of the queue's in-flight items
+// in order.
+func (f *FSM) inflightSeqs(name string) []uint64 {
+	return slices.Sorted(maps.Keys(f.queues[name]))
+}
+
+func (f *FSM) setInflight(name string, seq uint64, it inflight) error {
+	if err := f.putJSON(queueInflightKey(name, seq), it); err != nil {
+		return err
+	}
+	if f.queues[name] == nil {
+		f.queues[name] = make(map[uint64]inflight)
+	}
+	f.queues[name][seq] = it
+	return nil
+}
+
+// dropItem deletes a delivered item and its in-flight record.
+func (f *FSM) dropItem(name string, seq uint64) error {
+	if err := f.store.Delete(queueItemKey(name, seq)); err != nil {
+		return err
+	}
+	if _, ok := f.queues[name][seq]; !ok {
+		return nil
+	}
+	if err := f.store.Delete(queueInflightKey(name, seq)); err != nil {
+		return err
+	}
+	delete(f.queues[name], seq)
+	if len(f.queues[name]) == 0 {
+		delete(f.queues, name)
+	}
+	return nil
+}
+
+func (f *FSM) applyEnqueue(name string, val []byte) (uint64, error) {
+	if err := checkQueueName(name); err != nil {
+		return 0, err
+	}
+	m, err := f.queueMeta(name)
+	if err != nil {
+		return 0, err
+	}
+	seq := m.Tail
+	if err := f.store.Put(queueItemKey(name, seq), val); err != nil {
+		return 0, err
+	}
+	m.Tail++
+	if err := f.putJSON(queueMetaKey(name), m); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// applyDequeue delivers the oldest item whose visibility timeout has run
+// out, or else the head of the queue. A zero visibility removes the item
+// immediately instead of waiting for an ack.
+func (f *FSM) applyDequeue(name string, visibility time.Duration, index uint64, ts time.Time) (*QueueItem, error) {
+	if err := checkQueueName(name); err != nil {
+		return nil, err
+	}
+	seq, deliveries, found := uint64(0), 0, false
+	for _, s := range f.inflightSeqs(name) {
+		if it := f.queues[name][s]; !ts.Before(it.Deadline) {
+			seq, deliveries, found = s, it.Deliveries, true
+			break
+		}
+	}
+	if !found {
+		m, err := f.queueMeta(name)
+		if err != nil {
+			return nil, err
+		}
+		if m.Head >= m.Tail {
+			return nil, fmt.Errorf("%w: %s", ErrQueueEmpty, name)
+		}
+		seq = m.Head
+		m.Head++
+		if err := f.putJSON(queueMetaKey(name), m); err != nil {
+			return nil, err
+		}
+	}
+
+	val, err := f.store.Get(queueItemKey(name, seq))
+	if err != nil {
+		return nil, err
+	}
+	item := &QueueItem{Seq: seq, Deliveries: deliveries + 1, Value: val}
+	if visibility <= 0 {
+		return item, f.dropItem(name, seq)
+	}
+	item.Receipt = index
+	item.Deadline = ts.Add(visibility)
+	rec := inflight{Receipt: item.Receipt, Deadline: item.Deadline, Deliveries: item.Deliveries}
+	if err := f.setInflight(name, seq, rec); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (f *FSM) applyAck(name string, receipt uint64) error {
+	if err := checkQueueName(name); err != nil {
+		return err
+	}
+	for seq, it := range f.queues[name] {
+		if it.Receipt == receipt {
+			return f.dropItem(name, seq)
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrQueueNoReceipt, receipt)
+}
+
+// applyPeek returns the item the next dequeue would deliver, without
+// delivering it.
+func (f *FSM) applyPeek(name string, ts time.Time) (*QueueItem, error) {
+	if err := checkQueueName(name); err != nil {
+		return nil, err
+	}
+	for _, seq := range f.inflightSeqs(name) {
+		if it := f.queues[name][seq]; !ts.Before(it.Deadline) {
+			val, err := f.store.Get(queueItemKey(name, seq))
+			if err != nil {
+				return nil, err
+			}
+			return &QueueItem{Seq: seq, Deliveries: it.Deliveries, Value: val}, nil
+		}
+	}
+	m, err := f.queueMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.Head >= m.Tail {
+		return nil, fmt.Errorf("%w: %s", ErrQueueEmpty, name)
+	}
+	val, err := f.store.Get(queueItemKey(name, m.Head))
+	if err != nil {
+		return nil, err
+	}
+	return &QueueItem{Seq: m.Head, Value: val}, nil
+}
+
+func (f *FSM) applyQueueLen(name string) (*QueueStats, error) {
+	if err := checkQueueName(name); err != nil {
+		return nil, err
+	}
+	m, err := f.queueMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueStats{Pending: m.Tail - m.Head, InFlight: len(f.queues[name])}, nil
+}
+
+func (n *Node) Enqueue(name string, val []byte) (uint64, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpEnqueue, Key: name, Val: val})
+	if err != nil {
+		return 0, err
+	}
+	return resp.(uint64), nil
+}
+
+// Dequeue pops the next item, hiding it from other consumers for visibility.
+func (n *Node) Dequeue(name string, visibility time.Duration) (*QueueItem, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpDequeue, Key: name, TTL: visibility})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*QueueItem), nil
+}
+
+func (n *Node) AckQueue(name string, receipt uint64) error {
+	_, err := n.ApplyCommand(Command{Op: OpQueueAck, Key: name, Token: receipt})
+	return err
+}
+
+// PeekQueue and QueueLen go through the log as well, so they observe every
+// operation committed before them even right after a leader change.
+func (n *Node) PeekQueue(name string) (*QueueItem, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpQueuePeek, Key: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*QueueItem), nil
+}
+
+func (n *Node) QueueLen(name string) (*QueueStats, error) {
+	resp, err := n.ApplyCommand(Command{Op: OpQueueLen, Key: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*QueueStats), nil
+}
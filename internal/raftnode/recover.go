@@ -0,0 +1,95 @@
+package raftnode
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"github.com/hashicorp/raft"
+)
+
+// RecoveryPoint is the last Raft entry a stopped node holds, from its log
+// or, past log compaction, its latest snapshot.
+type RecoveryPoint struct {
+	Index uint64
+	Term  uint64
+}
+
+// LastEntry returns the last entry the stopped node cfg describes holds.
+// Among the survivors of a cluster that lost its quorum, the one with the
+// highest index has the most of the committed state.
+func LastEntry(cfg Config) (RecoveryPoint, error) {
+	logger := cmp.Or(cfg.Logger, slog.Default())
+	st, err := openStores(cfg, logger, logging.HCLog(logger, "raft"))
+	if err != nil {
+		return RecoveryPoint{}, err
+	}
+	defer st.close()
+	var last RecoveryPoint
+	snaps, err := st.snapshots.List()
+	if err != nil {
+		return RecoveryPoint{}, err
+	}
+	if len(snaps) > 0 {
+		last = RecoveryPoint{Index: snaps[0].Index, Term: snaps[0].Term}
+	}
+	index, err := st.logs.LastIndex()
+	if err != nil {
+		return RecoveryPoint{}, err
+	}
+	if index > last.Index {
+		var entry raft.Log
+		if err := st.logs.GetLog(index, &entry); err != nil {
+			return RecoveryPoint{}, err
+		}
+		last = RecoveryPoint{Index: entry.Index, Term: entry.Term}
+	}
+	return last, nil
+}
+
+// Recover forces servers as the Raft configuration of the stopped node
+// cfg describes, for a cluster that lost its quorum and cannot elect a
+// leader to change its membership. It commits every entry in the node's
+// log, replays them into the store and replaces the log with a snapshot
+// holding the new configuration, through raft.RecoverCluster.
+//
+// Entries the lost servers committed but this node never received are
+// gone, and entries it received that were never committed become
+// committed. Every server in servers must be recovered with the same
+// configuration before any of them is started, and the servers left out
+// must never rejoin with their old state.
+func Recover(cfg Config, servers []raft.Server) error {
+	found := false
+	for _, srv := range servers {
+		if srv.ID == raft.ServerID(cfg.ID) {
+			if srv.Suffrage != raft.Voter {
+				return fmt.Errorf("node %s must be a voter of the recovered cluster", cfg.ID)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("node %s is not in the recovered configuration", cfg.ID)
+	}
+
+	logger := cmp.Or(cfg.Logger, slog.Default())
+	raftLog := logging.HCLog(logger, "raft")
+	config := cfg.RaftConfig()
+	config.Logger = raftLog
+	st, err := openStores(cfg, logger, raftLog)
+	if err != nil {
+		return err
+	}
+	fsm, err := NewFSM(st.data)
+	if err != nil {
+		st.close()
+		return err
+	}
+	// The snapshot store encodes the legacy peer list through a
+	// transport; nothing is sent.
+	_, trans := raft.NewInmemTransport("")
+	err = raft.RecoverCluster(config, fsm, st.logs, st.stable, st.snapshots, trans, raft.Configuration{Servers: servers})
+	return errors.Join(err, st.close())
+}
@@ -0,0 +1,69 @@
+package raftnode
+
+import (
+	"github.com/hashicorp/raft"
+)
+
+// Compact compacts the store's data files. It waits for a requested
+// snapshot to finish, and the snapshots Raft takes by itself are skipped
+// until it is done, rather than stalling the FSM behind the store's lock.
+func (n *Node) Compact() error {
+	n.maintenance.Lock()
+	defer n.maintenance.Unlock()
+	n.fsm.compacting.Store(true)
+	defer n.fsm.compacting.Store(false)
+	return n.Store.InitiateCompaction()
+}
+
+// Snapshot takes a Raft snapshot once any compaction in progress is done,
+// and truncates the log behind it. Raft keeps the configured trailing
+// entries for slow followers; with truncate the log drops every entry the
+// snapshot covers, and followers that need them are sent the snapshot.
+func (n *Node) Snapshot(truncate bool) (*raft.SnapshotMeta, error) {
+	fut, err := n.snapshot(truncate)
+	if err != nil {
+		return nil, err
+	}
+	meta, rc, err := fut.Open()
+	if err != nil {
+		return nil, err
+	}
+	rc.Close()
+	return meta, nil
+}
+
+func (n *Node) snapshot(truncate bool) (raft.SnapshotFuture, error) {
+	n.maintenance.Lock()
+	defer n.maintenance.Unlock()
+	if truncate {
+		rc := n.Raft.ReloadableConfig()
+		trailing := rc.TrailingLogs
+		rc.TrailingLogs = 0
+		if err := n.Raft.ReloadConfig(rc); err != nil {
+			return nil, err
+		}
+		defer func() {
+			rc.TrailingLogs = trailing
+			if err := n.Raft.ReloadConfig(rc); err != nil {
+				n.log.Error("failed to restore the trailing logs after a snapshot", "err", err)
+			}
+		}()
+	}
+	fut := n.Raft.Snapshot()
+	return fut, fut.Error()
+}
+
+// Snapshots lists the snapshots kept on disk, newest first.
+func (n *Node) Snapshots() ([]*raft.SnapshotMeta, error) {
+	return n.snapshots.List()
+}
+
+// LogRange returns the indexes of the first and last entries of the Raft
+// log, which are zero when snapshots have replaced all of it.
+func (n *Node) LogRange() (first, last uint64, err error) {
+	if first, err = n.logStore.FirstIndex(); err != nil {
+		return 0, 0, err
+	}
+	last, err = n.logStore.LastIndex()
+	return first, last, err
+}
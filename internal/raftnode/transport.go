@@ -0,0 +1,38 @@
+package raftnode
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// tlsStreamLayer carries Raft traffic over TLS. Both ends verify each
+// other during the handshake, which runs before the first read, so a
+// rejected peer's connection fails before Raft reads a message from it.
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	client    *tls.Config
+}
+
+func newTLSStreamLayer(bindAddr string, advertise net.Addr, server, client *tls.Config) (*tlsStreamLayer, error) {
+	l, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	if advertise == nil {
+		advertise = l.Addr()
+	}
+	return &tlsStreamLayer{Listener: tls.NewListener(l, server), advertise: advertise, client: client}, nil
+}
+
+func (t *tlsStreamLayer) Addr() net.Addr {
+	return t.advertise
+}
+
+func (t *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	d := &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: t.client}
+	return d.Dial("tcp", string(address))
+}
@@ -0,0 +1,195 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+var ErrTxnOp = errors.New("invalid transaction operation")
+
+// Compare asserts that Key is at Version, the Raft index of its last write.
+// Version 0 asserts that the key does not exist.
+type Compare struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// TxnOp is a "put", "del" or "get" executed by a transaction. A put with a
+// Lease attaches the key to it, like PutWithLease, and stores Flags with
+// the value.
+type TxnOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	Lease uint64 `json:"lease,omitempty"`
+	Flags uint32 `json:"flags,omitempty"`
+}
+
+// Txn runs Success when every Compare holds at apply time and Failure
+// otherwise, in the style of etcd's compare/success/failure transactions.
+type Txn struct {
+	Compare []Compare `json:"compare"`
+	Success []TxnOp   `json:"success"`
+	Failure []TxnOp   `json:"failure,omitempty"`
+}
+
+type TxnResponse struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+	Flags   uint32 `json:"flags,omitempty"`
+	Found   bool   `json:"found"`
+}
+
+// TxnResult reports which branch ran. Conflicts lists the compared keys
+// whose versions did not match; Responses holds one entry per "get".
+type TxnResult struct {
+	Succeeded bool          `json:"succeeded"`
+	Conflicts []string      `json:"conflicts,omitempty"`
+	Responses []TxnResponse `json:"responses,omitempty"`
+	Index     uint64        `json:"index"`
+}
+
+// textTxn is a transaction as logged by earlier versions, whose values
+// were strings.
+type textTxn struct {
+	Compare []Compare
+	Success []textTxnOp
+	Failure []textTxnOp
+}
+
+type textTxnOp struct {
+	Op    string
+	Key   string
+	Value string
+	Lease uint64
+	Flags uint32
+}
+
+func (t textTxn) txn() Txn {
+	ops := func(text []textTxnOp) []TxnOp {
+		var out []TxnOp
+		for _, op := range text {
+			out = append(out, TxnOp{Op: op.Op, Key: op.Key, Value: []byte(op.Value), Lease: op.Lease, Flags: op.Flags})
+		}
+		return out
+	}
+	return Txn{Compare: t.Compare, Success: ops(t.Success), Failure: ops(t.Failure)}
+}
+
+func (t Txn) validate() error {
+	for _, c := range t.Compare {
+		if c.Key == "" || strings.HasPrefix(c.Key, bitcask.SystemPrefix) {
+			return fmt.Errorf("%w: invalid key %q", ErrTxnOp, c.Key)
+		}
+	}
+	for _, ops := range [][]TxnOp{t.Success, t.Failure} {
+		for _, op := range ops {
+			switch op.Op {
+			case "put", "del", "get":
+			default:
+				return fmt.Errorf("%w: %q", ErrTxnOp, op.Op)
+			}
+			if op.Key == "" || strings.HasPrefix(op.Key, bitcask.SystemPrefix) {
+				return fmt.Errorf("%w: invalid key %q", ErrTxnOp, op.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FSM) applyTxn(t Txn, index uint64, ts time.Time) (*TxnResult, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	res := &TxnResult{Succeeded: true, Index: index}
+	for _, c := range t.Compare {
+		// A key already at this entry's index was written by an earlier,
+		// interrupted apply of this same transaction before a restart.
+		v, exists := f.store.Version(c.Key)
+		ok := !exists
+		if c.Version != 0 {
+			ok = exists && v == c.Version
+		}
+		if !ok && v != index {
+			res.Succeeded = false
+			res.Conflicts = append(res.Conflicts, c.Key)
+		}
+	}
+	ops := t.Success
+	if !res.Succeeded {
+		ops = t.Failure
+	}
+
+	var batch []bitcask.BatchOp
+	var writes []TxnOp
+	for _, op := range ops {
+		switch op.Op {
+		case "put":
+			if op.Lease != 0 {
+				f.mu.Lock()
+				_, ok := f.leases[op.Lease]
+				f.mu.Unlock()
+				if !ok {
+					return nil, fmt.Errorf("%w: %d", ErrLeaseNotFound, op.Lease)
+				}
+			}
+			batch = append(batch, bitcask.BatchOp{Key: op.Key, Value: op.Value})
+			writes = append(writes, op)
+		case "del":
+			batch = append(batch, bitcask.BatchOp{Key: op.Key, Delete: true})
+			writes = append(writes, op)
+		case "get":
+			r := TxnResponse{Key: op.Key}
+			val, err := f.store.Get(op.Key)
+			switch {
+			case errors.Is(err, bitcask.ErrKeyNotFound):
+			case err != nil:
+				return nil, err
+			default:
+				r.Value, r.Found = val, true
+				r.Version, _ = f.store.Version(op.Key)
+				r.Flags = f.flags(op.Key)
+			}
+			res.Responses = append(res.Responses, r)
+		}
+	}
+	if err := f.store.WriteBatch(batch, index, ts); err != nil {
+		return nil, err
+	}
+	for _, op := range writes {
+		if err := f.setFlags(op.Key, op.Flags); err != nil {
+			return nil, err
+		}
+		if err := f.keyChanged(op.Key, index); err != nil {
+			return nil, err
+		}
+		if err := f.attachLease(op.Key, op.Lease); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Txn submits t through Raft. A transaction whose compares fail is not an
+// error; check TxnResult.Succeeded.
+func (n *Node) Txn(t Txn) (*TxnResult, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	resp, err := n.ApplyCommand(Command{Op: OpTxn, Transaction: &t})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*TxnResult), nil
+}
+
+// Version returns the Raft index of the last write to key and whether the
+// key exists.
+func (n *Node) Version(key string) (uint64, bool) {
+	return n.Store.Version(key)
+}
@@ -0,0 +1,103 @@
+package raftnode
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+)
+
+const watchBuffer = 256
+
+const (
+	EventPut    = "put"
+	EventDelete = "delete"
+)
+
+// Event describes a committed change to a user key. Version is the Raft
+// index of the entry that made it.
+type Event struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+// keyChanged is called after every write to a user key. It refreshes the
+// secondary indexes, drops the flags of deleted keys and notifies watchers
+// with the key's new value.
+func (f *FSM) keyChanged(key string, index uint64) error {
+	if strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return nil
+	}
+	val, err := f.store.Get(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return err
+	}
+	f.updateIndexes(key, val)
+
+	ev := Event{Type: EventPut, Key: key, Value: val, Version: index}
+	if err != nil {
+		ev.Type, ev.Value = EventDelete, nil
+		if err := f.setFlags(key, 0); err != nil {
+			return err
+		}
+	}
+	f.watchMu.Lock()
+	defer f.watchMu.Unlock()
+	for id, w := range f.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			// The watcher fell too far behind; closing the channel tells
+			// it to re-read and watch again rather than miss events silently.
+			close(w.ch)
+			delete(f.watchers, id)
+		}
+	}
+	return nil
+}
+
+func (f *FSM) watch(prefix string) (<-chan Event, func()) {
+	f.watchMu.Lock()
+	defer f.watchMu.Unlock()
+	f.nextWatch++
+	id := f.nextWatch
+	w := &watcher{prefix: prefix, ch: make(chan Event, watchBuffer)}
+	f.watchers[id] = w
+	cancel := func() {
+		f.watchMu.Lock()
+		defer f.watchMu.Unlock()
+		if _, ok := f.watchers[id]; ok {
+			close(w.ch)
+			delete(f.watchers, id)
+		}
+	}
+	return w.ch, cancel
+}
+
+// resetWatchers closes every watch, telling watchers to re-read, after the
+// store was replaced by a snapshot without events for what changed.
+func (f *FSM) resetWatchers() {
+	f.watchMu.Lock()
+	defer f.watchMu.Unlock()
+	for id, w := range f.watchers {
+		close(w.ch)
+		delete(f.watchers, id)
+	}
+}
+
+// Watch streams changes to keys under prefix as this node applies them.
+// The channel is closed by cancel, or early if the reader falls behind or
+// the node restores a snapshot.
+func (n *Node) Watch(prefix string) (<-chan Event, func()) {
+	return n.fsm.watch(prefix)
+}
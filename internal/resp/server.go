@@ -0,0 +1,690 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/netutil"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// Server answers Redis clients. Reads are served from this node's copy of
+// the store; writes go through the service, so a follower proxies them to
+// the leader and clients need not know which node leads.
+type Server struct {
+	svc  *service.Service
+	node *raftnode.Node
+	log  *slog.Logger
+
+	conns netutil.Conns
+}
+
+func NewServer(svc *service.Service) *Server {
+	return &Server{svc: svc, node: svc.Node(), log: svc.Logger().With("component", "resp")}
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l, s.handle)
+}
+
+// Shutdown stops accepting connections and closes each open one once the
+// command it is running is answered, waiting for them until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := &writer{Writer: bufio.NewWriter(conn), proto: 2}
+	ctx := context.Background()
+	var ok bool
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.err("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		switch name {
+		case "QUIT":
+			w.simple("OK")
+			w.Flush()
+			return
+		case "AUTH":
+			if len(args) != 2 && len(args) != 3 {
+				w.err("ERR wrong number of arguments for 'auth' command")
+			} else if ctx, ok = s.login(ctx, w, args); ok {
+				w.simple("OK")
+			}
+		case "HELLO":
+			if ctx, ok = s.helloAuth(ctx, w, args); ok {
+				s.dispatch(ctx, w, name, args)
+			}
+		default:
+			s.dispatch(ctx, w, name, args)
+		}
+		// Pipelined requests are answered in one write.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				s.log.Debug("failed to write reply", "err", err)
+				return
+			}
+		}
+	}
+}
+
+type command struct {
+	// arity counts the command name; a negative arity is a minimum.
+	arity int
+	fn    func(s *Server, ctx context.Context, w *writer, args [][]byte)
+	// access is what the command needs to its keys, the arguments from
+	// first to last, -1 being the last argument, every step. A command
+	// needing access without keys needs it to the whole keyspace.
+	access            auth.Access
+	first, last, step int
+}
+
+var commands = map[string]command{
+	"PING":    {-1, (*Server).ping, auth.None, 0, 0, 0},
+	"ECHO":    {2, (*Server).echo, auth.None, 0, 0, 0},
+	"SELECT":  {2, (*Server).selectDB, auth.None, 0, 0, 0},
+	"HELLO":   {-1, (*Server).hello, auth.None, 0, 0, 0},
+	"CLIENT":  {-2, (*Server).client, auth.None, 0, 0, 0},
+	"COMMAND": {-1, (*Server).command, auth.None, 0, 0, 0},
+	"INFO":    {-1, (*Server).info, auth.None, 0, 0, 0},
+	"DBSIZE":  {1, (*Server).dbsize, auth.Read, 0, 0, 0},
+	"GET":     {2, (*Server).get, auth.Read, 1, 1, 1},
+	"SET":     {-3, (*Server).set, auth.Write, 1, 1, 1},
+	"DEL":     {-2, (*Server).del, auth.Write, 1, -1, 1},
+	"EXISTS":  {-2, (*Server).exists, auth.Read, 1, -1, 1},
+	"KEYS":    {2, (*Server).keys, auth.Read, 0, 0, 0},
+	"SCAN":    {-2, (*Server).scan, auth.Read, 0, 0, 0},
+	"EXPIRE":  {3, (*Server).expire, auth.Write, 1, 1, 1},
+	"PEXPIRE": {3, (*Server).expire, auth.Write, 1, 1, 1},
+	"TTL":     {2, (*Server).ttl, auth.Read, 1, 1, 1},
+	"PTTL":    {2, (*Server).ttl, auth.Read, 1, 1, 1},
+	"PERSIST": {2, (*Server).persist, auth.Write, 1, 1, 1},
+	"INCR":    {2, (*Server).incr, auth.Write, 1, 1, 1},
+	"DECR":    {2, (*Server).incr, auth.Write, 1, 1, 1},
+	"INCRBY":  {3, (*Server).incr, auth.Write, 1, 1, 1},
+	"DECRBY":  {3, (*Server).incr, auth.Write, 1, 1, 1},
+	"MGET":    {-2, (*Server).mget, auth.Read, 1, -1, 1},
+	"MSET":    {-3, (*Server).mset, auth.Write, 1, -1, 2},
+}
+
+func (s *Server) dispatch(ctx context.Context, w *writer, name string, args [][]byte) {
+	cmd, ok := commands[name]
+	if !ok {
+		w.err(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		w.err(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	// HELLO may be sent before AUTH to negotiate the protocol.
+	if a := s.svc.Auth(); a.Enabled() && name != "HELLO" {
+		if auth.FromContext(ctx) == nil {
+			w.err("NOAUTH Authentication required.")
+			return
+		}
+		if err := a.CheckAll(ctx, cmd.needs(args)); err != nil {
+			w.err("NOPERM " + err.Error())
+			return
+		}
+	}
+	cmd.fn(s, ctx, w, args)
+}
+
+func (c command) needs(args [][]byte) []auth.Need {
+	if c.first == 0 {
+		return []auth.Need{{Namespace: auth.KV, Access: c.access}}
+	}
+	last := c.last
+	if last < 0 {
+		last += len(args)
+	}
+	var needs []auth.Need
+	for i := c.first; i <= last; i += c.step {
+		needs = append(needs, auth.Need{Namespace: auth.KV, Name: string(args[i]), Access: c.access})
+	}
+	return needs
+}
+
+// login authenticates a connection with the token of an AUTH command or
+// HELLO option, which like Redis may be preceded by a user name; the name
+// is ignored, as tokens carry their own.
+func (s *Server) login(ctx context.Context, w *writer, args [][]byte) (context.Context, bool) {
+	a := s.svc.Auth()
+	if !a.Enabled() {
+		w.err("ERR AUTH called without any password configured for the default user. Are you sure your configuration is correct?")
+		return ctx, false
+	}
+	id, err := a.Authenticate(string(args[len(args)-1]))
+	if err != nil {
+		w.err("WRONGPASS invalid username-password pair or user is disabled.")
+		return ctx, false
+	}
+	return auth.WithIdentity(ctx, id), true
+}
+
+func writeError(w *writer, err error) {
+	if errors.Is(err, raftnode.ErrNotInteger) || errors.Is(err, raftnode.ErrOverflow) {
+		w.err("ERR value is not an integer or out of range")
+		return
+	}
+	e := service.AsError(err)
+	switch e.Code {
+	case api.CodeNotLeader, api.CodeUnavailable:
+		w.err("TRYAGAIN " + e.Message)
+	default:
+		w.err("ERR " + e.Message)
+	}
+}
+
+func parseInt(w *writer, b []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		w.err("ERR value is not an integer or out of range")
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Server) role() string {
+	if s.node.Raft.State() == raft.Leader {
+		return "master"
+	}
+	return "slave"
+}
+
+func (s *Server) ping(ctx context.Context, w *writer, args [][]byte) {
+	if len(args) > 1 {
+		w.bulk(args[1])
+		return
+	}
+	w.simple("PONG")
+}
+
+func (s *Server) echo(ctx context.Context, w *writer, args [][]byte) {
+	w.bulk(args[1])
+}
+
+func (s *Server) selectDB(ctx context.Context, w *writer, args [][]byte) {
+	if string(args[1]) != "0" {
+		w.err("ERR DB index is out of range")
+		return
+	}
+	w.simple("OK")
+}
+
+// helloAuth authenticates the connection if HELLO has an AUTH option and
+// auth is enabled.
+func (s *Server) helloAuth(ctx context.Context, w *writer, args [][]byte) (context.Context, bool) {
+	if !s.svc.Auth().Enabled() {
+		return ctx, true
+	}
+	for i := 2; i < len(args); i++ {
+		if strings.EqualFold(string(args[i]), "AUTH") {
+			if i+2 >= len(args) {
+				w.err("ERR syntax error")
+				return ctx, false
+			}
+			return s.login(ctx, w, args[i:i+3])
+		}
+	}
+	return ctx, true
+}
+
+// hello switches the connection's protocol version. Its AUTH option is
+// handled by helloAuth; SETNAME is accepted and ignored.
+func (s *Server) hello(ctx context.Context, w *writer, args [][]byte) {
+	if len(args) > 1 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil || (v != 2 && v != 3) {
+			w.err("NOPROTO unsupported protocol version")
+			return
+		}
+		w.proto = v
+	}
+	w.mapHeader(7)
+	w.bulk([]byte("server"))
+	w.bulk([]byte("hyphora"))
+	w.bulk([]byte("version"))
+	w.bulk([]byte("7.0.0"))
+	w.bulk([]byte("proto"))
+	w.int(int64(w.proto))
+	w.bulk([]byte("id"))
+	w.int(0)
+	w.bulk([]byte("mode"))
+	w.bulk([]byte("standalone"))
+	w.bulk([]byte("role"))
+	w.bulk([]byte(s.role()))
+	w.bulk([]byte("modules"))
+	w.array(0)
+}
+
+func (s *Server) client(ctx context.Context, w *writer, args [][]byte) {
+	switch strings.ToUpper(string(args[1])) {
+	case "GETNAME":
+		w.null()
+	case "ID":
+		w.int(0)
+	default:
+		w.simple("OK")
+	}
+}
+
+func (s *Server) command(ctx context.Context, w *writer, args [][]byte) {
+	w.array(0)
+}
+
+func (s *Server) info(ctx context.Context, w *writer, args [][]byte) {
+	w.bulk([]byte(fmt.Sprintf("# Server\r\nredis_version:7.0.0\r\nredis_mode:standalone\r\n\r\n# Replication\r\nrole:%s\r\n", s.role())))
+}
+
+func (s *Server) dbsize(ctx context.Context, w *writer, args [][]byte) {
+	w.int(int64(len(s.node.Store.Keys())))
+}
+
+func (s *Server) get(ctx context.Context, w *writer, args [][]byte) {
+	kv, err := s.svc.Get(ctx, string(args[1]), 0)
+	if api.IsCode(err, api.CodeNotFound) {
+		w.null()
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.bulk(kv.Value)
+}
+
+// leaseFor grants a lease that expires after d. Leases cannot be shorter
+// than a second, so shorter expiries are rounded up.
+func (s *Server) leaseFor(d time.Duration) (uint64, error) {
+	l, err := s.node.GrantLease(max(d, time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return l.ID, nil
+}
+
+// set supports NX, XX, GET, KEEPTTL and the EX, PX, EXAT and PXAT expiries.
+func (s *Server) set(ctx context.Context, w *writer, args [][]byte) {
+	key, val := string(args[1]), args[2]
+	var nx, xx, get, keepTTL bool
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 == len(args) || ttl != 0 {
+				w.err("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || n <= 0 {
+				w.err("ERR invalid expire time in 'set' command")
+				return
+			}
+			switch opt {
+			case "EX":
+				ttl = time.Duration(n) * time.Second
+			case "PX":
+				ttl = time.Duration(n) * time.Millisecond
+			case "EXAT":
+				ttl = time.Until(time.Unix(n, 0))
+			case "PXAT":
+				ttl = time.Until(time.UnixMilli(n))
+			}
+			ttl = max(ttl, time.Millisecond)
+		default:
+			w.err("ERR syntax error")
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && ttl != 0) {
+		w.err("ERR syntax error")
+		return
+	}
+
+	var lease uint64
+	if ttl != 0 {
+		var err error
+		if lease, err = s.leaseFor(ttl); err != nil {
+			writeError(w, err)
+			return
+		}
+	} else if keepTTL {
+		if l, ok := s.node.KeyLease(key); ok {
+			lease = l.ID
+		}
+	}
+
+	if !nx && !xx && !get {
+		if err := s.svc.Put(ctx, key, val, lease); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.simple("OK")
+		return
+	}
+
+	put := raftnode.TxnOp{Op: "put", Key: key, Value: val, Lease: lease}
+	read := raftnode.TxnOp{Op: "get", Key: key}
+	// XX compares against the version read here; retry if another write
+	// lands in between, since XX only cares that the key exists.
+	for attempt := 0; ; attempt++ {
+		txn := raftnode.Txn{Success: []raftnode.TxnOp{read, put}, Failure: []raftnode.TxnOp{read}}
+		switch {
+		case nx:
+			txn.Compare = []raftnode.Compare{{Key: key}}
+		case xx:
+			v, ok := s.node.Version(key)
+			if !ok {
+				s.replySet(w, nil, false, get)
+				s.dropLease(lease)
+				return
+			}
+			txn.Compare = []raftnode.Compare{{Key: key, Version: v}}
+		}
+		res, err := s.node.Txn(txn)
+		if err != nil {
+			writeError(w, err)
+			s.dropLease(lease)
+			return
+		}
+		if !res.Succeeded && xx && attempt < 3 {
+			continue
+		}
+		if !res.Succeeded {
+			s.dropLease(lease)
+		}
+		s.replySet(w, &res.Responses[0], res.Succeeded, get)
+		return
+	}
+}
+
+func (s *Server) replySet(w *writer, old *raftnode.TxnResponse, written, get bool) {
+	switch {
+	case get && old != nil && old.Found:
+		w.bulk(old.Value)
+	case get:
+		w.null()
+	case written:
+		w.simple("OK")
+	default:
+		w.null()
+	}
+}
+
+// dropLease revokes a lease granted for a write that did not happen.
+func (s *Server) dropLease(id uint64) {
+	if id == 0 {
+		return
+	}
+	if err := s.node.RevokeLease(id); err != nil {
+		s.log.Warn("failed to revoke unused lease", "lease", id, "err", err)
+	}
+}
+
+// del reads and deletes the keys in one transaction, so the count is exact
+// even when sent to a follower.
+func (s *Server) del(ctx context.Context, w *writer, args [][]byte) {
+	var txn raftnode.Txn
+	for _, k := range args[1:] {
+		txn.Success = append(txn.Success, raftnode.TxnOp{Op: "get", Key: string(k)})
+	}
+	for _, k := range args[1:] {
+		txn.Success = append(txn.Success, raftnode.TxnOp{Op: "del", Key: string(k)})
+	}
+	res, err := s.node.Txn(txn)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	n := 0
+	for _, r := range res.Responses {
+		if r.Found {
+			n++
+		}
+	}
+	w.int(int64(n))
+}
+
+func (s *Server) exists(ctx context.Context, w *writer, args [][]byte) {
+	n := 0
+	for _, k := range args[1:] {
+		if _, ok := s.node.Version(string(k)); ok && !strings.HasPrefix(string(k), bitcask.SystemPrefix) {
+			n++
+		}
+	}
+	w.int(int64(n))
+}
+
+func (s *Server) keys(ctx context.Context, w *writer, args [][]byte) {
+	pattern := string(args[1])
+	var keys []string
+	for _, k := range s.node.Store.Keys() {
+		if match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	w.array(len(keys))
+	for _, k := range keys {
+		w.bulk([]byte(k))
+	}
+}
+
+// scan walks the sorted key list; the cursor is the position to resume
+// from. Keys added or removed between calls may shift it, so like Redis it
+// may return a key twice, but it never loses one present throughout.
+func (s *Server) scan(ctx context.Context, w *writer, args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.err("ERR invalid cursor")
+		return
+	}
+	pattern, count, typ := "*", 10, ""
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.err("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || n < 1 {
+				w.err("ERR syntax error")
+				return
+			}
+			count = n
+		case "TYPE":
+			typ = strings.ToLower(string(args[i+1]))
+		default:
+			w.err("ERR syntax error")
+			return
+		}
+	}
+	all := s.node.Store.Keys()
+	start := min(cursor, uint64(len(all)))
+	end := min(start+uint64(count), uint64(len(all)))
+	var keys []string
+	if typ == "" || typ == "string" {
+		for _, k := range all[start:end] {
+			if match(pattern, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	next := end
+	if end == uint64(len(all)) {
+		next = 0
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(next, 10)))
+	w.array(len(keys))
+	for _, k := range keys {
+		w.bulk([]byte(k))
+	}
+}
+
+func (s *Server) expire(ctx context.Context, w *writer, args [][]byte) {
+	n, ok := parseInt(w, args[2])
+	if !ok {
+		return
+	}
+	key := string(args[1])
+	d := time.Duration(n) * time.Second
+	if strings.EqualFold(string(args[0]), "PEXPIRE") {
+		d = time.Duration(n) * time.Millisecond
+	}
+	if d <= 0 {
+		res, err := s.node.Txn(raftnode.Txn{Success: []raftnode.TxnOp{{Op: "get", Key: key}, {Op: "del", Key: key}}})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.int(boolInt(res.Responses[0].Found))
+		return
+	}
+	lease, err := s.leaseFor(d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attached, err := s.svc.AttachLease(ctx, key, lease)
+	if !attached {
+		s.dropLease(lease)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.int(boolInt(attached))
+}
+
+func (s *Server) ttl(ctx context.Context, w *writer, args [][]byte) {
+	key := string(args[1])
+	if _, ok := s.node.Version(key); !ok || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		w.int(-2)
+		return
+	}
+	l, ok := s.node.KeyLease(key)
+	if !ok {
+		w.int(-1)
+		return
+	}
+	left := max(time.Until(l.ExpiresAt), 0)
+	if strings.EqualFold(string(args[0]), "PTTL") {
+		w.int(left.Milliseconds())
+		return
+	}
+	w.int(int64((left + 500*time.Millisecond) / time.Second))
+}
+
+func (s *Server) persist(ctx context.Context, w *writer, args [][]byte) {
+	key := string(args[1])
+	if _, ok := s.node.KeyLease(key); !ok {
+		w.int(0)
+		return
+	}
+	attached, err := s.svc.AttachLease(ctx, key, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.int(boolInt(attached))
+}
+
+func (s *Server) incr(ctx context.Context, w *writer, args [][]byte) {
+	delta := int64(1)
+	name := strings.ToUpper(string(args[0]))
+	if len(args) == 3 {
+		var ok bool
+		if delta, ok = parseInt(w, args[2]); !ok {
+			return
+		}
+	}
+	if strings.HasPrefix(name, "DECR") {
+		delta = -delta
+	}
+	n, err := s.svc.Add(ctx, string(args[1]), delta)
+	if api.IsCode(err, api.CodeConflict) {
+		// ErrNotInteger or ErrOverflow, on this node or the leader.
+		w.err("ERR value is not an integer or out of range")
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.int(n)
+}
+
+func (s *Server) mget(ctx context.Context, w *writer, args [][]byte) {
+	w.array(len(args) - 1)
+	for _, k := range args[1:] {
+		kv, err := s.svc.Get(ctx, string(k), 0)
+		if err != nil {
+			w.null()
+			continue
+		}
+		w.bulk(kv.Value)
+	}
+}
+
+// mset writes every pair in one transaction, so it is atomic like in Redis.
+func (s *Server) mset(ctx context.Context, w *writer, args [][]byte) {
+	if len(args)%2 != 1 {
+		w.err("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	var txn raftnode.Txn
+	for i := 1; i < len(args); i += 2 {
+		txn.Success = append(txn.Success, raftnode.TxnOp{Op: "put", Key: string(args[i]), Value: args[i+1]})
+	}
+	if _, err := s.node.Txn(txn); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
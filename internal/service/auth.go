@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tokenPrefix marks the secrets of tokens created through the API.
+const tokenPrefix = "hyp_"
+
+// SetAuth makes the service authorize requests with a and, when it calls
+// another node without a caller to act for, authenticate with nodeToken.
+// Call it before serving.
+func (s *Service) SetAuth(a *auth.Authorizer, nodeToken string) {
+	s.auth, s.nodeToken = a, nodeToken
+}
+
+// Auth returns the authorizer requests are checked against.
+func (s *Service) Auth() *auth.Authorizer {
+	return s.auth
+}
+
+// authorize checks the needs of a request made by the caller in ctx.
+func (s *Service) authorize(ctx context.Context, needs ...auth.Need) error {
+	return wrap(s.auth.CheckAll(ctx, needs))
+}
+
+// withToken passes on the token of the caller in ctx, or the node's own,
+// when calling another node.
+func (s *Service) withToken(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	token := s.nodeToken
+	if id := auth.FromContext(ctx); id != nil && id.Secret() != "" {
+		token = id.Secret()
+	}
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Roles lists the built-in roles followed by the replicated ones.
+func (s *Service) Roles(ctx context.Context) []api.Role {
+	var roles []api.Role
+	names := make([]string, 0, len(auth.Builtin))
+	for name := range auth.Builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := toAPIRole(auth.Builtin[name])
+		r.Builtin = true
+		roles = append(roles, r)
+	}
+	for _, r := range s.node.Roles() {
+		roles = append(roles, toAPIRole(r))
+	}
+	return roles
+}
+
+func (s *Service) PutRole(ctx context.Context, role api.Role) error {
+	r := raftnode.Role{Name: role.Name}
+	for _, rule := range role.Rules {
+		r.Rules = append(r.Rules, raftnode.Rule{Namespace: rule.Namespace, Prefix: rule.Prefix, Access: rule.Access})
+	}
+	if err := auth.ValidateRole(r); err != nil {
+		return wrap(err)
+	}
+	return wrap(s.node.PutRole(r))
+}
+
+func (s *Service) DeleteRole(ctx context.Context, name string) error {
+	if _, ok := auth.Builtin[name]; ok {
+		return api.Errorf(api.CodeInvalid, fmt.Sprintf("role %s is built in", name))
+	}
+	return wrap(s.node.DeleteRole(name))
+}
+
+func (s *Service) Tokens(ctx context.Context) []api.Token {
+	tokens := []api.Token{}
+	for _, t := range s.node.Tokens() {
+		tokens = append(tokens, toAPIToken(t))
+	}
+	return tokens
+}
+
+// CreateToken creates a token with roles and returns it with its secret.
+// Only the hash of the secret is stored, so it cannot be read again.
+func (s *Service) CreateToken(ctx context.Context, name string, roles []string) (*api.CreateTokenResponse, error) {
+	if name == "" {
+		return nil, api.Errorf(api.CodeInvalid, "a token needs a name")
+	}
+	for _, r := range roles {
+		if !s.auth.RoleExists(r) {
+			return nil, api.Errorf(api.CodeInvalid, fmt.Sprintf("unknown role %q", r))
+		}
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, wrap(err)
+	}
+	secret := tokenPrefix + hex.EncodeToString(buf)
+	hash := auth.HashToken(secret)
+	t := raftnode.Token{ID: raftnode.TokenID(hash), Name: name, Roles: slices.Clone(roles), Created: time.Now().UTC()}
+	if err := s.node.PutToken(hash, t); err != nil {
+		return nil, wrap(err)
+	}
+	return &api.CreateTokenResponse{Token: toAPIToken(t), Secret: secret}, nil
+}
+
+func (s *Service) DeleteToken(ctx context.Context, id string) error {
+	return wrap(s.node.DeleteToken(id))
+}
+
+// WhoAmI describes the caller in ctx.
+func (s *Service) WhoAmI(ctx context.Context) *api.WhoAmIResponse {
+	resp := &api.WhoAmIResponse{AuthEnabled: s.auth.Enabled()}
+	if id := auth.FromContext(ctx); id != nil {
+		resp.Name, resp.Roles = id.Name, id.Roles
+	}
+	return resp
+}
+
+func toAPIRole(r raftnode.Role) api.Role {
+	out := api.Role{Name: r.Name, Rules: []api.Rule{}}
+	for _, rule := range r.Rules {
+		out.Rules = append(out.Rules, api.Rule{Namespace: rule.Namespace, Prefix: rule.Prefix, Access: rule.Access})
+	}
+	return out
+}
+
+func toAPIToken(t raftnode.Token) api.Token {
+	return api.Token{ID: t.ID, Name: t.Name, Roles: t.Roles, Created: t.Created}
+}
+
+// UnaryInterceptor authenticates the caller of a gRPC call from its
+// "authorization" metadata and checks what the request needs. Forwarded
+// commands are exempt under TLS, where Forward accepts only peers.
+func (s *Service) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := req.(*api.ForwardRequest); !ok || s.tls == nil {
+		if err := s.authorize(ctx, grpcNeeds(req)...); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor is UnaryInterceptor for streams; it checks every
+// message the client sends.
+func (s *Service) StreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: ctx, s: s})
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	s   *Service
+}
+
+func (a *authStream) Context() context.Context {
+	return a.ctx
+}
+
+func (a *authStream) RecvMsg(m any) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return a.s.authorize(a.ctx, grpcNeeds(m)...)
+}
+
+func (s *Service) authenticate(ctx context.Context) (context.Context, error) {
+	if !s.auth.Enabled() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if v := md.Get("authorization"); len(v) > 0 {
+		header = v[0]
+	}
+	id, err := s.auth.Authenticate(auth.BearerToken(header))
+	if err != nil {
+		return nil, wrap(err)
+	}
+	return auth.WithIdentity(ctx, id), nil
+}
+
+// grpcNeeds returns what a gRPC request needs; anything not listed needs
+// admin access to the cluster.
+func grpcNeeds(req any) []auth.Need {
+	switch req := req.(type) {
+	case *api.GetRequest:
+		return []auth.Need{{Namespace: auth.KV, Name: req.Key, Access: auth.Read}}
+	case *api.ScanRequest:
+		return []auth.Need{{Namespace: auth.KV, Name: req.Prefix, Access: auth.Read}}
+	case *api.WatchRequest:
+		return []auth.Need{{Namespace: auth.KV, Name: req.Prefix, Access: auth.Read}}
+	case *api.PutRequest:
+		return []auth.Need{{Namespace: auth.KV, Name: req.Key, Access: auth.Write}}
+	case *api.DeleteRequest:
+		return []auth.Need{{Namespace: auth.KV, Name: req.Key, Access: auth.Write}}
+	case *api.MembersRequest, *api.NodeInfoRequest, *api.WhoAmIRequest:
+		return []auth.Need{{Namespace: auth.Cluster, Access: auth.None}}
+	case *api.StatusRequest:
+		return []auth.Need{{Namespace: auth.Cluster, Access: auth.Read}}
+	}
+	return []auth.Need{{Namespace: auth.Cluster, Access: auth.Admin}}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// Members lists the Raft configuration. On the leader each member also
+// carries whether the leader is in contact with it and since when.
+func (s *Service) Members(ctx context.Context) ([]api.Member, error) {
+	cfg, err := s.configuration()
+	if err != nil {
+		return nil, err
+	}
+	isLeader := s.node.Raft.State() == raft.Leader
+	_, leaderID := s.node.Raft.LeaderWithID()
+	var members []api.Member
+	for _, srv := range cfg.Servers {
+		m := api.Member{
+			ID:          string(srv.ID),
+			RaftAddress: string(srv.Address),
+			Suffrage:    strings.ToLower(srv.Suffrage.String()),
+			Leader:      srv.ID == leaderID,
+		}
+		if meta, ok := s.node.Member(string(srv.ID)); ok {
+			m.HTTPAddress, m.GRPCAddress = meta.HTTPAddr, meta.GRPCAddr
+		}
+		switch {
+		case isLeader && m.Leader:
+			m.Status = "leader"
+		case isLeader:
+			reachable, last := s.node.PeerContact(m.ID)
+			m.Status, m.LastContact = "reachable", last
+			if !reachable {
+				m.Status = "unreachable"
+			}
+		case m.Leader:
+			m.LastContact = s.node.Raft.LastContact()
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// NodeInfo describes this node, so a node forming a cluster can find out
+// who its seeds are.
+func (s *Service) NodeInfo(ctx context.Context) *api.NodeInfoResponse {
+	meta := s.node.Meta()
+	return &api.NodeInfoResponse{
+		ID:          meta.ID,
+		RaftAddress: meta.RaftAddr,
+		HTTPAddress: meta.HTTPAddr,
+		GRPCAddress: meta.GRPCAddr,
+		InCluster:   s.node.InCluster(),
+	}
+}
+
+// AddPeer adds a voter, or a non-voter that replicates the log without
+// counting towards quorum. Adding an existing non-voter as a voter
+// promotes it.
+func (s *Service) AddPeer(ctx context.Context, id, addr string, nonVoter bool) error {
+	if id == "" || addr == "" {
+		return api.Errorf(api.CodeInvalid, "id and addr required")
+	}
+	c, err := s.leader()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		_, err := c.AddPeer(ctx, &api.AddPeerRequest{ID: id, Address: addr, NonVoter: nonVoter})
+		return err
+	}
+	var fut raft.IndexFuture
+	if nonVoter {
+		if srv, ok := s.server(id); ok && srv.Suffrage == raft.Voter {
+			return api.Errorf(api.CodeInvalid, fmt.Sprintf("member %q is a voter; demote it instead", id))
+		}
+		fut = s.node.Raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	} else {
+		fut = s.node.Raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	}
+	if err := fut.Error(); err != nil {
+		return wrap(fmt.Errorf("failed to add peer: %w", err))
+	}
+	return nil
+}
+
+// RemovePeer removes a member. Unless forced it refuses when the voters
+// left would not include a reachable majority.
+func (s *Service) RemovePeer(ctx context.Context, id string, force bool) error {
+	if id == "" {
+		return api.Errorf(api.CodeInvalid, "id required")
+	}
+	c, err := s.leader()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		_, err := c.RemovePeer(ctx, &api.RemovePeerRequest{ID: id, Force: force})
+		return err
+	}
+	if _, ok := s.server(id); !ok {
+		return api.Errorf(api.CodeNotFound, fmt.Sprintf("no member %q", id))
+	}
+	if !force {
+		if err := s.checkQuorum("removing", id); err != nil {
+			return err
+		}
+	}
+	fut := s.node.Raft.RemoveServer(raft.ServerID(id), 0, 0)
+	if err := fut.Error(); err != nil {
+		return wrap(fmt.Errorf("failed to remove peer: %w", err))
+	}
+	return nil
+}
+
+// DemotePeer turns a voter into a non-voter, with the same quorum check as
+// RemovePeer.
+func (s *Service) DemotePeer(ctx context.Context, id string, force bool) error {
+	if id == "" {
+		return api.Errorf(api.CodeInvalid, "id required")
+	}
+	c, err := s.leader()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		_, err := c.DemotePeer(ctx, &api.DemotePeerRequest{ID: id, Force: force})
+		return err
+	}
+	srv, ok := s.server(id)
+	if !ok {
+		return api.Errorf(api.CodeNotFound, fmt.Sprintf("no member %q", id))
+	}
+	if srv.Suffrage != raft.Voter {
+		return api.Errorf(api.CodeInvalid, fmt.Sprintf("member %q is not a voter", id))
+	}
+	if !force {
+		if err := s.checkQuorum("demoting", id); err != nil {
+			return err
+		}
+	}
+	fut := s.node.Raft.DemoteVoter(raft.ServerID(id), 0, 0)
+	if err := fut.Error(); err != nil {
+		return wrap(fmt.Errorf("failed to demote peer: %w", err))
+	}
+	return nil
+}
+
+// TransferLeader makes the leader step down in favour of the voter id, or
+// of the most up-to-date voter when id is empty. Unless forced it refuses
+// to hand over to a voter the leader cannot reach.
+func (s *Service) TransferLeader(ctx context.Context, id string, force bool) error {
+	c, err := s.leader()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		_, err := c.TransferLeader(ctx, &api.TransferLeaderRequest{ID: id, Force: force})
+		return err
+	}
+	var fut raft.Future
+	if id == "" {
+		fut = s.node.Raft.LeadershipTransfer()
+	} else {
+		srv, ok := s.server(id)
+		if !ok {
+			return api.Errorf(api.CodeNotFound, fmt.Sprintf("no member %q", id))
+		}
+		if _, self := s.node.Raft.LeaderWithID(); srv.ID == self {
+			return api.Errorf(api.CodeInvalid, fmt.Sprintf("member %q is already the leader", id))
+		}
+		if srv.Suffrage != raft.Voter {
+			return api.Errorf(api.CodeInvalid, fmt.Sprintf("member %q is not a voter", id))
+		}
+		if reachable, _ := s.node.PeerContact(id); !reachable && !force {
+			return api.Errorf(api.CodeConflict, fmt.Sprintf("member %q is unreachable; use force to transfer anyway", id))
+		}
+		fut = s.node.Raft.LeadershipTransferToServer(srv.ID, srv.Address)
+	}
+	if err := fut.Error(); err != nil {
+		return wrap(fmt.Errorf("leadership transfer failed: %w", err))
+	}
+	return nil
+}
+
+// checkQuorum refuses to take the voter id out of the configuration when
+// the voters left would not include a majority the leader can reach, so
+// the cluster could no longer commit anything.
+func (s *Service) checkQuorum(action, id string) error {
+	cfg, err := s.configuration()
+	if err != nil {
+		return err
+	}
+	voters, reachable := 0, 0
+	for _, srv := range cfg.Servers {
+		if srv.Suffrage != raft.Voter || srv.ID == raft.ServerID(id) {
+			continue
+		}
+		voters++
+		if ok, _ := s.node.PeerContact(string(srv.ID)); ok {
+			reachable++
+		}
+	}
+	if voters == 0 {
+		return api.Errorf(api.CodeConflict, fmt.Sprintf("%s %q would leave no voters", action, id))
+	}
+	if quorum := voters/2 + 1; reachable < quorum {
+		return api.Errorf(api.CodeConflict, fmt.Sprintf(
+			"%s %q would leave %d of %d voters reachable, short of a quorum of %d; use force to override",
+			action, id, reachable, voters, quorum))
+	}
+	return nil
+}
+
+func (s *Service) configuration() (raft.Configuration, error) {
+	fut := s.node.Raft.GetConfiguration()
+	if err := fut.Error(); err != nil {
+		return raft.Configuration{}, wrap(err)
+	}
+	return fut.Configuration(), nil
+}
+
+// server looks id up in the current Raft configuration.
+func (s *Service) server(id string) (raft.Server, bool) {
+	cfg, err := s.configuration()
+	if err != nil {
+		return raft.Server{}, false
+	}
+	for _, srv := range cfg.Servers {
+		if srv.ID == raft.ServerID(id) {
+			return srv, true
+		}
+	}
+	return raft.Server{}, false
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type grpcServer struct {
+	s *Service
+}
+
+// RegisterGRPC registers every service of the node on gs.
+func (s *Service) RegisterGRPC(gs *grpc.Server) {
+	srv := &grpcServer{s: s}
+	api.RegisterKVServer(gs, srv)
+	api.RegisterClusterServer(gs, srv)
+	api.RegisterAdminServer(gs, srv)
+	api.RegisterAuthServer(gs, srv)
+	api.RegisterInternalServer(gs, srv)
+}
+
+func (g *grpcServer) Get(ctx context.Context, req *api.GetRequest) (*api.GetResponse, error) {
+	kv, err := g.s.Get(ctx, req.Key, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetResponse{KeyValue: *kv}, nil
+}
+
+func (g *grpcServer) Put(ctx context.Context, req *api.PutRequest) (*api.PutResponse, error) {
+	if err := g.s.Put(ctx, req.Key, req.Value, req.Lease); err != nil {
+		return nil, err
+	}
+	return &api.PutResponse{}, nil
+}
+
+func (g *grpcServer) Delete(ctx context.Context, req *api.DeleteRequest) (*api.DeleteResponse, error) {
+	if err := g.s.Delete(ctx, req.Key); err != nil {
+		return nil, err
+	}
+	return &api.DeleteResponse{}, nil
+}
+
+func (g *grpcServer) Scan(ctx context.Context, req *api.ScanRequest) (*api.ScanResponse, error) {
+	items, more, err := g.s.Scan(ctx, req.Prefix, req.After, req.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ScanResponse{Items: items, More: more}, nil
+}
+
+func (g *grpcServer) Watch(req *api.WatchRequest, stream api.KVWatchServer) error {
+	ctx := stream.Context()
+	events, cancel := g.s.Watch(ctx, req.Prefix)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return api.Errorf(api.CodeUnavailable, "watch fell behind; read again and re-watch")
+			}
+			out := &api.WatchEvent{Type: ev.Type, Key: ev.Key, Value: ev.Value, Version: ev.Version}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (g *grpcServer) Members(ctx context.Context, req *api.MembersRequest) (*api.MembersResponse, error) {
+	members, err := g.s.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &api.MembersResponse{Members: members}, nil
+}
+
+func (g *grpcServer) NodeInfo(ctx context.Context, req *api.NodeInfoRequest) (*api.NodeInfoResponse, error) {
+	return g.s.NodeInfo(ctx), nil
+}
+
+func (g *grpcServer) Status(ctx context.Context, req *api.StatusRequest) (*api.StatusResponse, error) {
+	return g.s.Status(ctx), nil
+}
+
+func (g *grpcServer) AddPeer(ctx context.Context, req *api.AddPeerRequest) (*api.AddPeerResponse, error) {
+	if err := g.s.AddPeer(ctx, req.ID, req.Address, req.NonVoter); err != nil {
+		return nil, err
+	}
+	return &api.AddPeerResponse{}, nil
+}
+
+func (g *grpcServer) RemovePeer(ctx context.Context, req *api.RemovePeerRequest) (*api.RemovePeerResponse, error) {
+	if err := g.s.RemovePeer(ctx, req.ID, req.Force); err != nil {
+		return nil, err
+	}
+	return &api.RemovePeerResponse{}, nil
+}
+
+func (g *grpcServer) DemotePeer(ctx context.Context, req *api.DemotePeerRequest) (*api.DemotePeerResponse, error) {
+	if err := g.s.DemotePeer(ctx, req.ID, req.Force); err != nil {
+		return nil, err
+	}
+	return &api.DemotePeerResponse{}, nil
+}
+
+func (g *grpcServer) TransferLeader(ctx context.Context, req *api.TransferLeaderRequest) (*api.TransferLeaderResponse, error) {
+	if err := g.s.TransferLeader(ctx, req.ID, req.Force); err != nil {
+		return nil, err
+	}
+	return &api.TransferLeaderResponse{}, nil
+}
+
+func (g *grpcServer) Compact(ctx context.Context, req *api.CompactRequest) (*api.CompactResponse, error) {
+	if err := g.s.Compact(ctx); err != nil {
+		return nil, err
+	}
+	return &api.CompactResponse{}, nil
+}
+
+func (g *grpcServer) Snapshot(ctx context.Context, req *api.SnapshotRequest) (*api.SnapshotResponse, error) {
+	return g.s.Snapshot(ctx, req.Truncate)
+}
+
+func (g *grpcServer) Snapshots(ctx context.Context, req *api.SnapshotsRequest) (*api.SnapshotsResponse, error) {
+	return g.s.Snapshots(ctx)
+}
+
+func (g *grpcServer) Roles(ctx context.Context, req *api.RolesRequest) (*api.RolesResponse, error) {
+	return &api.RolesResponse{Roles: g.s.Roles(ctx)}, nil
+}
+
+func (g *grpcServer) PutRole(ctx context.Context, req *api.PutRoleRequest) (*api.PutRoleResponse, error) {
+	if err := g.s.PutRole(ctx, req.Role); err != nil {
+		return nil, err
+	}
+	return &api.PutRoleResponse{}, nil
+}
+
+func (g *grpcServer) DeleteRole(ctx context.Context, req *api.DeleteRoleRequest) (*api.DeleteRoleResponse, error) {
+	if err := g.s.DeleteRole(ctx, req.Name); err != nil {
+		return nil, err
+	}
+	return &api.DeleteRoleResponse{}, nil
+}
+
+func (g *grpcServer) Tokens(ctx context.Context, req *api.TokensRequest) (*api.TokensResponse, error) {
+	return &api.TokensResponse{Tokens: g.s.Tokens(ctx)}, nil
+}
+
+func (g *grpcServer) CreateToken(ctx context.Context, req *api.CreateTokenRequest) (*api.CreateTokenResponse, error) {
+	return g.s.CreateToken(ctx, req.Name, req.Roles)
+}
+
+func (g *grpcServer) DeleteToken(ctx context.Context, req *api.DeleteTokenRequest) (*api.DeleteTokenResponse, error) {
+	if err := g.s.DeleteToken(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	return &api.DeleteTokenResponse{}, nil
+}
+
+func (g *grpcServer) WhoAmI(ctx context.Context, req *api.WhoAmIRequest) (*api.WhoAmIResponse, error) {
+	return g.s.WhoAmI(ctx), nil
+}
+
+func (g *grpcServer) Forward(ctx context.Context, req *api.ForwardRequest) (*api.ForwardResponse, error) {
+	if !g.s.fromPeer(ctx) {
+		return nil, api.Errorf(api.CodePermissionDenied, "only cluster nodes may forward commands")
+	}
+	out, err := g.s.Forward(ctx, req.Command)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ForwardResponse{Result: out}, nil
+}
+
+// fromPeer reports whether the call was made by another node: with TLS,
+// one that presented a peer certificate; without, anyone.
+func (s *Service) fromPeer(ctx context.Context) bool {
+	if s.tls == nil {
+		return true
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return false
+	}
+	return s.tls.IsPeer(info.State.PeerCertificates[0])
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"github.com/hashicorp/raft"
+)
+
+// DefaultMaxApplyLag is how many committed entries a ready node may have
+// left to apply, by default.
+const DefaultMaxApplyLag = 1000
+
+// Readiness sets when Ready reports the node ready to serve. The store
+// must be open and Raft running in any case.
+type Readiness struct {
+	// RequireLeader requires the node to know a leader, which writes are
+	// forwarded to.
+	RequireLeader bool
+	// MaxApplyLag bounds how far the applied index may trail the commit
+	// index, so reads are not answered from far behind.
+	MaxApplyLag uint64
+}
+
+// SetReadiness replaces the readiness criteria.
+func (s *Service) SetReadiness(r Readiness) {
+	s.readiness.Store(&r)
+}
+
+// Drain makes Ready report the node unavailable from now on, so load
+// balancers stop sending it requests while it shuts down.
+func (s *Service) Drain() {
+	s.draining.Store(true)
+}
+
+// Ready returns nil if the node is ready to serve, and an unavailable
+// error saying why not otherwise.
+func (s *Service) Ready(ctx context.Context) error {
+	r, readiness := s.node.Raft, s.readiness.Load()
+	switch {
+	case s.draining.Load():
+		return api.Errorf(api.CodeUnavailable, "node is shutting down")
+	case s.node.Store.Closed():
+		return api.Errorf(api.CodeUnavailable, "store is closed")
+	case r.State() == raft.Shutdown:
+		return api.Errorf(api.CodeUnavailable, "raft is shut down")
+	}
+	if readiness.RequireLeader {
+		if _, id := r.LeaderWithID(); id == "" {
+			return api.Errorf(api.CodeUnavailable, "no known leader")
+		}
+	}
+	commit, applied := r.CommitIndex(), r.AppliedIndex()
+	if commit > applied && commit-applied > readiness.MaxApplyLag {
+		return api.Errorf(api.CodeUnavailable, fmt.Sprintf("applied index %d trails commit index %d by more than %d", applied, commit, readiness.MaxApplyLag))
+	}
+	return nil
+}
+
+// Status describes this node: its Raft state, what it knows of the
+// cluster and its store.
+func (s *Service) Status(ctx context.Context) *api.StatusResponse {
+	r := s.node.Raft
+	out := &api.StatusResponse{
+		ID:    s.node.Meta().ID,
+		State: r.State().String(),
+		Raft:  r.Stats(),
+		Ready: true,
+	}
+	if err := s.Ready(ctx); err != nil {
+		out.Ready, out.NotReady = false, AsError(err).Message
+	}
+	addr, id := r.LeaderWithID()
+	out.LeaderID, out.LeaderAddress = string(id), string(addr)
+	if meta, ok := s.node.Member(string(id)); ok {
+		out.LeaderHTTPAddress = meta.HTTPAddr
+	}
+	out.Members, _ = s.Members(ctx)
+
+	st := s.node.Store.Stats()
+	out.Store = api.StoreStats{Keys: st.Keys, DataFiles: st.DataFiles, DataBytes: st.DataBytes, LiveBytes: st.LiveBytes, DeadBytes: st.DeadBytes}
+	if snaps, err := s.node.Snapshots(); err == nil && len(snaps) > 0 {
+		snap := snapshotOf(snaps[0])
+		out.LastSnapshot = &snap
+	}
+	if c, ok := s.node.Store.LastCompaction(); ok {
+		out.LastCompaction = &api.Compaction{Time: c.Time, DurationMS: c.Duration.Milliseconds(), ReclaimedBytes: c.Reclaimed}
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+// Indexes lists the secondary index definitions.
+func (s *Service) Indexes(ctx context.Context) []raftnode.IndexDef {
+	return s.node.Indexes()
+}
+
+// DefineIndex creates or replaces an index over user keys under def.Prefix.
+func (s *Service) DefineIndex(ctx context.Context, def raftnode.IndexDef) error {
+	if err := checkName("index name", def.Name); err != nil {
+		return err
+	}
+	if err := checkName("prefix", def.Prefix); err != nil {
+		return err
+	}
+	return wrap(s.node.DefineIndex(def))
+}
+
+func (s *Service) DropIndex(ctx context.Context, name string) error {
+	if err := checkName("index name", name); err != nil {
+		return err
+	}
+	return wrap(s.node.DropIndex(name))
+}
+
+// QueryIndex lists the keys whose indexed value lies in [from, to]; a nil
+// bound is open.
+func (s *Service) QueryIndex(ctx context.Context, name string, from, to *string) ([]string, error) {
+	if err := checkName("index name", name); err != nil {
+		return nil, err
+	}
+	keys, err := s.node.QueryIndex(name, from, to)
+	return keys, wrap(err)
+}
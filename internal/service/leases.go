@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+func (s *Service) GrantLease(ctx context.Context, ttl time.Duration) (*raftnode.Lease, error) {
+	lease, err := s.node.GrantLease(ttl)
+	return lease, wrap(err)
+}
+
+func (s *Service) KeepAliveLease(ctx context.Context, id uint64) (*raftnode.Lease, error) {
+	lease, err := s.node.KeepAliveLease(id)
+	return lease, wrap(err)
+}
+
+func (s *Service) RevokeLease(ctx context.Context, id uint64) error {
+	return wrap(s.node.RevokeLease(id))
+}
+
+// Leases lists the leases known to this node.
+func (s *Service) Leases(ctx context.Context) []raftnode.Lease {
+	return s.node.Leases()
+}
+
+// AcquireLock takes the named lock for ttl on behalf of owner.
+func (s *Service) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (*raftnode.Lock, error) {
+	if err := checkName("lock name", name); err != nil {
+		return nil, err
+	}
+	lock, err := s.node.AcquireLock(name, owner, ttl)
+	return lock, wrap(err)
+}
+
+// ReleaseLock releases the named lock if token still holds it.
+func (s *Service) ReleaseLock(ctx context.Context, name string, token uint64) error {
+	if err := checkName("lock name", name); err != nil {
+		return err
+	}
+	return wrap(s.node.ReleaseLock(name, token))
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader carries a request ID from clients, and between nodes
+// when one passes a call on to the leader, so the lines logged for it on
+// both nodes share it.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestID bounds the length of a request ID taken from a client.
+const maxRequestID = 128
+
+// Logger returns the logger of the node.
+func (s *Service) Logger() *slog.Logger {
+	return s.node.Logger()
+}
+
+// RequestID returns the request ID a client sent, if it is usable, or a
+// new one.
+func RequestID(sent string) string {
+	if sent == "" || len(sent) > maxRequestID {
+		return logging.NewRequestID()
+	}
+	return sent
+}
+
+// UnaryLogger gives every call a request ID, the one its caller sent in
+// its metadata or a new one, and logs the call at debug level.
+func (s *Service) UnaryLogger(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = withIncomingRequestID(ctx)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.Logger().DebugContext(ctx, "grpc request", "method", info.FullMethod, "code", resultLabel(err).Value, "duration", time.Since(start))
+	return resp, err
+}
+
+// StreamLogger is UnaryLogger for streams.
+func (s *Service) StreamLogger(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := withIncomingRequestID(ss.Context())
+	start := time.Now()
+	err := handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+	s.Logger().DebugContext(ctx, "grpc stream", "method", info.FullMethod, "code", resultLabel(err).Value, "duration", time.Since(start))
+	return err
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (l *loggedStream) Context() context.Context {
+	return l.ctx
+}
+
+func withIncomingRequestID(ctx context.Context) context.Context {
+	var sent string
+	if vals := metadata.ValueFromIncomingContext(ctx, RequestIDHeader); len(vals) > 0 {
+		sent = vals[0]
+	}
+	return logging.WithRequestID(ctx, RequestID(sent))
+}
+
+// withRequestID passes the request ID of ctx on when calling another node.
+func withRequestID(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := logging.RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"google.golang.org/grpc"
+)
+
+// UnaryMetrics records the count and latency of gRPC calls by method, and
+// counts them by result code too. It goes before the auth interceptors, so
+// rejected calls are counted.
+func UnaryMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	labels := []metrics.Label{{Name: "method", Value: info.FullMethod}}
+	metrics.MeasureSinceWithLabels([]string{"grpc", "latency"}, start, labels)
+	metrics.IncrCounterWithLabels([]string{"grpc", "requests"}, 1, append(labels, resultLabel(err)))
+	return resp, err
+}
+
+// StreamMetrics counts gRPC streams by method and result code when they
+// end. Streams such as watches last as long as the client wants, so their
+// duration is not recorded.
+func StreamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	labels := []metrics.Label{{Name: "method", Value: info.FullMethod}, resultLabel(err)}
+	metrics.IncrCounterWithLabels([]string{"grpc", "requests"}, 1, labels)
+	return err
+}
+
+func resultLabel(err error) metrics.Label {
+	if err == nil {
+		return metrics.Label{Name: "code", Value: "ok"}
+	}
+	return metrics.Label{Name: "code", Value: AsError(err).Code.String()}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+)
+
+// Enqueue appends val to the named queue and returns its sequence number.
+func (s *Service) Enqueue(ctx context.Context, name string, val []byte) (uint64, error) {
+	if err := checkName("queue name", name); err != nil {
+		return 0, err
+	}
+	seq, err := s.node.Enqueue(name, val)
+	return seq, wrap(err)
+}
+
+// Dequeue delivers the queue's oldest item, which is delivered again after
+// visibility unless it is acked.
+func (s *Service) Dequeue(ctx context.Context, name string, visibility time.Duration) (*raftnode.QueueItem, error) {
+	if err := checkName("queue name", name); err != nil {
+		return nil, err
+	}
+	item, err := s.node.Dequeue(name, visibility)
+	return item, wrap(err)
+}
+
+func (s *Service) AckQueue(ctx context.Context, name string, receipt uint64) error {
+	if err := checkName("queue name", name); err != nil {
+		return err
+	}
+	return wrap(s.node.AckQueue(name, receipt))
+}
+
+func (s *Service) PeekQueue(ctx context.Context, name string) (*raftnode.QueueItem, error) {
+	if err := checkName("queue name", name); err != nil {
+		return nil, err
+	}
+	item, err := s.node.PeekQueue(name)
+	return item, wrap(err)
+}
+
+func (s *Service) QueueLen(ctx context.Context, name string) (*raftnode.QueueStats, error) {
+	if err := checkName("queue name", name); err != nil {
+		return nil, err
+	}
+	stats, err := s.node.QueueLen(name)
+	return stats, wrap(err)
+}
@@ -0,0 +1,390 @@
+// Package service implements the operations exposed by a node, shared by
+// the HTTP handlers and the gRPC server so both forward to the leader and
+// report errors the same way.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/auth"
+	"github.com/AMS003010/Hyphora/internal/backup"
+	"github.com/AMS003010/Hyphora/internal/bitcask"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/tlsutil"
+	"github.com/AMS003010/Hyphora/pkg/api"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	forwardTimeout   = 10 * time.Second
+	defaultScanLimit = 1000
+	maxScanLimit     = 10000
+)
+
+type Service struct {
+	node      *raftnode.Node
+	tls       *tlsutil.Store
+	auth      *auth.Authorizer
+	nodeToken string
+	readiness atomic.Pointer[Readiness]
+	draining  atomic.Bool
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// New wraps node and installs a forwarder that sends writes made on a
+// follower to the leader's gRPC listener.
+func New(node *raftnode.Node) *Service {
+	s := &Service{
+		node:  node,
+		auth:  auth.New(node, false, nil),
+		conns: make(map[string]*grpc.ClientConn),
+	}
+	s.SetReadiness(Readiness{RequireLeader: true, MaxApplyLag: DefaultMaxApplyLag})
+	node.SetForwarder(s.forward)
+	return s
+}
+
+// Close closes the connections to other nodes.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for addr, cc := range s.conns {
+		errs = append(errs, cc.Close())
+		delete(s.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) Node() *raftnode.Node {
+	return s.node
+}
+
+// SetTLS makes the service dial other nodes over mutual TLS and accept
+// forwarded commands only from peers. Call it before serving.
+func (s *Service) SetTLS(store *tlsutil.Store) {
+	s.tls = store
+}
+
+// AsError classifies err for the APIs. Errors that are already an
+// *api.Error are returned unchanged.
+func AsError(err error) *api.Error {
+	var e *api.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	code := api.CodeInternal
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound),
+		errors.Is(err, bitcask.ErrVersionNotFound),
+		errors.Is(err, raftnode.ErrLeaseNotFound),
+		errors.Is(err, raftnode.ErrQueueEmpty),
+		errors.Is(err, raftnode.ErrQueueNoReceipt),
+		errors.Is(err, raftnode.ErrIndexNotFound),
+		errors.Is(err, raftnode.ErrRoleNotFound),
+		errors.Is(err, raftnode.ErrTokenNotFound):
+		code = api.CodeNotFound
+	case errors.Is(err, raftnode.ErrNotInteger),
+		errors.Is(err, raftnode.ErrOverflow),
+		errors.Is(err, raftnode.ErrLockHeld),
+		errors.Is(err, raftnode.ErrLockNotHeld),
+		errors.Is(err, raft.ErrNothingNewToSnapshot):
+		code = api.CodeConflict
+	case errors.Is(err, raftnode.ErrLeaseTTL),
+		errors.Is(err, raftnode.ErrQueueName),
+		errors.Is(err, raftnode.ErrIndexDef),
+		errors.Is(err, raftnode.ErrTxnOp),
+		errors.Is(err, raftnode.ErrBackupSince),
+		errors.Is(err, raftnode.ErrInvalidKey),
+		errors.Is(err, auth.ErrInvalidRole):
+		code = api.CodeInvalid
+	case errors.Is(err, auth.ErrUnauthenticated):
+		code = api.CodeUnauthenticated
+	case errors.Is(err, auth.ErrPermissionDenied):
+		code = api.CodePermissionDenied
+	case errors.Is(err, raft.ErrNotLeader),
+		errors.Is(err, raft.ErrLeadershipLost):
+		code = api.CodeNotLeader
+	case errors.Is(err, raftnode.ErrNoLeader):
+		code = api.CodeUnavailable
+	}
+	return api.Errorf(code, err.Error())
+}
+
+func wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return AsError(err)
+}
+
+func (s *Service) client(addr string) (*api.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cc, ok := s.conns[addr]
+	if !ok {
+		creds := insecure.NewCredentials()
+		if s.tls != nil {
+			creds = credentials.NewTLS(s.tls.ClientConfig())
+		}
+		opts := append(api.DialOptions(), grpc.WithTransportCredentials(creds), grpc.WithChainUnaryInterceptor(s.withToken, withRequestID))
+		var err error
+		cc, err = grpc.NewClient(addr, opts...)
+		if err != nil {
+			return nil, err
+		}
+		s.conns[addr] = cc
+	}
+	return api.NewClient(cc), nil
+}
+
+// leader returns a client for the leader, or nil if this node is leader.
+func (s *Service) leader() (*api.Client, error) {
+	if s.node.Raft.State() == raft.Leader {
+		return nil, nil
+	}
+	m, err := s.node.Leader()
+	if err != nil {
+		return nil, wrap(err)
+	}
+	if m.GRPCAddr == "" {
+		return nil, api.Errorf(api.CodeNotLeader, fmt.Sprintf("leader %s has no gRPC address to forward to", m.ID))
+	}
+	return s.client(m.GRPCAddr)
+}
+
+func (s *Service) forward(leader raftnode.NodeMeta, req []byte) ([]byte, error) {
+	if leader.GRPCAddr == "" {
+		return nil, api.Errorf(api.CodeNotLeader, fmt.Sprintf("leader %s has no gRPC address to forward to", leader.ID))
+	}
+	c, err := s.client(leader.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+	resp, err := c.Forward(ctx, &api.ForwardRequest{Command: req})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// Forward applies a command forwarded by a follower.
+func (s *Service) Forward(ctx context.Context, req []byte) ([]byte, error) {
+	out, err := s.node.ApplyForwarded(req)
+	return out, wrap(err)
+}
+
+func (s *Service) Get(ctx context.Context, key string, version uint64) (*api.KeyValue, error) {
+	if strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return nil, api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	var val []byte
+	var err error
+	if version != 0 {
+		val, err = s.node.GetAt(key, version)
+	} else {
+		val, err = s.node.Get(key)
+	}
+	if err != nil {
+		return nil, wrap(err)
+	}
+	kv := &api.KeyValue{Key: key, Value: val, Version: version}
+	if version == 0 {
+		kv.Version, _ = s.node.Version(key)
+	}
+	return kv, nil
+}
+
+// History lists the versions of key this node retains, oldest first.
+func (s *Service) History(ctx context.Context, key string) ([]bitcask.Version, error) {
+	if strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return nil, api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	versions, err := s.node.History(key)
+	return versions, wrap(err)
+}
+
+func (s *Service) Put(ctx context.Context, key string, val []byte, lease uint64) error {
+	if key == "" || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	return wrap(s.node.PutWithLease(key, val, lease))
+}
+
+// Add adds delta to the counter at key and returns its new value.
+func (s *Service) Add(ctx context.Context, key string, delta int64) (int64, error) {
+	if key == "" || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return 0, api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	n, err := s.node.Add(key, delta)
+	return n, wrap(err)
+}
+
+// AttachLease puts an existing key under lease id, or makes it permanent
+// again when id is 0. It reports false if the key does not exist.
+func (s *Service) AttachLease(ctx context.Context, key string, id uint64) (bool, error) {
+	if key == "" || strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return false, api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	ok, err := s.node.AttachLease(key, id)
+	return ok, wrap(err)
+}
+
+func (s *Service) Delete(ctx context.Context, key string) error {
+	if strings.HasPrefix(key, bitcask.SystemPrefix) {
+		return api.Errorf(api.CodeInvalid, "invalid key")
+	}
+	return wrap(s.node.Apply(raftnode.OpDelete, key, nil))
+}
+
+// Txn runs t through Raft. Every key it compares or operates on must be a
+// user key.
+func (s *Service) Txn(ctx context.Context, t raftnode.Txn) (*raftnode.TxnResult, error) {
+	for _, c := range t.Compare {
+		if err := checkName("key", c.Key); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range append(t.Success, t.Failure...) {
+		if err := checkName("key", op.Key); err != nil {
+			return nil, err
+		}
+	}
+	res, err := s.node.Txn(t)
+	return res, wrap(err)
+}
+
+// RetentionPolicies lists the retention policies in force.
+func (s *Service) RetentionPolicies(ctx context.Context) []bitcask.RetentionPolicy {
+	return s.node.Store.RetentionPolicies()
+}
+
+// SetRetention installs, replaces or, with neither limit set, removes the
+// retention policy for p.Prefix.
+func (s *Service) SetRetention(ctx context.Context, p bitcask.RetentionPolicy) error {
+	if strings.HasPrefix(p.Prefix, bitcask.SystemPrefix) {
+		return api.Errorf(api.CodeInvalid, "invalid prefix")
+	}
+	return wrap(s.node.SetRetention(p))
+}
+
+// Scan lists up to limit keys under prefix that sort after after, and
+// reports whether more remain.
+func (s *Service) Scan(ctx context.Context, prefix, after string, limit int) ([]api.KeyValue, bool, error) {
+	if strings.HasPrefix(prefix, bitcask.SystemPrefix) {
+		return nil, false, api.Errorf(api.CodeInvalid, "invalid prefix")
+	}
+	if limit <= 0 {
+		limit = defaultScanLimit
+	}
+	limit = min(limit, maxScanLimit)
+	keys := s.node.Store.KeysWithPrefix(prefix)
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	var items []api.KeyValue
+	for _, k := range keys[start:] {
+		if len(items) == limit {
+			return items, true, nil
+		}
+		val, err := s.node.Get(k)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, wrap(err)
+		}
+		version, _ := s.node.Version(k)
+		items = append(items, api.KeyValue{Key: k, Value: val, Version: version})
+	}
+	return items, false, nil
+}
+
+// Watch streams changes under prefix applied by this node.
+func (s *Service) Watch(ctx context.Context, prefix string) (<-chan raftnode.Event, func()) {
+	return s.node.Watch(prefix)
+}
+
+// Compact compacts the leader's data files.
+func (s *Service) Compact(ctx context.Context) error {
+	c, err := s.leader()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		_, err := c.Compact(ctx, &api.CompactRequest{})
+		return err
+	}
+	if err := s.node.Compact(); err != nil {
+		return wrap(fmt.Errorf("compaction failed: %w", err))
+	}
+	fut := s.node.Raft.Barrier(5 * time.Second)
+	if err := fut.Error(); err != nil {
+		return wrap(fmt.Errorf("failed to ensure Raft consistency post-compaction: %w", err))
+	}
+	return nil
+}
+
+// Snapshot takes a Raft snapshot of this node's state and truncates the
+// log behind it, entirely with truncate.
+func (s *Service) Snapshot(ctx context.Context, truncate bool) (*api.SnapshotResponse, error) {
+	meta, err := s.node.Snapshot(truncate)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	first, last, err := s.node.LogRange()
+	if err != nil {
+		return nil, wrap(err)
+	}
+	return &api.SnapshotResponse{Snapshot: snapshotOf(meta), FirstLogIndex: first, LastLogIndex: last}, nil
+}
+
+// Snapshots lists the snapshots this node keeps on disk.
+func (s *Service) Snapshots(ctx context.Context) (*api.SnapshotsResponse, error) {
+	metas, err := s.node.Snapshots()
+	if err != nil {
+		return nil, wrap(err)
+	}
+	out := &api.SnapshotsResponse{Snapshots: make([]api.Snapshot, 0, len(metas))}
+	for _, meta := range metas {
+		out.Snapshots = append(out.Snapshots, snapshotOf(meta))
+	}
+	return out, nil
+}
+
+// Backup captures this node's state for a backup archive: all of it, or
+// the changes after since when it is not zero. The caller closes the
+// returned state.
+func (s *Service) Backup(ctx context.Context, since uint64) (backup.Manifest, int64, io.ReadCloser, error) {
+	m, size, rc, err := s.node.Backup(since)
+	return m, size, rc, wrap(err)
+}
+
+// checkName rejects a key, or the name of a lock, queue or index, under
+// bitcask.SystemPrefix.
+func checkName(kind, name string) error {
+	if strings.HasPrefix(name, bitcask.SystemPrefix) {
+		return api.Errorf(api.CodeInvalid, "invalid "+kind)
+	}
+	return nil
+}
+
+func snapshotOf(meta *raft.SnapshotMeta) api.Snapshot {
+	return api.Snapshot{ID: meta.ID, Index: meta.Index, Term: meta.Term, Size: meta.Size}
+}
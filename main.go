@@ -9,7 +9,7 @@ import (
 
 func main() {
 	// Open or create the Bitcask Store in ./data
-	bc, err := bitcask.Open("./data")
+	bc, err := bitcask.Open("./data", nil)
 	if err != nil {
 		log.Fatalf("failed to open bitcask: %v", err)
 	}
@@ -0,0 +1,286 @@
+// Package api defines the messages, error codes and gRPC services of a
+// Hyphora node. The gRPC services use a JSON codec, so no generated code or
+// protobuf toolchain is needed to call them from Go.
+package api
+
+import "time"
+
+type KeyValue struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+type GetRequest struct {
+	Key string `json:"key"`
+	// Version reads the key as of a Raft index; see the retention policy
+	// documentation. Zero reads the current value.
+	Version uint64 `json:"version,omitempty"`
+}
+
+type GetResponse struct {
+	KeyValue
+}
+
+type PutRequest struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	Lease uint64 `json:"lease,omitempty"`
+}
+
+type PutResponse struct{}
+
+type DeleteRequest struct {
+	Key string `json:"key"`
+}
+
+type DeleteResponse struct{}
+
+// ScanRequest lists keys under Prefix in order, starting after After.
+type ScanRequest struct {
+	Prefix string `json:"prefix"`
+	After  string `json:"after,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+type ScanResponse struct {
+	Items []KeyValue `json:"items"`
+	More  bool       `json:"more"`
+}
+
+type WatchRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+type WatchEvent struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+// Member is one server of the Raft configuration. Status is "leader",
+// "reachable" or "unreachable" as seen by the leader, and empty when a
+// follower answered. LastContact is when the leader last heard from the
+// member, and is omitted for a member it has not heard from since it was
+// elected; on a follower it is set for the leader only.
+type Member struct {
+	ID          string    `json:"id"`
+	RaftAddress string    `json:"raft_address"`
+	HTTPAddress string    `json:"http_address,omitempty"`
+	GRPCAddress string    `json:"grpc_address,omitempty"`
+	Suffrage    string    `json:"suffrage"`
+	Leader      bool      `json:"leader"`
+	Status      string    `json:"status,omitempty"`
+	LastContact time.Time `json:"last_contact,omitzero"`
+}
+
+type MembersRequest struct{}
+
+type MembersResponse struct {
+	Members []Member `json:"members"`
+}
+
+type NodeInfoRequest struct{}
+
+// NodeInfoResponse describes the node that answers. InCluster is false
+// while a new node waits to be added to a cluster or bootstrapped.
+type NodeInfoResponse struct {
+	ID          string `json:"id"`
+	RaftAddress string `json:"raft_address"`
+	HTTPAddress string `json:"http_address,omitempty"`
+	GRPCAddress string `json:"grpc_address,omitempty"`
+	InCluster   bool   `json:"in_cluster"`
+}
+
+// AddPeerRequest adds a voter, or with NonVoter a member that replicates
+// the log without voting.
+type AddPeerRequest struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	NonVoter bool   `json:"non_voter,omitempty"`
+}
+
+type AddPeerResponse struct{}
+
+// RemovePeerRequest removes a member. Force skips the check that a
+// reachable quorum of voters remains.
+type RemovePeerRequest struct {
+	ID    string `json:"id"`
+	Force bool   `json:"force,omitempty"`
+}
+
+type RemovePeerResponse struct{}
+
+// DemotePeerRequest turns a voter into a non-voter, with the same quorum
+// check as RemovePeerRequest.
+type DemotePeerRequest struct {
+	ID    string `json:"id"`
+	Force bool   `json:"force,omitempty"`
+}
+
+type DemotePeerResponse struct{}
+
+// TransferLeaderRequest hands leadership to the voter ID, or to any
+// up-to-date voter when ID is empty. Force allows a voter the leader
+// cannot reach.
+type TransferLeaderRequest struct {
+	ID    string `json:"id,omitempty"`
+	Force bool   `json:"force,omitempty"`
+}
+
+type TransferLeaderResponse struct{}
+
+type CompactRequest struct{}
+
+type CompactResponse struct{}
+
+// SnapshotRequest asks for a Raft snapshot. Truncate drops every log entry
+// it covers, instead of keeping the configured trailing entries.
+type SnapshotRequest struct {
+	Truncate bool `json:"truncate,omitempty"`
+}
+
+// Snapshot describes a Raft snapshot kept on disk.
+type Snapshot struct {
+	ID    string `json:"id"`
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Size  int64  `json:"size"`
+}
+
+// SnapshotResponse describes the new snapshot, and the entries the log
+// kept behind it; both indexes are zero when the log is empty.
+type SnapshotResponse struct {
+	Snapshot
+	FirstLogIndex uint64 `json:"first_log_index"`
+	LastLogIndex  uint64 `json:"last_log_index"`
+}
+
+type SnapshotsRequest struct{}
+
+// SnapshotsResponse lists the snapshots a node keeps, newest first.
+type SnapshotsResponse struct {
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+type StatusRequest struct{}
+
+// StatusResponse describes the node that answers. Ready and NotReady
+// report the readiness check of /readyz; Raft holds raft.Stats().
+type StatusResponse struct {
+	ID                string            `json:"id"`
+	State             string            `json:"state"`
+	Ready             bool              `json:"ready"`
+	NotReady          string            `json:"not_ready,omitempty"`
+	LeaderID          string            `json:"leader_id,omitempty"`
+	LeaderAddress     string            `json:"leader_address,omitempty"`
+	LeaderHTTPAddress string            `json:"leader_http_address,omitempty"`
+	Raft              map[string]string `json:"raft"`
+	Members           []Member          `json:"members"`
+	Store             StoreStats        `json:"store"`
+	LastSnapshot      *Snapshot         `json:"last_snapshot,omitempty"`
+	LastCompaction    *Compaction       `json:"last_compaction,omitempty"`
+}
+
+// StoreStats describes the data files: LiveBytes of current records and
+// DeadBytes that compaction reclaims.
+type StoreStats struct {
+	Keys      int   `json:"keys"`
+	DataFiles int   `json:"data_files"`
+	DataBytes int64 `json:"data_bytes"`
+	LiveBytes int64 `json:"live_bytes"`
+	DeadBytes int64 `json:"dead_bytes"`
+}
+
+// Compaction describes the last compaction since the node started.
+type Compaction struct {
+	Time           time.Time `json:"time"`
+	DurationMS     int64     `json:"duration_ms"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// Rule grants Access, "read", "write" or "admin", to the resources of
+// Namespace whose names start with Prefix. The namespaces are kv, lease,
+// lock, queue, index and cluster; an empty one covers them all.
+type Rule struct {
+	Namespace string `json:"namespace,omitempty"`
+	Prefix    string `json:"prefix"`
+	Access    string `json:"access"`
+}
+
+type Role struct {
+	Name    string `json:"name"`
+	Rules   []Rule `json:"rules"`
+	Builtin bool   `json:"builtin,omitempty"`
+}
+
+type RolesRequest struct{}
+
+type RolesResponse struct {
+	Roles []Role `json:"roles"`
+}
+
+// PutRoleRequest creates or replaces a role.
+type PutRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+type PutRoleResponse struct{}
+
+type DeleteRoleRequest struct {
+	Name string `json:"name"`
+}
+
+type DeleteRoleResponse struct{}
+
+// Token describes a token without its secret.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Roles   []string  `json:"roles"`
+	Created time.Time `json:"created,omitzero"`
+}
+
+type TokensRequest struct{}
+
+type TokensResponse struct {
+	Tokens []Token `json:"tokens"`
+}
+
+type CreateTokenRequest struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// CreateTokenResponse carries the token's secret, which cannot be read
+// again.
+type CreateTokenResponse struct {
+	Token  Token  `json:"token"`
+	Secret string `json:"secret"`
+}
+
+type DeleteTokenRequest struct {
+	ID string `json:"id"`
+}
+
+type DeleteTokenResponse struct{}
+
+type WhoAmIRequest struct{}
+
+// WhoAmIResponse names the caller; it is empty when auth is disabled.
+type WhoAmIResponse struct {
+	Name        string   `json:"name,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	AuthEnabled bool     `json:"auth_enabled"`
+}
+
+// ForwardRequest carries a replicated command from a follower to the
+// leader. Command and Result are opaque to clients.
+type ForwardRequest struct {
+	Command []byte `json:"command"`
+}
+
+type ForwardResponse struct {
+	Result []byte `json:"result"`
+}
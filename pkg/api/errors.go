@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code classifies an error the same way on the HTTP and gRPC APIs.
+type Code int
+
+const (
+	CodeInternal Code = iota
+	CodeNotFound
+	CodeInvalid
+	CodeConflict
+	CodeNotLeader
+	CodeUnavailable
+	CodePermissionDenied
+	CodeUnauthenticated
+)
+
+var codeNames = map[Code]string{
+	CodeInternal:         "internal",
+	CodeNotFound:         "not_found",
+	CodeInvalid:          "invalid",
+	CodeConflict:         "conflict",
+	CodeNotLeader:        "not_leader",
+	CodeUnavailable:      "unavailable",
+	CodePermissionDenied: "permission_denied",
+	CodeUnauthenticated:  "unauthenticated",
+}
+
+func (c Code) String() string {
+	return codeNames[c]
+}
+
+func (c Code) HTTPStatus() int {
+	switch c {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeInvalid:
+		return http.StatusBadRequest
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeNotLeader:
+		return http.StatusMisdirectedRequest
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (c Code) GRPCCode() codes.Code {
+	switch c {
+	case CodeNotFound:
+		return codes.NotFound
+	case CodeInvalid:
+		return codes.InvalidArgument
+	case CodeConflict:
+		return codes.Aborted
+	case CodeNotLeader:
+		return codes.FailedPrecondition
+	case CodeUnavailable:
+		return codes.Unavailable
+	case CodePermissionDenied:
+		return codes.PermissionDenied
+	case CodeUnauthenticated:
+		return codes.Unauthenticated
+	default:
+		return codes.Internal
+	}
+}
+
+// CodeFromHTTP is the inverse of Code.HTTPStatus.
+func CodeFromHTTP(status int) Code {
+	for c := range codeNames {
+		if c != CodeInternal && c.HTTPStatus() == status {
+			return c
+		}
+	}
+	return CodeInternal
+}
+
+// Error is returned by both APIs. Over gRPC it travels as a status with
+// the matching code.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(e.Code.GRPCCode(), e.Message)
+}
+
+func Errorf(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// FromGRPC turns an error returned by a gRPC call back into an *Error.
+func FromGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for c := range codeNames {
+		if c.GRPCCode() == st.Code() {
+			return &Error{Code: c, Message: st.Message()}
+		}
+	}
+	return &Error{Code: CodeInternal, Message: st.Message()}
+}
+
+// IsCode reports whether err is an *Error with the given code.
+func IsCode(err error, code Code) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the gRPC content subtype used by every Hyphora service.
+const CodecName = "json"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return CodecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// DialOptions returns the options a client connection needs to talk to a
+// node, on top of its transport credentials.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)),
+	}
+}
+
+func unary[Req any, Resp any](call func(srv any, ctx context.Context, req *Req) (*Resp, error), method string) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(Req)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv, ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method}
+			return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv, ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+// KVServer serves reads, writes and change streams of keys.
+type KVServer interface {
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	Scan(context.Context, *ScanRequest) (*ScanResponse, error)
+	Watch(*WatchRequest, KVWatchServer) error
+}
+
+type KVWatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type kvWatchServer struct {
+	grpc.ServerStream
+}
+
+func (s *kvWatchServer) Send(ev *WatchEvent) error {
+	return s.ServerStream.SendMsg(ev)
+}
+
+var kvServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hyphora.KV",
+	HandlerType: (*KVServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(func(srv any, ctx context.Context, req *GetRequest) (*GetResponse, error) {
+			return srv.(KVServer).Get(ctx, req)
+		}, "Get"),
+		unary(func(srv any, ctx context.Context, req *PutRequest) (*PutResponse, error) {
+			return srv.(KVServer).Put(ctx, req)
+		}, "Put"),
+		unary(func(srv any, ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+			return srv.(KVServer).Delete(ctx, req)
+		}, "Delete"),
+		unary(func(srv any, ctx context.Context, req *ScanRequest) (*ScanResponse, error) {
+			return srv.(KVServer).Scan(ctx, req)
+		}, "Scan"),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Watch",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			in := new(WatchRequest)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			return srv.(KVServer).Watch(in, &kvWatchServer{stream})
+		},
+	}},
+}
+
+func RegisterKVServer(s grpc.ServiceRegistrar, srv KVServer) {
+	s.RegisterService(&kvServiceDesc, srv)
+}
+
+// ClusterServer serves Raft membership.
+type ClusterServer interface {
+	Members(context.Context, *MembersRequest) (*MembersResponse, error)
+	NodeInfo(context.Context, *NodeInfoRequest) (*NodeInfoResponse, error)
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	AddPeer(context.Context, *AddPeerRequest) (*AddPeerResponse, error)
+	RemovePeer(context.Context, *RemovePeerRequest) (*RemovePeerResponse, error)
+	DemotePeer(context.Context, *DemotePeerRequest) (*DemotePeerResponse, error)
+	TransferLeader(context.Context, *TransferLeaderRequest) (*TransferLeaderResponse, error)
+}
+
+var clusterServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hyphora.Cluster",
+	HandlerType: (*ClusterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(func(srv any, ctx context.Context, req *MembersRequest) (*MembersResponse, error) {
+			return srv.(ClusterServer).Members(ctx, req)
+		}, "Members"),
+		unary(func(srv any, ctx context.Context, req *NodeInfoRequest) (*NodeInfoResponse, error) {
+			return srv.(ClusterServer).NodeInfo(ctx, req)
+		}, "NodeInfo"),
+		unary(func(srv any, ctx context.Context, req *StatusRequest) (*StatusResponse, error) {
+			return srv.(ClusterServer).Status(ctx, req)
+		}, "Status"),
+		unary(func(srv any, ctx context.Context, req *AddPeerRequest) (*AddPeerResponse, error) {
+			return srv.(ClusterServer).AddPeer(ctx, req)
+		}, "AddPeer"),
+		unary(func(srv any, ctx context.Context, req *RemovePeerRequest) (*RemovePeerResponse, error) {
+			return srv.(ClusterServer).RemovePeer(ctx, req)
+		}, "RemovePeer"),
+		unary(func(srv any, ctx context.Context, req *DemotePeerRequest) (*DemotePeerResponse, error) {
+			return srv.(ClusterServer).DemotePeer(ctx, req)
+		}, "DemotePeer"),
+		unary(func(srv any, ctx context.Context, req *TransferLeaderRequest) (*TransferLeaderResponse, error) {
+			return srv.(ClusterServer).TransferLeader(ctx, req)
+		}, "TransferLeader"),
+	},
+}
+
+func RegisterClusterServer(s grpc.ServiceRegistrar, srv ClusterServer) {
+	s.RegisterService(&clusterServiceDesc, srv)
+}
+
+// AdminServer serves maintenance operations.
+type AdminServer interface {
+	Compact(context.Context, *CompactRequest) (*CompactResponse, error)
+	Snapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error)
+	Snapshots(context.Context, *SnapshotsRequest) (*SnapshotsResponse, error)
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hyphora.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(func(srv any, ctx context.Context, req *CompactRequest) (*CompactResponse, error) {
+			return srv.(AdminServer).Compact(ctx, req)
+		}, "Compact"),
+		unary(func(srv any, ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+			return srv.(AdminServer).Snapshot(ctx, req)
+		}, "Snapshot"),
+		unary(func(srv any, ctx context.Context, req *SnapshotsRequest) (*SnapshotsResponse, error) {
+			return srv.(AdminServer).Snapshots(ctx, req)
+		}, "Snapshots"),
+	},
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&adminServiceDesc, srv)
+}
+
+// AuthServer manages roles and tokens.
+type AuthServer interface {
+	Roles(context.Context, *RolesRequest) (*RolesResponse, error)
+	PutRole(context.Context, *PutRoleRequest) (*PutRoleResponse, error)
+	DeleteRole(context.Context, *DeleteRoleRequest) (*DeleteRoleResponse, error)
+	Tokens(context.Context, *TokensRequest) (*TokensResponse, error)
+	CreateToken(context.Context, *CreateTokenRequest) (*CreateTokenResponse, error)
+	DeleteToken(context.Context, *DeleteTokenRequest) (*DeleteTokenResponse, error)
+	WhoAmI(context.Context, *WhoAmIRequest) (*WhoAmIResponse, error)
+}
+
+var authServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hyphora.Auth",
+	HandlerType: (*AuthServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(func(srv any, ctx context.Context, req *RolesRequest) (*RolesResponse, error) {
+			return srv.(AuthServer).Roles(ctx, req)
+		}, "Roles"),
+		unary(func(srv any, ctx context.Context, req *PutRoleRequest) (*PutRoleResponse, error) {
+			return srv.(AuthServer).PutRole(ctx, req)
+		}, "PutRole"),
+		unary(func(srv any, ctx context.Context, req *DeleteRoleRequest) (*DeleteRoleResponse, error) {
+			return srv.(AuthServer).DeleteRole(ctx, req)
+		}, "DeleteRole"),
+		unary(func(srv any, ctx context.Context, req *TokensRequest) (*TokensResponse, error) {
+			return srv.(AuthServer).Tokens(ctx, req)
+		}, "Tokens"),
+		unary(func(srv any, ctx context.Context, req *CreateTokenRequest) (*CreateTokenResponse, error) {
+			return srv.(AuthServer).CreateToken(ctx, req)
+		}, "CreateToken"),
+		unary(func(srv any, ctx context.Context, req *DeleteTokenRequest) (*DeleteTokenResponse, error) {
+			return srv.(AuthServer).DeleteToken(ctx, req)
+		}, "DeleteToken"),
+		unary(func(srv any, ctx context.Context, req *WhoAmIRequest) (*WhoAmIResponse, error) {
+			return srv.(AuthServer).WhoAmI(ctx, req)
+		}, "WhoAmI"),
+	},
+}
+
+func RegisterAuthServer(s grpc.ServiceRegistrar, srv AuthServer) {
+	s.RegisterService(&authServiceDesc, srv)
+}
+
+// InternalServer is used between nodes and is not part of the client API.
+type InternalServer interface {
+	Forward(context.Context, *ForwardRequest) (*ForwardResponse, error)
+}
+
+var internalServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hyphora.Internal",
+	HandlerType: (*InternalServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unary(func(srv any, ctx context.Context, req *ForwardRequest) (*ForwardResponse, error) {
+			return srv.(InternalServer).Forward(ctx, req)
+		}, "Forward"),
+	},
+}
+
+func RegisterInternalServer(s grpc.ServiceRegistrar, srv InternalServer) {
+	s.RegisterService(&internalServiceDesc, srv)
+}
+
+// Client calls every service of one node. Errors are converted with
+// FromGRPC, so they can be inspected with IsCode.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+func invoke[Resp any](ctx context.Context, c *Client, method string, req any) (*Resp, error) {
+	out := new(Resp)
+	if err := c.cc.Invoke(ctx, method, req, out); err != nil {
+		return nil, FromGRPC(err)
+	}
+	return out, nil
+}
+
+func (c *Client) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	return invoke[GetResponse](ctx, c, "/hyphora.KV/Get", req)
+}
+
+func (c *Client) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	return invoke[PutResponse](ctx, c, "/hyphora.KV/Put", req)
+}
+
+func (c *Client) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	return invoke[DeleteResponse](ctx, c, "/hyphora.KV/Delete", req)
+}
+
+func (c *Client) Scan(ctx context.Context, req *ScanRequest) (*ScanResponse, error) {
+	return invoke[ScanResponse](ctx, c, "/hyphora.KV/Scan", req)
+}
+
+// Watch streams events until ctx is cancelled or the server ends the
+// stream. Recv returns io.EOF when the stream ends cleanly.
+func (c *Client) Watch(ctx context.Context, req *WatchRequest) (*WatchStream, error) {
+	stream, err := c.cc.NewStream(ctx, &kvServiceDesc.Streams[0], "/hyphora.KV/Watch")
+	if err != nil {
+		return nil, FromGRPC(err)
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, FromGRPC(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, FromGRPC(err)
+	}
+	return &WatchStream{stream}, nil
+}
+
+type WatchStream struct {
+	stream grpc.ClientStream
+}
+
+func (w *WatchStream) Recv() (*WatchEvent, error) {
+	ev := new(WatchEvent)
+	if err := w.stream.RecvMsg(ev); err != nil {
+		return nil, FromGRPC(err)
+	}
+	return ev, nil
+}
+
+func (c *Client) Members(ctx context.Context, req *MembersRequest) (*MembersResponse, error) {
+	return invoke[MembersResponse](ctx, c, "/hyphora.Cluster/Members", req)
+}
+
+func (c *Client) NodeInfo(ctx context.Context, req *NodeInfoRequest) (*NodeInfoResponse, error) {
+	return invoke[NodeInfoResponse](ctx, c, "/hyphora.Cluster/NodeInfo", req)
+}
+
+func (c *Client) Status(ctx context.Context, req *StatusRequest) (*StatusResponse, error) {
+	return invoke[StatusResponse](ctx, c, "/hyphora.Cluster/Status", req)
+}
+
+func (c *Client) AddPeer(ctx context.Context, req *AddPeerRequest) (*AddPeerResponse, error) {
+	return invoke[AddPeerResponse](ctx, c, "/hyphora.Cluster/AddPeer", req)
+}
+
+func (c *Client) RemovePeer(ctx context.Context, req *RemovePeerRequest) (*RemovePeerResponse, error) {
+	return invoke[RemovePeerResponse](ctx, c, "/hyphora.Cluster/RemovePeer", req)
+}
+
+func (c *Client) DemotePeer(ctx context.Context, req *DemotePeerRequest) (*DemotePeerResponse, error) {
+	return invoke[DemotePeerResponse](ctx, c, "/hyphora.Cluster/DemotePeer", req)
+}
+
+func (c *Client) TransferLeader(ctx context.Context, req *TransferLeaderRequest) (*TransferLeaderResponse, error) {
+	return invoke[TransferLeaderResponse](ctx, c, "/hyphora.Cluster/TransferLeader", req)
+}
+
+func (c *Client) Compact(ctx context.Context, req *CompactRequest) (*CompactResponse, error) {
+	return invoke[CompactResponse](ctx, c, "/hyphora.Admin/Compact", req)
+}
+
+func (c *Client) Snapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	return invoke[SnapshotResponse](ctx, c, "/hyphora.Admin/Snapshot", req)
+}
+
+func (c *Client) Snapshots(ctx context.Context, req *SnapshotsRequest) (*SnapshotsResponse, error) {
+	return invoke[SnapshotsResponse](ctx, c, "/hyphora.Admin/Snapshots", req)
+}
+
+func (c *Client) Roles(ctx context.Context, req *RolesRequest) (*RolesResponse, error) {
+	return invoke[RolesResponse](ctx, c, "/hyphora.Auth/Roles", req)
+}
+
+func (c *Client) PutRole(ctx context.Context, req *PutRoleRequest) (*PutRoleResponse, error) {
+	return invoke[PutRoleResponse](ctx, c, "/hyphora.Auth/PutRole", req)
+}
+
+func (c *Client) DeleteRole(ctx context.Context, req *DeleteRoleRequest) (*DeleteRoleResponse, error) {
+	return invoke[DeleteRoleResponse](ctx, c, "/hyphora.Auth/DeleteRole", req)
+}
+
+func (c *Client) Tokens(ctx context.Context, req *TokensRequest) (*TokensResponse, error) {
+	return invoke[TokensResponse](ctx, c, "/hyphora.Auth/Tokens", req)
+}
+
+func (c *Client) CreateToken(ctx context.Context, req *CreateTokenRequest) (*CreateTokenResponse, error) {
+	return invoke[CreateTokenResponse](ctx, c, "/hyphora.Auth/CreateToken", req)
+}
+
+func (c *Client) DeleteToken(ctx context.Context, req *DeleteTokenRequest) (*DeleteTokenResponse, error) {
+	return invoke[DeleteTokenResponse](ctx, c, "/hyphora.Auth/DeleteToken", req)
+}
+
+func (c *Client) WhoAmI(ctx context.Context, req *WhoAmIRequest) (*WhoAmIResponse, error) {
+	return invoke[WhoAmIResponse](ctx, c, "/hyphora.Auth/WhoAmI", req)
+}
+
+func (c *Client) Forward(ctx context.Context, req *ForwardRequest) (*ForwardResponse, error) {
+	return invoke[ForwardResponse](ctx, c, "/hyphora.Internal/Forward", req)
+}
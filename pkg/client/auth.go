@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// Roles lists the built-in and the replicated roles.
+func (c *Client) Roles(ctx context.Context) ([]api.Role, error) {
+	var out api.RolesResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/auth/roles", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Roles, nil
+}
+
+// PutRole creates or replaces a role. Built-in roles cannot be replaced.
+func (c *Client) PutRole(ctx context.Context, role api.Role) error {
+	req := api.PutRoleRequest{Role: role}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/auth/roles/put", body: req, idempotent: true}, nil)
+}
+
+func (c *Client) DeleteRole(ctx context.Context, name string) error {
+	req := api.DeleteRoleRequest{Name: name}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/auth/roles/delete", body: req}, nil)
+}
+
+// Tokens lists the tokens created through the API, without their secrets.
+func (c *Client) Tokens(ctx context.Context) ([]api.Token, error) {
+	var out api.TokensResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/auth/tokens", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Tokens, nil
+}
+
+// CreateToken creates a token with roles. The secret in the answer is the
+// only copy; the cluster keeps just its hash.
+func (c *Client) CreateToken(ctx context.Context, name string, roles []string) (*api.CreateTokenResponse, error) {
+	var out api.CreateTokenResponse
+	req := api.CreateTokenRequest{Name: name, Roles: roles}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/v1/auth/tokens/create", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) DeleteToken(ctx context.Context, id string) error {
+	req := api.DeleteTokenRequest{ID: id}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/auth/tokens/delete", body: req}, nil)
+}
+
+// WhoAmI describes the caller's token.
+func (c *Client) WhoAmI(ctx context.Context) (*api.WhoAmIResponse, error) {
+	var out api.WhoAmIResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/auth/whoami", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,368 @@
+// Package client is the Go client of a Hyphora cluster. It talks to the
+// HTTP API, finds the leader from a list of seed nodes and follows it
+// across elections, retrying requests that are safe to retry.
+//
+//	c, err := client.New(client.Config{Endpoints: []string{"localhost:8081", "localhost:8082"}})
+//	if err != nil { ... }
+//	err = c.Put(ctx, "greeting", []byte("hello"))
+//	kv, err := c.Get(ctx, "greeting")
+//
+// Errors answered by a node are *Error values that match ErrNotFound,
+// ErrConflict, ErrInvalid, ErrNotLeader, ErrUnavailable or
+// ErrPermissionDenied with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 2 * time.Second
+)
+
+type Config struct {
+	// Endpoints are the HTTP addresses of some nodes, as "host:port" or as
+	// URLs. The rest of the cluster is discovered from them.
+	Endpoints []string
+	// HTTPClient defaults to http.DefaultClient. Use contexts rather than
+	// its Timeout to bound calls, or watches will be cut off.
+	HTTPClient *http.Client
+	// TLS makes endpoints without a scheme use HTTPS. Unless HTTPClient
+	// is set, requests are made with this configuration.
+	TLS *tls.Config
+	// Token, when set, is sent as a bearer token with every request.
+	Token string
+	// MaxRetries bounds the retries of one call; zero means 5 and a
+	// negative value disables retries.
+	MaxRetries int
+	// Backoff is the delay before the first retry. It doubles on every
+	// retry up to MaxBackoff. They default to 100ms and 2s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+type Client struct {
+	cfg    Config
+	hc     *http.Client
+	scheme string
+	seeds  []string
+
+	mu        sync.Mutex
+	endpoints []string
+	leader    string
+	next      int
+}
+
+func New(cfg Config) (*Client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("client: no endpoints")
+	}
+	scheme := "http"
+	if cfg.TLS != nil {
+		scheme = "https"
+	}
+	if cfg.HTTPClient == nil && cfg.TLS != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = cfg.TLS
+		cfg.HTTPClient = &http.Client{Transport: tr}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	c := &Client{cfg: cfg, hc: cfg.HTTPClient, scheme: scheme}
+	for _, ep := range cfg.Endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = scheme + "://" + ep
+		}
+		u, err := url.Parse(ep)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("client: invalid endpoint %q", ep)
+		}
+		c.scheme = u.Scheme
+		c.seeds = append(c.seeds, u.Scheme+"://"+u.Host)
+	}
+	c.endpoints = slices.Clone(c.seeds)
+	return c, nil
+}
+
+// Endpoints returns the nodes the client currently knows about.
+func (c *Client) Endpoints() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.endpoints)
+}
+
+// Leader asks the cluster for its leader and returns the leader's HTTP
+// address.
+func (c *Client) Leader(ctx context.Context) (string, error) {
+	c.forget("")
+	if _, err := c.sync(ctx); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leader == "" {
+		return "", &Error{Code: api.CodeUnavailable, Message: "no leader elected"}
+	}
+	return strings.TrimPrefix(c.leader, c.scheme+"://"), nil
+}
+
+// request describes one HTTP call. Calls marked idempotent are retried
+// after any failure; the others only when the node provably did not act
+// on them, so a counter is never incremented twice.
+type request struct {
+	method     string
+	path       string
+	query      url.Values
+	body       any
+	idempotent bool
+	// accept lists non-2xx statuses that still carry a result.
+	accept []int
+}
+
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+	var lastErr error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := c.sleep(ctx, attempt); err != nil {
+				return nil, lastErr
+			}
+		}
+		base, err := c.target(ctx)
+		if errors.Is(err, ErrUnauthenticated) {
+			return nil, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := c.send(ctx, base, req, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			c.forget(base)
+			lastErr = err
+			if req.idempotent || isDialError(err) {
+				continue
+			}
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 || slices.Contains(req.accept, resp.StatusCode) {
+			return resp, nil
+		}
+		e := readError(resp)
+		lastErr = e
+		switch {
+		case e.Code == api.CodeUnavailable:
+			c.forget(base)
+			continue
+		case e.Code == api.CodeNotLeader && req.idempotent:
+			c.forget(base)
+			continue
+		}
+		return nil, e
+	}
+	return nil, lastErr
+}
+
+// call runs req and decodes the JSON answer into out, if out is not nil.
+func (c *Client) call(ctx context.Context, req request, out any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// callNode sends req once to the node at addr rather than to the leader.
+func (c *Client) callNode(ctx context.Context, addr string, req request, out any) error {
+	base := addr
+	if !strings.Contains(base, "://") {
+		base = c.scheme + "://" + base
+	}
+	resp, err := c.send(ctx, base, req, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return readError(resp)
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) send(ctx context.Context, base string, req request, body []byte) (*http.Response, error) {
+	u := base + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, req.method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	return c.hc.Do(hreq)
+}
+
+// target returns the node to send the next request to: the leader when it
+// is known, otherwise whichever node answered the membership query.
+func (c *Client) target(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	leader := c.leader
+	c.mu.Unlock()
+	if leader != "" {
+		return leader, nil
+	}
+	return c.sync(ctx)
+}
+
+// forget drops base as the known leader, so the next request looks the
+// leader up again. An empty base always drops it.
+func (c *Client) forget(base string) {
+	c.mu.Lock()
+	if base == "" || c.leader == base {
+		c.leader = ""
+	}
+	c.mu.Unlock()
+}
+
+// sync refreshes the membership and leader from the first node that
+// answers, starting at a different node each time.
+func (c *Client) sync(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	endpoints := slices.Clone(c.endpoints)
+	start := c.next
+	c.next++
+	c.mu.Unlock()
+
+	var lastErr error
+	for i := range endpoints {
+		base := endpoints[(start+i)%len(endpoints)]
+		members, err := c.members(ctx, base)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			// Every node holds the same tokens; none would take this one.
+			if errors.Is(err, ErrUnauthenticated) {
+				return "", err
+			}
+			lastErr = err
+			continue
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.endpoints = slices.Clone(c.seeds)
+		c.leader = ""
+		for _, m := range members {
+			if m.HTTPAddress == "" {
+				continue
+			}
+			addr := c.scheme + "://" + m.HTTPAddress
+			if !slices.Contains(c.endpoints, addr) {
+				c.endpoints = append(c.endpoints, addr)
+			}
+			if m.Leader {
+				c.leader = addr
+			}
+		}
+		if c.leader != "" {
+			return c.leader, nil
+		}
+		return base, nil
+	}
+	return "", fmt.Errorf("%w: no endpoint reachable: %v", ErrUnavailable, lastErr)
+}
+
+func (c *Client) members(ctx context.Context, base string) ([]api.Member, error) {
+	var out api.MembersResponse
+	if err := c.callNode(ctx, base, request{method: http.MethodGet, path: "/v1/cluster/members"}, &out); err != nil {
+		return nil, err
+	}
+	return out.Members, nil
+}
+
+func (c *Client) sleep(ctx context.Context, attempt int) error {
+	d := c.cfg.Backoff << (attempt - 1)
+	if d > c.cfg.MaxBackoff || d <= 0 {
+		d = c.cfg.MaxBackoff
+	}
+	d = d/2 + rand.N(d/2+1)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// readError turns a failed response into an *Error and closes its body.
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	text := strings.TrimSpace(string(msg))
+	if text == "" {
+		text = http.StatusText(resp.StatusCode)
+	}
+	return &Error{Code: api.CodeFromHTTP(resp.StatusCode), Status: resp.StatusCode, Message: text}
+}
+
+// isDialError reports whether err happened before the request reached the
+// node, which makes any request safe to retry.
+func isDialError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
@@ -0,0 +1,176 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AMS003010/Hyphora/pkg/client"
+	"github.com/AMS003010/Hyphora/pkg/client/clienttest"
+)
+
+// startCluster runs a three-node cluster for the length of the test.
+func startCluster(t *testing.T) *clienttest.Cluster {
+	t.Helper()
+	cluster, err := clienttest.Start(3, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cluster.Close() })
+	return cluster
+}
+
+// newClient gives the client enough retries to outlast an election.
+func newClient(t *testing.T, endpoints ...string) *client.Client {
+	t.Helper()
+	c, err := client.New(client.Config{Endpoints: endpoints, MaxRetries: 20, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// deadAddr returns a loopback address nothing listens on.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestDiscoversLeaderFromFollower(t *testing.T) {
+	cluster := startCluster(t)
+	leader := cluster.Leader()
+	var follower *clienttest.Node
+	for _, nd := range cluster.Nodes {
+		if nd != leader {
+			follower = nd
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c := newClient(t, follower.HTTPAddr)
+	got, err := c.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != leader.HTTPAddr {
+		t.Fatalf("leader = %s, want %s", got, leader.HTTPAddr)
+	}
+	if n := len(c.Endpoints()); n != len(cluster.Nodes) {
+		t.Fatalf("client knows %d endpoints, want %d", n, len(cluster.Nodes))
+	}
+}
+
+func TestFollowsLeaderAcrossFailover(t *testing.T) {
+	cluster := startCluster(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	c := newClient(t, cluster.Endpoints()...)
+	// Without keep-alives every request dials afresh, so one sent to a
+	// stopped node fails before reaching it.
+	counter, err := client.New(client.Config{
+		Endpoints:  cluster.Endpoints(),
+		HTTPClient: &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+		MaxRetries: 20,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(ctx, "greeting", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := counter.Incr(ctx, "hits", 1); err != nil {
+		t.Fatal(err)
+	}
+	old := cluster.Leader()
+	if err := cluster.Stop(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both clients still point at the stopped leader and have to find the
+	// new one by themselves.
+	if err := c.Put(ctx, "greeting", []byte("hello again")); err != nil {
+		t.Fatalf("put after failover: %v", err)
+	}
+	kv, err := c.Get(ctx, "greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kv.Value) != "hello again" {
+		t.Fatalf("value = %q, want %q", kv.Value, "hello again")
+	}
+	leader, err := c.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader == old.HTTPAddr {
+		t.Fatalf("client still follows the stopped leader %s", leader)
+	}
+
+	// A counter is only retried because the dial failed, and is applied
+	// once.
+	n, err := counter.Incr(ctx, "hits", 1)
+	if err != nil {
+		t.Fatalf("incr after failover: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("hits = %d, want 2", n)
+	}
+}
+
+func TestSkipsUnreachableEndpoint(t *testing.T) {
+	cluster := startCluster(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c := newClient(t, append([]string{deadAddr(t)}, cluster.Endpoints()...)...)
+
+	if err := c.Put(ctx, "greeting", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := c.Get(ctx, "greeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kv.Value) != "hello" {
+		t.Fatalf("value = %q, want %q", kv.Value, "hello")
+	}
+}
+
+func TestUnreachableClusterFails(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := client.New(client.Config{Endpoints: []string{deadAddr(t)}, MaxRetries: 2, Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "greeting"); !errors.Is(err, client.ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cluster := startCluster(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c := newClient(t, cluster.Endpoints()...)
+
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, client.ErrKeyNotFound) {
+		t.Fatalf("get missing key: err = %v, want ErrKeyNotFound", err)
+	}
+	if err := c.Put(ctx, "\x00applied", []byte("x")); !errors.Is(err, client.ErrInvalid) {
+		t.Fatalf("put system key: err = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Package clienttest runs a Hyphora cluster inside the current process, so
+// code built on package client can be tested without starting nodes.
+//
+//	cluster, err := clienttest.Start(3, t.TempDir())
+//	if err != nil { t.Fatal(err) }
+//	defer cluster.Close()
+//	c, err := client.New(client.Config{Endpoints: cluster.Endpoints()})
+package clienttest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/AMS003010/Hyphora/internal/httpapi"
+	"github.com/AMS003010/Hyphora/internal/raftnode"
+	"github.com/AMS003010/Hyphora/internal/service"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+)
+
+const startTimeout = 15 * time.Second
+
+type Cluster struct {
+	Nodes []*Node
+}
+
+// Node is one member of a Cluster, listening on loopback ports chosen by
+// the system.
+type Node struct {
+	ID       string
+	RaftAddr string
+	HTTPAddr string
+	GRPCAddr string
+
+	node    *raftnode.Node
+	http    *http.Server
+	grpc    *grpc.Server
+	stopped bool
+}
+
+// Start runs n nodes with their data under dir and returns once they form
+// one cluster whose every member knows every node's address.
+func Start(n int, dir string) (*Cluster, error) {
+	if n < 1 {
+		return nil, errors.New("clienttest: need at least one node")
+	}
+	c := &Cluster{}
+	for i := range n {
+		nd, err := startNode(fmt.Sprintf("n%d", i+1), filepath.Join(dir, fmt.Sprintf("n%d", i+1)), i == 0)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.Nodes = append(c.Nodes, nd)
+		if i == 0 {
+			if err := waitFor(func() bool { return nd.node.Raft.State() == raft.Leader }); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("clienttest: %s did not become leader: %w", nd.ID, err)
+			}
+			continue
+		}
+		fut := c.Nodes[0].node.Raft.AddVoter(raft.ServerID(nd.ID), raft.ServerAddress(nd.RaftAddr), 0, 0)
+		if err := fut.Error(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("clienttest: add %s: %w", nd.ID, err)
+		}
+	}
+	if err := waitFor(c.settled); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("clienttest: cluster did not settle: %w", err)
+	}
+	return c, nil
+}
+
+func startNode(id, dir string, bootstrap bool) (*Node, error) {
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		httpLis.Close()
+		return nil, err
+	}
+	raftAddr, err := freeAddr()
+	if err != nil {
+		httpLis.Close()
+		grpcLis.Close()
+		return nil, err
+	}
+	httpPort := strconv.Itoa(httpLis.Addr().(*net.TCPAddr).Port)
+	node, err := raftnode.NewNode(raftnode.Config{DataDir: dir, BindAddr: raftAddr, ID: id, HTTPPort: httpPort, Bootstrap: bootstrap})
+	if err != nil {
+		httpLis.Close()
+		grpcLis.Close()
+		return nil, err
+	}
+	svc := service.New(node)
+	nd := &Node{
+		ID:       id,
+		RaftAddr: raftAddr,
+		HTTPAddr: httpLis.Addr().String(),
+		GRPCAddr: grpcLis.Addr().String(),
+		node:     node,
+		http:     &http.Server{Handler: httpapi.New(svc)},
+		grpc:     grpc.NewServer(),
+	}
+	svc.RegisterGRPC(nd.grpc)
+	go nd.grpc.Serve(grpcLis)
+	go nd.http.Serve(httpLis)
+	node.Advertise(raftnode.NodeMeta{ID: id, RaftAddr: raftAddr, HTTPAddr: nd.HTTPAddr, GRPCAddr: nd.GRPCAddr})
+	return nd, nil
+}
+
+// freeAddr picks a loopback address for Raft, whose transport has to be
+// given a fixed address up front.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// settled reports whether every running node sees the same leader and
+// knows the addresses of every member.
+func (c *Cluster) settled() bool {
+	var leader raft.ServerID
+	for _, nd := range c.Nodes {
+		if nd.stopped {
+			continue
+		}
+		_, id := nd.node.Raft.LeaderWithID()
+		if id == "" || (leader != "" && id != leader) {
+			return false
+		}
+		leader = id
+		for _, other := range c.Nodes {
+			if _, ok := nd.node.Member(other.ID); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Endpoints returns the HTTP addresses of the running nodes.
+func (c *Cluster) Endpoints() []string {
+	var eps []string
+	for _, nd := range c.Nodes {
+		if !nd.stopped {
+			eps = append(eps, nd.HTTPAddr)
+		}
+	}
+	return eps
+}
+
+// Leader returns the running node that is currently the leader, or nil.
+func (c *Cluster) Leader() *Node {
+	for _, nd := range c.Nodes {
+		if !nd.stopped && nd.node.Raft.State() == raft.Leader {
+			return nd
+		}
+	}
+	return nil
+}
+
+// WaitLeader waits until a running node is the leader and every other
+// running node follows it.
+func (c *Cluster) WaitLeader() (*Node, error) {
+	if err := waitFor(c.settled); err != nil {
+		return nil, err
+	}
+	return c.Leader(), nil
+}
+
+// Stop shuts one node down, as if it had crashed. Its data is kept but it
+// cannot be restarted.
+func (c *Cluster) Stop(nd *Node) error {
+	if nd.stopped {
+		return nil
+	}
+	nd.stopped = true
+	nd.grpc.Stop()
+	return errors.Join(nd.http.Close(), nd.node.Close())
+}
+
+// Close stops every node.
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, nd := range c.Nodes {
+		errs = append(errs, c.Stop(nd))
+	}
+	return errors.Join(errs...)
+}
+
+func waitFor(cond func() bool) error {
+	deadline := time.Now().Add(startTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v", startTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// Members lists the Raft configuration with each node's API addresses.
+func (c *Client) Members(ctx context.Context) ([]api.Member, error) {
+	var out api.MembersResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/cluster/members", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Members, nil
+}
+
+// NodeInfo describes the node at addr, an HTTP address, without going
+// through the leader.
+func (c *Client) NodeInfo(ctx context.Context, addr string) (*api.NodeInfoResponse, error) {
+	var out api.NodeInfoResponse
+	if err := c.callNode(ctx, addr, request{method: http.MethodGet, path: "/v1/cluster/node"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Status describes the node at addr, an HTTP address: its Raft state and
+// readiness, the cluster as it sees it and its store.
+func (c *Client) Status(ctx context.Context, addr string) (*api.StatusResponse, error) {
+	var out api.StatusResponse
+	if err := c.callNode(ctx, addr, request{method: http.MethodGet, path: "/v1/cluster/status"}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddPeer adds a voter with the given Raft ID and address, or promotes a
+// non-voter.
+func (c *Client) AddPeer(ctx context.Context, id, addr string) error {
+	req := api.AddPeerRequest{ID: id, Address: addr}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/cluster/add-peer", body: req, idempotent: true}, nil)
+}
+
+// AddNonVoter adds a member that replicates the log without voting, for
+// example to let a new node catch up before promoting it with AddPeer.
+func (c *Client) AddNonVoter(ctx context.Context, id, addr string) error {
+	req := api.AddPeerRequest{ID: id, Address: addr, NonVoter: true}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/cluster/add-peer", body: req, idempotent: true}, nil)
+}
+
+// RemovePeer removes a member from the Raft configuration. It fails with
+// ErrConflict when the voters left would not include a reachable quorum,
+// unless force is set.
+func (c *Client) RemovePeer(ctx context.Context, id string, force bool) error {
+	req := api.RemovePeerRequest{ID: id, Force: force}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/cluster/remove-peer", body: req}, nil)
+}
+
+// DemotePeer turns a voter into a non-voter, with the same quorum check as
+// RemovePeer.
+func (c *Client) DemotePeer(ctx context.Context, id string, force bool) error {
+	req := api.DemotePeerRequest{ID: id, Force: force}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/cluster/demote-peer", body: req}, nil)
+}
+
+// TransferLeader hands leadership to the voter id, or to any up-to-date
+// voter when id is empty. Unless force is set it refuses a voter the
+// leader cannot reach.
+func (c *Client) TransferLeader(ctx context.Context, id string, force bool) error {
+	req := api.TransferLeaderRequest{ID: id, Force: force}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/v1/cluster/transfer-leader", body: req}, nil); err != nil {
+		return err
+	}
+	c.forget("")
+	return nil
+}
+
+// Compact compacts the leader's data files.
+func (c *Client) Compact(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/admin/compact", idempotent: true}, nil)
+}
+
+// Snapshot takes a Raft snapshot on the leader, and truncates its log
+// behind it: entirely with truncate, up to the configured trailing entries
+// otherwise.
+func (c *Client) Snapshot(ctx context.Context, truncate bool) (*api.SnapshotResponse, error) {
+	var out api.SnapshotResponse
+	req := api.SnapshotRequest{Truncate: truncate}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/v1/admin/snapshot", body: req, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Snapshots lists the snapshots the node at addr, an HTTP address, keeps
+// on disk, newest first.
+func (c *Client) Snapshots(ctx context.Context, addr string) ([]api.Snapshot, error) {
+	var out api.SnapshotsResponse
+	if err := c.callNode(ctx, addr, request{method: http.MethodGet, path: "/v1/admin/snapshots"}, &out); err != nil {
+		return nil, err
+	}
+	return out.Snapshots, nil
+}
+
+// Backup streams a backup archive of the leader's state, a tar file, or
+// of the changes after index since when it is not zero. The caller closes
+// the archive.
+func (c *Client) Backup(ctx context.Context, since uint64) (io.ReadCloser, error) {
+	q := url.Values{}
+	if since > 0 {
+		q.Set("since", strconv.FormatUint(since, 10))
+	}
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/admin/backup", query: q, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Lease struct {
+	ID        uint64        `json:"id"`
+	TTL       time.Duration `json:"ttl"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	Keys      []string      `json:"keys,omitempty"`
+}
+
+// GrantLease creates a lease that expires after ttl unless it is kept
+// alive.
+func (c *Client) GrantLease(ctx context.Context, ttl time.Duration) (*Lease, error) {
+	req := struct {
+		TTL string `json:"ttl"`
+	}{ttl.String()}
+	var out Lease
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/lease/grant", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) KeepAliveLease(ctx context.Context, id uint64) (*Lease, error) {
+	var out Lease
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/lease/keepalive", body: leaseID{id}, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokeLease ends a lease and deletes the keys attached to it.
+func (c *Client) RevokeLease(ctx context.Context, id uint64) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/lease/revoke", body: leaseID{id}}, nil)
+}
+
+func (c *Client) Leases(ctx context.Context) ([]Lease, error) {
+	var out []Lease
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/leases", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type leaseID struct {
+	ID uint64 `json:"id"`
+}
+
+// Lock is a held lock. Token increases with every acquisition, so it can
+// fence writes made by an owner that lost the lock.
+type Lock struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+	Token uint64 `json:"token"`
+	Lease uint64 `json:"lease"`
+}
+
+// AcquireLock takes the lock name for ttl. It fails with ErrConflict while
+// another owner holds it.
+func (c *Client) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (*Lock, error) {
+	req := struct {
+		Name  string `json:"name"`
+		Owner string `json:"owner"`
+		TTL   string `json:"ttl"`
+	}{name, owner, ttl.String()}
+	var out Lock
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/lock/acquire", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ReleaseLock(ctx context.Context, name string, token uint64) error {
+	req := struct {
+		Name  string `json:"name"`
+		Token uint64 `json:"token"`
+	}{name, token}
+	return c.call(ctx, request{method: http.MethodPost, path: "/lock/release", body: req}, nil)
+}
+
+type QueueItem struct {
+	Seq        uint64    `json:"seq"`
+	Receipt    uint64    `json:"receipt,omitempty"`
+	Deadline   time.Time `json:"deadline,omitzero"`
+	Deliveries int       `json:"deliveries,omitempty"`
+	Value      string    `json:"value"`
+}
+
+type QueueStats struct {
+	Pending  uint64 `json:"pending"`
+	InFlight int    `json:"in_flight"`
+}
+
+// Enqueue appends val to queue and returns its sequence number.
+func (c *Client) Enqueue(ctx context.Context, queue, val string) (uint64, error) {
+	req := struct {
+		Queue string `json:"queue"`
+		Value string `json:"value"`
+	}{queue, val}
+	var out struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/queue/enqueue", body: req}, &out); err != nil {
+		return 0, err
+	}
+	return out.Seq, nil
+}
+
+// Dequeue hands out the oldest item of queue. Unless it is acknowledged
+// with its Receipt within visibility it is handed out again; zero removes
+// it at once. An empty queue fails with ErrNotFound.
+func (c *Client) Dequeue(ctx context.Context, queue string, visibility time.Duration) (*QueueItem, error) {
+	req := struct {
+		Queue      string `json:"queue"`
+		Visibility string `json:"visibility,omitempty"`
+	}{Queue: queue}
+	if visibility > 0 {
+		req.Visibility = visibility.String()
+	}
+	var out QueueItem
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/queue/dequeue", body: req}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Ack(ctx context.Context, queue string, receipt uint64) error {
+	req := struct {
+		Queue   string `json:"queue"`
+		Receipt uint64 `json:"receipt"`
+	}{queue, receipt}
+	return c.call(ctx, request{method: http.MethodPost, path: "/queue/ack", body: req, idempotent: true}, nil)
+}
+
+func (c *Client) Peek(ctx context.Context, queue string) (*QueueItem, error) {
+	var out QueueItem
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/queue/peek", query: url.Values{"queue": {queue}}, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) QueueLen(ctx context.Context, queue string) (*QueueStats, error) {
+	var out QueueStats
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/queue/len", query: url.Values{"queue": {queue}}, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IndexDef indexes the JSON values of the keys under Prefix by the field
+// at Path.
+type IndexDef struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Path   string `json:"path"`
+}
+
+func (c *Client) Indexes(ctx context.Context) ([]IndexDef, error) {
+	var out []IndexDef
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/index", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) DefineIndex(ctx context.Context, def IndexDef) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/index", body: def, idempotent: true}, nil)
+}
+
+func (c *Client) DropIndex(ctx context.Context, name string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: "/index", query: url.Values{"name": {name}}, idempotent: true}, nil)
+}
+
+// Query returns the keys whose indexed value lies in [from, to]. Empty
+// bounds are open.
+func (c *Client) Query(ctx context.Context, index, from, to string) ([]string, error) {
+	q := url.Values{"index": {index}}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out struct {
+		Keys []string `json:"keys"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/query", query: q, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Keys, nil
+}
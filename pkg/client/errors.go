@@ -0,0 +1,46 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// Errors returned by the client can be matched against these with
+// errors.Is. ErrNotFound covers every missing resource: keys, versions,
+// leases, locks, indexes and empty queues.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrKeyNotFound      = ErrNotFound
+	ErrInvalid          = errors.New("invalid request")
+	ErrConflict         = errors.New("conflict")
+	ErrNotLeader        = errors.New("not the leader")
+	ErrUnavailable      = errors.New("cluster unavailable")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnauthenticated  = errors.New("missing or unknown token")
+)
+
+var codeErrors = map[api.Code]error{
+	api.CodeNotFound:         ErrNotFound,
+	api.CodeInvalid:          ErrInvalid,
+	api.CodeConflict:         ErrConflict,
+	api.CodeNotLeader:        ErrNotLeader,
+	api.CodeUnavailable:      ErrUnavailable,
+	api.CodePermissionDenied: ErrPermissionDenied,
+	api.CodeUnauthenticated:  ErrUnauthenticated,
+}
+
+// Error is an error answered by a node.
+type Error struct {
+	Code    api.Code
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code.String() + ": " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return target != nil && codeErrors[e.Code] == target
+}
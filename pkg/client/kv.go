@@ -0,0 +1,256 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/AMS003010/Hyphora/pkg/api"
+)
+
+// Get returns the current value of key.
+func (c *Client) Get(ctx context.Context, key string) (*api.KeyValue, error) {
+	return c.GetVersion(ctx, key, 0)
+}
+
+// GetVersion returns key as it was at a Raft index, as far back as its
+// retention policy keeps versions.
+func (c *Client) GetVersion(ctx context.Context, key string, version uint64) (*api.KeyValue, error) {
+	q := url.Values{"key": {key}}
+	if version != 0 {
+		q.Set("version", strconv.FormatUint(version, 10))
+	}
+	var out api.GetResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/kv/get", query: q, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return &out.KeyValue, nil
+}
+
+func (c *Client) Put(ctx context.Context, key string, val []byte) error {
+	return c.PutWithLease(ctx, key, val, 0)
+}
+
+// PutWithLease writes key and attaches it to a lease, so it is deleted
+// when the lease expires or is revoked.
+func (c *Client) PutWithLease(ctx context.Context, key string, val []byte, lease uint64) error {
+	req := api.PutRequest{Key: key, Value: val, Lease: lease}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/kv/put", body: req, idempotent: true}, nil)
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	req := api.DeleteRequest{Key: key}
+	return c.call(ctx, request{method: http.MethodPost, path: "/v1/kv/delete", body: req, idempotent: true}, nil)
+}
+
+// Scan lists up to limit keys under prefix in order, starting after the
+// key after. more reports whether the listing was cut short; pass the last
+// returned key as after to continue.
+func (c *Client) Scan(ctx context.Context, prefix, after string, limit int) (items []api.KeyValue, more bool, err error) {
+	q := url.Values{"prefix": {prefix}}
+	if after != "" {
+		q.Set("after", after)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out api.ScanResponse
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/kv/scan", query: q, idempotent: true}, &out); err != nil {
+		return nil, false, err
+	}
+	return out.Items, out.More, nil
+}
+
+// Watcher receives the changes under a prefix as one node applies them.
+type Watcher struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+// Watch streams changes under prefix until ctx is cancelled or Close is
+// called. It is not resumed after the node goes away; Next then fails and
+// the caller decides how to catch up, for example with Scan.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/kv/watch", query: url.Values{"prefix": {prefix}}, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{body: resp.Body, dec: json.NewDecoder(bufio.NewReader(resp.Body))}, nil
+}
+
+// Next blocks until the next change. It returns io.EOF when the node ends
+// the stream.
+func (w *Watcher) Next() (*api.WatchEvent, error) {
+	var ev api.WatchEvent
+	if err := w.dec.Decode(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (w *Watcher) Close() error {
+	return w.body.Close()
+}
+
+// Incr adds delta to the integer stored at key, starting from zero, and
+// returns the new value. It is not retried once the request has reached a
+// node, so a failure may still have been applied.
+func (c *Client) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	req := struct {
+		Key   string `json:"key"`
+		Delta int64  `json:"delta"`
+	}{key, delta}
+	var out struct {
+		Value int64 `json:"value"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/incr", body: req}, &out); err != nil {
+		return 0, err
+	}
+	return out.Value, nil
+}
+
+// Compare holds when Key is at Version; version zero means the key does
+// not exist.
+type Compare struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// TxnOp is a "put", "del" or "get" run by a transaction.
+type TxnOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	Lease uint64 `json:"lease,omitempty"`
+}
+
+// Txn runs Success when every Compare holds and Failure otherwise.
+type Txn struct {
+	Compare []Compare `json:"compare"`
+	Success []TxnOp   `json:"success"`
+	Failure []TxnOp   `json:"failure,omitempty"`
+}
+
+type TxnResponse struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+	Found   bool   `json:"found"`
+}
+
+// TxnResult reports which branch ran. Conflicts lists the compared keys
+// whose versions did not match; Responses holds one entry per "get".
+type TxnResult struct {
+	Succeeded bool          `json:"succeeded"`
+	Conflicts []string      `json:"conflicts,omitempty"`
+	Responses []TxnResponse `json:"responses,omitempty"`
+	Index     uint64        `json:"index"`
+}
+
+// Txn runs t atomically. A failed comparison is not an error: the result
+// reports it with Succeeded false.
+func (c *Client) Txn(ctx context.Context, t Txn) (*TxnResult, error) {
+	resp, err := c.do(ctx, request{method: http.MethodPost, path: "/txn", body: t, accept: []int{http.StatusConflict}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusConflict && resp.Header.Get("Content-Type") != "application/json" {
+		return nil, readError(resp)
+	}
+	defer resp.Body.Close()
+	var out TxnResult
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Version describes one retained write of a key.
+type Version struct {
+	Index   uint64    `json:"index"`
+	Time    time.Time `json:"time"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+func (c *Client) History(ctx context.Context, key string) ([]Version, error) {
+	var out struct {
+		Versions []Version `json:"versions"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/history", query: url.Values{"key": {key}}, idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out.Versions, nil
+}
+
+// RetentionPolicy keeps up to MaxVersions old versions of the keys under
+// Prefix, for at most MaxAge. Zero values do not limit. MaxAge travels as
+// a duration string such as "1h30m".
+type RetentionPolicy struct {
+	Prefix      string
+	MaxVersions int
+	MaxAge      time.Duration
+}
+
+type retentionJSON struct {
+	Prefix      string `json:"prefix"`
+	MaxVersions int    `json:"max_versions"`
+	MaxAge      string `json:"max_age,omitempty"`
+}
+
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	out := retentionJSON{Prefix: p.Prefix, MaxVersions: p.MaxVersions}
+	if p.MaxAge > 0 {
+		out.MaxAge = p.MaxAge.String()
+	}
+	return json.Marshal(out)
+}
+
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	var in retentionJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*p = RetentionPolicy{Prefix: in.Prefix, MaxVersions: in.MaxVersions}
+	if in.MaxAge != "" {
+		d, err := time.ParseDuration(in.MaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid max_age: %w", err)
+		}
+		p.MaxAge = d
+	}
+	return nil
+}
+
+func (c *Client) RetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	var out []RetentionPolicy
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/retention", idempotent: true}, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) SetRetention(ctx context.Context, p RetentionPolicy) error {
+	return c.call(ctx, request{method: http.MethodPost, path: "/retention", body: p, idempotent: true}, nil)
+}
+
+// Replicate asks the node the client talks to, normally the leader, to
+// store a file from its own filesystem under the file's base name.
+func (c *Client) Replicate(ctx context.Context, path string) (key string, size int, err error) {
+	req := struct {
+		Path string `json:"path"`
+	}{path}
+	var out struct {
+		Key  string `json:"key"`
+		Size int    `json:"size"`
+	}
+	if err := c.call(ctx, request{method: http.MethodPost, path: "/replicate", body: req, idempotent: true}, &out); err != nil {
+		return "", 0, err
+	}
+	return out.Key, out.Size, nil
+}
@@ -0,0 +1,104 @@
+"""Exercise the memcached listener of a running cluster.
+
+Start three nodes with -memcached :11211, :11212 and :11213, join them, then
+run
+
+    python3 test/memcache_check.py 127.0.0.1:11211 127.0.0.1:11212 127.0.0.1:11213
+
+Writes go to every node in turn, so followers must proxy them to the leader.
+"""
+import socket
+import sys
+import time
+
+
+class Conn:
+    def __init__(self, addr):
+        host, port = addr.rsplit(":", 1)
+        self.sock = socket.create_connection((host, int(port)))
+        self.buf = self.sock.makefile("rb")
+
+    def line(self):
+        return self.buf.readline().rstrip(b"\r\n").decode()
+
+    def cmd(self, line, data=None):
+        out = line.encode() + b"\r\n"
+        if data is not None:
+            out += data + b"\r\n"
+        self.sock.sendall(out)
+        return self.line()
+
+    def store(self, cmd, key, data, flags=0, exptime=0, cas=None):
+        line = "%s %s %d %d %d" % (cmd, key, flags, exptime, len(data))
+        if cas is not None:
+            line += " %d" % cas
+        return self.cmd(line, data)
+
+    def gets(self, *keys):
+        self.sock.sendall(("gets %s\r\n" % " ".join(keys)).encode())
+        items = {}
+        while True:
+            head = self.line().split()
+            if head[0] == "END":
+                return items
+            _, key, flags, size, cas = head
+            items[key] = (self.buf.read(int(size) + 2)[:-2], int(flags), int(cas))
+
+
+def check(name, got, want):
+    if got != want:
+        sys.exit("FAIL %s: got %r, want %r" % (name, got, want))
+    print("ok  ", name)
+
+
+def eventually(name, fn, want, timeout=5):
+    deadline = time.time() + timeout
+    while True:
+        got = fn()
+        if got == want or time.time() > deadline:
+            return check(name, got, want)
+        time.sleep(0.1)
+
+
+def main(addrs):
+    conns = [Conn(a) for a in addrs]
+    first, last = conns[0], conns[-1]
+    for i, c in enumerate(conns):
+        check("set via node %d" % i, c.store("set", "mc:%d" % i, b"v%d" % i, flags=i), "STORED")
+    for i in range(len(conns)):
+        eventually("get on last node %d" % i,
+                   lambda: last.gets("mc:%d" % i).get("mc:%d" % i, (None, None))[:2], (b"v%d" % i, i))
+
+    check("add existing", last.store("add", "mc:0", b"x"), "NOT_STORED")
+    check("add new", last.store("add", "mc:add", b"x", flags=7), "STORED")
+    check("replace missing", last.store("replace", "mc:missing", b"x"), "NOT_STORED")
+    check("replace", last.store("replace", "mc:add", b"y", flags=8), "STORED")
+    eventually("replaced", lambda: first.gets("mc:add").get("mc:add", (None,))[:2], (b"y", 8))
+
+    _, _, cas = first.gets("mc:add")["mc:add"]
+    check("cas", last.store("cas", "mc:add", b"z", cas=cas), "STORED")
+    check("cas stale", last.store("cas", "mc:add", b"w", cas=cas), "EXISTS")
+    check("cas missing", last.store("cas", "mc:missing", b"w", cas=cas), "NOT_FOUND")
+
+    check("set counter", last.store("set", "mc:n", b"10", flags=3), "STORED")
+    check("incr", last.cmd("incr mc:n 5"), "15")
+    check("decr below zero", last.cmd("decr mc:n 100"), "0")
+    check("incr missing", last.cmd("incr mc:missing 1"), "NOT_FOUND")
+    check("incr non-numeric", last.cmd("incr mc:add 1").split()[0], "CLIENT_ERROR")
+    eventually("counter keeps flags", lambda: first.gets("mc:n").get("mc:n", (None,))[:2], (b"0", 3))
+
+    check("set with exptime", last.store("set", "mc:ttl", b"x", exptime=1), "STORED")
+    check("touch", last.cmd("touch mc:0 1"), "TOUCHED")
+    check("touch missing", last.cmd("touch mc:missing 1"), "NOT_FOUND")
+    eventually("expired", lambda: sorted(first.gets("mc:ttl", "mc:0")), [])
+
+    check("delete", last.cmd("delete mc:1"), "DELETED")
+    check("delete missing", last.cmd("delete mc:1"), "NOT_FOUND")
+    check("noreply", last.cmd("set mc:quiet 0 0 1 noreply\r\nq\r\nversion").split()[0], "VERSION")
+    print("all checks passed")
+
+
+if __name__ == "__main__":
+    if len(sys.argv) < 2:
+        sys.exit(__doc__)
+    main(sys.argv[1:])
@@ -0,0 +1,161 @@
+"""Recover a cluster that lost its quorum with hyphora-node recover.
+
+Starts three nodes of its own in a temporary directory, writes some keys,
+kills two of the nodes so the third cannot elect a leader, then recovers the
+survivor as a cluster of one and checks it leads again with every key. Build
+the node first and run
+
+    go build -o /tmp/hyphora-node ./cmd/hyphora-node
+    python3 test/recover_check.py /tmp/hyphora-node
+
+Ports 17001-17003, 18081-18083 and 19081-19083 must be free.
+"""
+import base64
+import json
+import os
+import shutil
+import signal
+import subprocess
+import sys
+import tempfile
+import time
+import urllib.error
+import urllib.request
+
+
+def check(name, got, want):
+    if got != want:
+        sys.exit("FAIL %s: got %r, want %r" % (name, got, want))
+    print("ok  ", name)
+
+
+def eventually(name, fn, want, timeout=15):
+    deadline = time.time() + timeout
+    while True:
+        try:
+            got = fn()
+        except (OSError, ValueError) as e:
+            got = e
+        if got == want or time.time() > deadline:
+            return check(name, got, want)
+        time.sleep(0.2)
+
+
+class Node:
+    def __init__(self, binary, root, n):
+        self.id = "node%d" % n
+        self.raft = "127.0.0.1:%d" % (17000 + n)
+        self.http = "127.0.0.1:%d" % (18080 + n)
+        self.args = [
+            "-id", self.id,
+            "-data-dir", os.path.join(root, self.id),
+            "-raft-addr", self.raft,
+            "-http-addr", self.http,
+            "-grpc-addr", "127.0.0.1:%d" % (19080 + n),
+        ]
+        self.binary, self.log, self.proc = binary, os.path.join(root, self.id + ".log"), None
+
+    def start(self, *extra):
+        with open(self.log, "a") as log:
+            self.proc = subprocess.Popen([self.binary, *self.args, *extra], stdout=log, stderr=log)
+
+    def stop(self, sig=signal.SIGTERM):
+        if self.proc and self.proc.poll() is None:
+            self.proc.send_signal(sig)
+            self.proc.wait(timeout=40)
+
+    def request(self, path, body=None, timeout=3):
+        data = json.dumps(body).encode() if body is not None else None
+        req = urllib.request.Request("http://%s%s" % (self.http, path), data=data,
+                                     headers={"Content-Type": "application/json"})
+        with urllib.request.urlopen(req, timeout=timeout) as resp:
+            return json.load(resp)
+
+    def status(self):
+        return self.request("/v1/cluster/status")
+
+    def put(self, key, value):
+        self.request("/v1/kv/put", {"key": key, "value": base64.b64encode(value.encode()).decode()})
+
+    def get(self, key):
+        kv = self.request("/v1/kv/get?key=" + key)
+        return base64.b64decode(kv["value"]).decode()
+
+    def members(self):
+        return sorted(m["id"] for m in self.status()["members"])
+
+
+def main():
+    if len(sys.argv) != 2:
+        sys.exit(__doc__)
+    binary = os.path.abspath(sys.argv[1])
+    root = tempfile.mkdtemp(prefix="hyphora-recover-")
+    nodes = [Node(binary, root, n) for n in (1, 2, 3)]
+    one, two, three = nodes
+    ok = False
+    try:
+        one.start()
+        eventually("node1 leads", lambda: one.status()["state"], "Leader")
+        for node in (two, three):
+            node.start("-join", one.http)
+        eventually("three members", one.members, ["node1", "node2", "node3"])
+
+        keys = {"k%d" % i: "v%d" % i for i in range(20)}
+        for k, v in keys.items():
+            one.put(k, v)
+        applied = int(one.status()["raft"]["applied_index"])
+        eventually("node3 replicated", lambda: int(three.status()["raft"]["applied_index"]) >= applied, True)
+
+        # Lose the majority, leader included.
+        one.stop(signal.SIGKILL)
+        two.stop(signal.SIGKILL)
+        time.sleep(3)
+        check("node3 has no leader", three.status().get("leader_id", ""), "")
+
+        def write_fails():
+            try:
+                three.put("lost", "x")
+            except (OSError, urllib.error.HTTPError):
+                return True
+            return False
+        check("writes fail without quorum", write_fails(), True)
+
+        peers = os.path.join(root, "peers.json")
+        with open(peers, "w") as f:
+            json.dump([{"id": three.id, "address": three.raft}], f)
+        running = subprocess.run([binary, "recover", peers, *three.args], capture_output=True, text=True)
+        check("recover refuses a running node", "still running" in running.stderr, True)
+        three.stop()
+
+        with open(peers, "w") as f:
+            json.dump([{"id": "node1", "address": one.raft}], f)
+        refused = subprocess.run([binary, "recover", peers, *three.args], capture_output=True, text=True)
+        check("recover refuses a configuration without the node", refused.returncode != 0, True)
+
+        with open(peers, "w") as f:
+            json.dump([{"id": three.id, "address": three.raft, "non_voter": False}], f)
+        out = subprocess.run([binary, "recover", peers, *three.args], capture_output=True, text=True)
+        if out.returncode != 0:
+            sys.exit("FAIL recover: %s" % out.stderr)
+        check("recover warns of data loss", "WARNING" in out.stderr, True)
+
+        three.start()
+        eventually("node3 leads alone", lambda: three.status()["state"], "Leader")
+        check("one member", three.members(), ["node3"])
+        for k, v in keys.items():
+            check("get " + k, three.get(k), v)
+        three.put("after", "recovery")
+        check("writes resume", three.get("after"), "recovery")
+        ok = True
+    finally:
+        for node in nodes:
+            node.stop(signal.SIGKILL)
+        if ok:
+            shutil.rmtree(root)
+        else:
+            print("logs and data left in", root)
+    print("PASS")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,120 @@
+"""Exercise the Redis protocol listener of a running cluster.
+
+Start three nodes with -redis :6381, :6382 and :6383, join them, then run
+
+    python3 test/resp_check.py 127.0.0.1:6381 127.0.0.1:6382 127.0.0.1:6383
+
+Writes go to every node in turn, so followers must proxy them to the leader.
+Needs no Redis server or client library.
+"""
+import socket
+import sys
+import time
+
+
+class Conn:
+    def __init__(self, addr):
+        host, port = addr.rsplit(":", 1)
+        self.sock = socket.create_connection((host, int(port)))
+        self.buf = self.sock.makefile("rb")
+
+    def call(self, *args):
+        out = b"*%d\r\n" % len(args)
+        for a in args:
+            a = a if isinstance(a, bytes) else str(a).encode()
+            out += b"$%d\r\n%s\r\n" % (len(a), a)
+        self.sock.sendall(out)
+        return self.read()
+
+    def read(self):
+        line = self.buf.readline().rstrip(b"\r\n")
+        kind, rest = line[:1], line[1:]
+        if kind == b"+":
+            return rest.decode()
+        if kind == b"-":
+            raise RuntimeError(rest.decode())
+        if kind == b":":
+            return int(rest)
+        if kind == b"_":
+            return None
+        if kind == b"$":
+            n = int(rest)
+            if n < 0:
+                return None
+            data = self.buf.read(n + 2)[:-2]
+            return data
+        if kind in (b"*", b"%"):
+            n = int(rest) * (2 if kind == b"%" else 1)
+            if n < 0:
+                return None
+            return [self.read() for _ in range(n)]
+        raise RuntimeError("unexpected reply %r" % line)
+
+
+def check(name, got, want):
+    if got != want:
+        sys.exit("FAIL %s: got %r, want %r" % (name, got, want))
+    print("ok  ", name)
+
+
+def eventually(name, fn, want, timeout=5):
+    deadline = time.time() + timeout
+    while True:
+        got = fn()
+        if got == want or time.time() > deadline:
+            return check(name, got, want)
+        time.sleep(0.1)
+
+
+def main(addrs):
+    conns = [Conn(a) for a in addrs]
+    first, last = conns[0], conns[-1]
+    for i, c in enumerate(conns):
+        check("ping %d" % i, c.call("PING"), "PONG")
+        check("set via node %d" % i, c.call("SET", "resp:%d" % i, "v%d" % i), "OK")
+    for i in range(len(conns)):
+        eventually("get on last node %d" % i, lambda: last.call("GET", "resp:%d" % i), b"v%d" % i)
+
+    check("hello 3", first.call("HELLO", "3")[4:6], [b"proto", 3])
+    check("resp3 null", first.call("GET", "resp:missing"), None)
+    check("set nx existing", last.call("SET", "resp:0", "x", "NX"), None)
+    check("set nx new", last.call("SET", "resp:nx", "x", "NX"), "OK")
+    check("set xx missing", last.call("SET", "resp:xx", "x", "XX"), None)
+    check("set get", last.call("SET", "resp:nx", "y", "GET"), b"x")
+    check("mset", last.call("MSET", "resp:a", "1", "resp:b", "2"), "OK")
+    eventually("mget", lambda: first.call("MGET", "resp:a", "resp:b", "resp:missing"), [b"1", b"2", None])
+    check("incr", last.call("INCR", "resp:a"), 2)
+    check("incrby", last.call("INCRBY", "resp:a", 10), 12)
+    check("decr", last.call("DECR", "resp:a"), 11)
+    try:
+        last.call("INCR", "resp:nx")
+        sys.exit("FAIL incr non-integer: no error")
+    except RuntimeError as e:
+        check("incr non-integer", str(e).split()[0], "ERR")
+    eventually("exists", lambda: first.call("EXISTS", "resp:a", "resp:b", "resp:missing"), 2)
+    eventually("keys", lambda: sorted(first.call("KEYS", "resp:[ab]")), [b"resp:a", b"resp:b"])
+
+    seen, cursor = set(), b"0"
+    while True:
+        cursor, keys = first.call("SCAN", cursor, "MATCH", "resp:*", "COUNT", 2)
+        seen.update(keys)
+        if cursor == b"0":
+            break
+    check("scan", {b"resp:a", b"resp:b", b"resp:nx"} <= seen, True)
+
+    check("ttl no expiry", last.call("TTL", "resp:a"), -1)
+    check("expire", last.call("EXPIRE", "resp:a", 2), 1)
+    eventually("ttl", lambda: first.call("TTL", "resp:a") in (1, 2), True)
+    check("set ex", last.call("SET", "resp:ex", "gone soon", "EX", 1), "OK")
+    check("persist", last.call("PERSIST", "resp:b"), 0)
+    eventually("expired", lambda: first.call("EXISTS", "resp:a", "resp:ex"), 0)
+
+    check("del", last.call("DEL", "resp:b", "resp:nx", "resp:missing"), 2)
+    eventually("del replicated", lambda: first.call("EXISTS", "resp:b", "resp:nx"), 0)
+    print("all checks passed")
+
+
+if __name__ == "__main__":
+    if len(sys.argv) < 2:
+        sys.exit(__doc__)
+    main(sys.argv[1:])